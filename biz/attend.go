@@ -0,0 +1,144 @@
+package biz
+
+/* ==================================
+Attendance counts only when the gm is sent within the window of the day
+gm before the window is rejected, after the window its either rejected or marked late as per the policy
+Managers can backfill the attendance for the days already past
+gm sent by mistake can be undone the same day before the adjustment, managers can undo it for any day
+====================================*/
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/kneerunjun/botmincock/dbadp"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	REGX_ATTEND_WINDOW = regexp.MustCompile(`^(?P<fh>[\d]{2}):(?P<fm>[\d]{2})-(?P<th>[\d]{2}):(?P<tm>[\d]{2})$`)
+)
+
+// AttendWindow : hours of the day in which the gm counts as attendance, as offsets from midnight
+type AttendWindow struct {
+	From time.Duration
+	To   time.Duration
+}
+
+// ParseAttendWindow : window as HH:MM-HH:MM, the start has to be before the end and both within the same day
+func ParseAttendWindow(s string) (*AttendWindow, error) {
+	matches := REGX_ATTEND_WINDOW.FindStringSubmatch(s)
+	if matches == nil {
+		return nil, fmt.Errorf("invalid attendance window %s, expected HH:MM-HH:MM", s)
+	}
+	offsets := []time.Duration{}
+	for i := 1; i < len(matches); i += 2 {
+		hr, _ := strconv.Atoi(matches[i])
+		min, _ := strconv.Atoi(matches[i+1])
+		if hr > 23 || min > 59 {
+			return nil, fmt.Errorf("invalid attendance window %s, time out of range", s)
+		}
+		offsets = append(offsets, time.Duration(hr)*time.Hour+time.Duration(min)*time.Minute)
+	}
+	if offsets[0] >= offsets[1] {
+		return nil, fmt.Errorf("invalid attendance window %s, start has to be before the end", s)
+	}
+	return &AttendWindow{From: offsets[0], To: offsets[1]}, nil
+}
+
+func (aw *AttendWindow) offset(t time.Time) time.Duration {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return t.Sub(midnight)
+}
+
+// Early : gm sent before the window opens for the day
+func (aw *AttendWindow) Early(t time.Time) bool {
+	return aw.offset(t) < aw.From
+}
+
+// Late : gm sent after the window has closed for the day
+func (aw *AttendWindow) Late(t time.Time) bool {
+	return aw.offset(t) > aw.To
+}
+
+func (aw *AttendWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", int(aw.From.Hours()), int(aw.From.Minutes())%60, int(aw.To.Hours()), int(aw.To.Minutes())%60)
+}
+
+// DayShare : playday debits and their adjustments on the day per attendee
+// this is what each one attending the day has paid, 0 when no one attended
+// Errors only when the query fails
+func DayShare(day time.Time, iadp dbadp.DbAdaptor) (float32, error) {
+	c, err := AttendedOn(day, iadp)
+	if err != nil || c == 0 {
+		return 0.0, err
+	}
+	from, to := DayAsBoundary(day)
+	trq := &TransacQ{From: from, To: to}
+	if err := TotalPlaydayDebits(trq, iadp); err != nil {
+		return 0.0, err
+	}
+	return trq.Debits / float32(c), nil
+}
+
+// DayAdjusted : true when the adjustment for the playdays of the day has been posted
+// Errors only when the query fails
+func DayAdjusted(day time.Time, iadp dbadp.DbAdaptor) (bool, error) {
+	errLoc := "DayAdjusted"
+	if iadp == nil {
+		return false, NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	from, to := DayAsBoundary(day)
+	count := 0
+	if err := iadp.GetCount(bson.M{"desc": ADJUST_DESC, "kind": poolOnly, "dttm": bson.M{"$gte": from, "$lte": to}}, &count); err != nil {
+		return false, NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the adjustments of the day"))
+	}
+	return count > 0, nil
+}
+
+// UndoPlayday : reverses the playday of the player on the day along with its adjustments
+// when the day is already adjusted, what the player had paid is spread evenly over the rest attending the day
+// so that the day still recovers what the adjustment had settled on
+// pu		: in/out param, send in the TelegID and the Day, get back what was reversed and spread
+// Errors when the player hasnt attended the day, the month is closed or the query fails
+func UndoPlayday(pu *PlaydayUndo, iadp dbadp.DbAdaptor) error {
+	errLoc := "UndoPlayday"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if err := AssertPeriodOpen(pu.Day, iadp); err != nil {
+		return err
+	}
+	from, to := DayAsBoundary(pu.Day)
+	playdays, err := PlaydayDebits(&TransacQ{TelegID: pu.TelegID, From: from, To: to}, iadp)
+	if err != nil {
+		return err
+	}
+	if len(playdays) == 0 {
+		return NewDomainError(ERR_TRANSAC404, nil).SetLoc(errLoc).SetUsrMsg(no_attendance(pu.TelegID, pu.Day))
+	}
+	adjusted, err := DayAdjusted(pu.Day, iadp)
+	if err != nil {
+		return err
+	}
+	pd := &Transac{Id: playdays[0].Id}
+	net, err := transacNet(pd.Id, iadp)
+	if err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the playday debits"))
+	}
+	if err := ReviseTransac(pd, 0.0, 0.0, iadp); err != nil {
+		return err
+	}
+	pu.Reversed, pu.Spread = net.Debits, 0.0
+	pu.Count, err = AttendedOn(pu.Day, iadp)
+	if err != nil {
+		return err
+	}
+	if !adjusted || pu.Count == 0 || pu.Reversed == 0.0 {
+		return nil // adjustment yet to run for the day accounts for the change, or no one left to spread it over
+	}
+	pu.Spread = pu.Reversed / float32(pu.Count)
+	return AdjustDayDebit(&TransacQ{From: from, To: to, Debits: pu.Spread}, iadp)
+}
@@ -0,0 +1,65 @@
+package biz
+
+/* ==================================
+Audit trail of all the commands executed and the state changes they have made
+Audit logs are only ever appended, they are never edited or removed
+====================================*/
+
+import (
+	"github.com/kneerunjun/botmincock/dbadp"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	AUDIT_OK      = "ok"
+	AUDIT_ERR     = "error"
+	MAX_AUDIT_QRY = 50 // max entries that can be queried from the audit trail in one go
+)
+
+// RecordAudit : appends the audit log to the trail
+// Errors when the log is nil or the query fails
+func RecordAudit(al *AuditLog, iadp dbadp.DbAdaptor) error {
+	errLoc := "RecordAudit"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if al == nil || al.Cmd == "" || al.DtTm.IsZero() {
+		return NewDomainError(ERR_INVLPARAM, nil).SetLoc(errLoc).SetUsrMsg(INVLD_PARAM)
+	}
+	if err := iadp.AddOne(al); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("recording the audit log")).SetLogEntry(log.Fields{
+			"cmd":     al.Cmd,
+			"telegid": al.TelegID,
+		})
+	}
+	return nil
+}
+
+// AuditTrail : gets the latest entries from the audit trail, latest first
+// aq		: in/out param, TelegID to filter for the account (0 for all), Limit for the number of entries, gets back the logs
+func AuditTrail(aq *AuditQ, iadp dbadp.DbAdaptor) error {
+	errLoc := "AuditTrail"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if aq.Limit <= 0 || aq.Limit > MAX_AUDIT_QRY {
+		aq.Limit = MAX_AUDIT_QRY
+	}
+	match := bson.M{}
+	if aq.TelegID != 0 {
+		match["tid"] = aq.TelegID
+	}
+	aq.Logs = []AuditLog{}
+	err := iadp.AggregateAll([]bson.M{
+		{"$match": match},
+		{"$sort": bson.M{"dttm": -1}},
+		{"$limit": aq.Limit},
+	}, &aq.Logs)
+	if err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the audit trail")).SetLogEntry(log.Fields{
+			"telegid": aq.TelegID,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package biz
+
+/* ==================================
+Monthly budget: expenses for a month are not all recorded at the start of the month
+Daily debits computed only on the recorded expenses under-charge early players and over-charge the later ones
+Budget is the projected cost of the month, set by admins or derived from last month and recurring expenses
+Daily debits recover the budget till the actual expenses exceed it, at month close the difference is trued-up
+====================================*/
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/kneerunjun/botmincock/dbadp"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// SetBudget : sets or revises the budget for the month
+// b		: month as YYYY-MM, amount and the admin setting it
+// iadp		: adaptor to the budgets collection
+// Errors when the month / amount is invalid, month is closed or the query fails
+func SetBudget(b *Budget, iadp dbadp.DbAdaptor) error {
+	errLoc := "SetBudget"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	month, err := ParsePeriod(b.Month)
+	if err != nil || b.Month == "" {
+		return NewDomainError(ERR_INVLPARAM, err).SetLoc(errLoc).SetUsrMsg(invalid_period(b.Month))
+	}
+	if b.INR <= float32(0.0) {
+		return NewDomainError(ERR_INVLPARAM, nil).SetLoc(errLoc).SetUsrMsg(invalid_budget(b.Month))
+	}
+	if err := AssertPeriodOpen(month, iadp); err != nil {
+		return err
+	}
+	count := 0
+	if err := iadp.GetCount(bson.M{"month": b.Month}, &count); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the budget"))
+	}
+	if count == 0 {
+		err = iadp.AddOne(b)
+	} else {
+		err = iadp.UpdateOne(bson.M{"month": b.Month}, bson.M{"inr": b.INR, "by": b.SetBy, "dttm": b.DtTm})
+	}
+	if err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("setting the budget")).SetLogEntry(log.Fields{
+			"month": b.Month,
+			"inr":   b.INR,
+		})
+	}
+	return nil
+}
+
+// MonthlyBudget : gets the budget and the actual expenses for the month
+// when admins havent set the budget, its the larger of last month's expenses and the recurring expenses
+// bq		: in/out param, send in the month as YYYY-MM
+// iadp		: adaptor to any collection, switches to budgets, expenses and recurexpenses
+func MonthlyBudget(bq *BudgetQ, iadp dbadp.DbAdaptor) error {
+	errLoc := "MonthlyBudget"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	month, err := ParsePeriod(bq.Month)
+	if err != nil {
+		return NewDomainError(ERR_INVLPARAM, err).SetLoc(errLoc).SetUsrMsg(invalid_period(bq.Month))
+	}
+	bq.Month = PeriodOf(month)
+	expenses := iadp.Switch("expenses")
+	actual := &MnthlyExpnsQry{Dttm: month}
+	if err := TeamMonthlyExpense(actual, expenses); err != nil {
+		return err
+	}
+	bq.Actual = actual.Total
+	found, err := iadp.Switch("budgets").GetOne(bson.M{"month": bq.Month}, reflect.TypeOf(&Budget{}))
+	if err == nil {
+		bq.Budget, bq.Derived = found.(*Budget).INR, false
+		return nil
+	}
+	if !errors.Is(err, mgo.ErrNotFound) {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the budget"))
+	}
+	// budget isnt set, deriving one
+	lastMonth := &MnthlyExpnsQry{Dttm: month.AddDate(0, -1, 0)}
+	if err := TeamMonthlyExpense(lastMonth, expenses); err != nil {
+		return err
+	}
+	recurring := struct {
+		Total float32 `bson:"total"`
+	}{}
+	err = iadp.Switch("recurexpenses").Aggregate([]bson.M{
+		{"$match": bson.M{"paused": false}},
+		{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$inr"}}},
+	}, &recurring)
+	if err != nil && !errors.Is(err, mgo.ErrNotFound) {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting recurring expenses"))
+	}
+	bq.Budget, bq.Derived = lastMonth.Total, true
+	if recurring.Total > bq.Budget {
+		bq.Budget = recurring.Total
+	}
+	return nil
+}
+
+// TrueUpMonth : settles the difference between what was recovered from the players and the actual expenses of the month
+// difference is distributed in proportion to what each player was debited, over recovery is a negative debit
+// all true-up transactions are dated on the last moment of the month, month has to be open
+// month	: YYYY-MM
+// iadp		: adaptor to any collection, switches to transacs and expenses
+// Sends back the true-up transactions appended, none when the month is settled or no one played
+func TrueUpMonth(month string, iadp dbadp.DbAdaptor) ([]Transac, error) {
+	errLoc := "TrueUpMonth"
+	if iadp == nil {
+		return nil, NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	dt, err := ParsePeriod(month)
+	if err != nil || month == "" {
+		return nil, NewDomainError(ERR_INVLPARAM, err).SetLoc(errLoc).SetUsrMsg(invalid_period(month))
+	}
+	from, to := MonthBoundaryOf(dt)
+	if err := AssertPeriodOpen(dt, iadp); err != nil {
+		return nil, err
+	}
+	actual := &MnthlyExpnsQry{Dttm: dt}
+	if err := TeamMonthlyExpense(actual, iadp.Switch("expenses")); err != nil {
+		return nil, err
+	}
+	transacs := iadp.Switch("transacs")
+	recovered := []struct {
+		TelegID int64   `bson:"_id"`
+		Debits  float32 `bson:"debits"`
+	}{}
+	err = transacs.AggregateAll([]bson.M{
+		{"$match": bson.M{"desc": bson.M{"$in": RECOVERY_DESCS}, "kind": poolOnly, "dttm": bson.M{"$gte": from, "$lte": to}}},
+		{"$group": bson.M{"_id": "$tid", "debits": bson.M{"$sum": "$debit"}}},
+		{"$match": bson.M{"debits": bson.M{"$gt": 0}}},
+	}, &recovered)
+	if err != nil {
+		return nil, NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting recoveries for the month"))
+	}
+	total := float32(0.0)
+	for _, r := range recovered {
+		total += r.Debits
+	}
+	diff := actual.Total - total
+	if total == 0.0 || math.Abs(float64(diff)) < 1.0 {
+		// no one played, or the month is already settled
+		return []Transac{}, nil
+	}
+	result := []Transac{}
+	now := time.Now()
+	for _, r := range recovered {
+		tr := Transac{Id: bson.NewObjectId(), TelegID: r.TelegID, Desc: TRUEUP_DESC, DtTm: to, Posted: now}
+		tr.Debit = float32(math.Round(float64(diff * r.Debits / total)))
+		if tr.Debit == 0.0 {
+			continue
+		}
+		if err := transacs.AddOne(&tr); err != nil {
+			return result, NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("truing up the month")).SetLogEntry(log.Fields{
+				"month":   month,
+				"telegid": r.TelegID,
+			})
+		}
+		result = append(result, tr)
+	}
+	return result, nil
+}
@@ -0,0 +1,163 @@
+package biz
+
+/* ==================================
+Expenses can be tagged with a category - court rent, shuttles, refreshments..
+Admins maintain the list of categories, when none is maintained DEFAULT_EXPNS_CTGRY is used
+list is seeded with the defaults when first written to
+====================================*/
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kneerunjun/botmincock/dbadp"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ExpenseCategories : gets the names of all the categories maintained, sorted by name
+// iadp		: adaptor to the expcategories collection
+// when none are maintained sends back DEFAULT_EXPNS_CTGRY
+func ExpenseCategories(iadp dbadp.DbAdaptor) ([]string, error) {
+	errLoc := "ExpenseCategories"
+	if iadp == nil {
+		return nil, NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	result := []ExpenseCategory{}
+	if err := iadp.AggregateAll([]bson.M{{"$sort": bson.M{"name": 1}}}, &result); err != nil {
+		return nil, NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting expense categories"))
+	}
+	if len(result) == 0 {
+		return DEFAULT_EXPNS_CTGRY, nil
+	}
+	names := []string{}
+	for _, c := range result {
+		names = append(names, c.Name)
+	}
+	return names, nil
+}
+
+// AssertExpenseCategory : checks the category is one of those maintained
+// empty category is valid since category is optional
+func AssertExpenseCategory(cat string, iadp dbadp.DbAdaptor) error {
+	errLoc := "AssertExpenseCategory"
+	if cat == "" {
+		return nil
+	}
+	valid, err := ExpenseCategories(iadp)
+	if err != nil {
+		return err
+	}
+	for _, v := range valid {
+		if v == cat {
+			return nil
+		}
+	}
+	return NewDomainError(ERR_EXPNSCTGRY, nil).SetLoc(errLoc).SetUsrMsg(invalid_category(cat, valid)).SetLogEntry(log.Fields{
+		"cat": cat,
+	})
+}
+
+// seedExpenseCategories : defaults are implicit only till the list is first written to
+// writing to an empty list adds the defaults first so that they arent lost, and can be removed like any other
+func seedExpenseCategories(by int64, iadp dbadp.DbAdaptor) error {
+	errLoc := "seedExpenseCategories"
+	count := 0
+	if err := iadp.GetCount(bson.M{}, &count); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting expense categories"))
+	}
+	if count > 0 {
+		return nil
+	}
+	for _, d := range DEFAULT_EXPNS_CTGRY {
+		if err := iadp.AddOne(&ExpenseCategory{Name: d, AddedBy: by, DtTm: time.Now()}); err != nil {
+			return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("adding expense category")).SetLogEntry(log.Fields{
+				"cat": d,
+			})
+		}
+	}
+	return nil
+}
+
+// AddExpenseCategory : adds a new category to the list
+// when the list is empty the default categories are added first so that they arent lost
+// Errors when the category name is invalid or the query fails, adding an existing category is not an error
+func AddExpenseCategory(ec *ExpenseCategory, iadp dbadp.DbAdaptor) error {
+	errLoc := "AddExpenseCategory"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	ec.Name = strings.ToLower(ec.Name)
+	if !REGX_EXPNS_CTGRY.MatchString(ec.Name) {
+		return NewDomainError(ERR_EXPNSCTGRY, nil).SetLoc(errLoc).SetUsrMsg(invalid_category(ec.Name, []string{"<lowercase letters only>"}))
+	}
+	if err := seedExpenseCategories(ec.AddedBy, iadp); err != nil {
+		return err
+	}
+	count := 0
+	if err := iadp.GetCount(bson.M{"name": ec.Name}, &count); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting expense categories"))
+	}
+	if count > 0 {
+		return nil
+	}
+	if err := iadp.AddOne(ec); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("adding expense category")).SetLogEntry(log.Fields{
+			"cat": ec.Name,
+		})
+	}
+	return nil
+}
+
+// RemoveExpenseCategory : removes the category from the list, expenses already tagged with it are left as is
+// defaults can be removed too, the list is seeded with them first
+// last category cannot be removed, an empty list is the defaults again
+// Errors when the category isnt maintained, is the last one or the query fails
+func RemoveExpenseCategory(ec *ExpenseCategory, iadp dbadp.DbAdaptor) error {
+	errLoc := "RemoveExpenseCategory"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	ec.Name = strings.ToLower(ec.Name)
+	if err := seedExpenseCategories(ec.AddedBy, iadp); err != nil {
+		return err
+	}
+	count := 0
+	if err := iadp.GetCount(bson.M{"name": bson.M{"$ne": ec.Name}}, &count); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting expense categories"))
+	}
+	if count == 0 {
+		return NewDomainError(ERR_EXPNSCTGRY, nil).SetLoc(errLoc).SetUsrMsg(last_category(ec.Name))
+	}
+	if err := iadp.RemoveOne(bson.M{"name": ec.Name}); err != nil {
+		if errors.Is(err, mgo.ErrNotFound) {
+			valid, _ := ExpenseCategories(iadp)
+			return NewDomainError(ERR_EXPNSCTGRY, err).SetLoc(errLoc).SetUsrMsg(invalid_category(ec.Name, valid))
+		}
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("removing expense category"))
+	}
+	return nil
+}
+
+// ExpensesByCategory : break down of the expenses for the month by category
+// ceq		: in/out param, send in any date in the month and get back the totals by category
+func ExpensesByCategory(ceq *CtgryExpnsQry, iadp dbadp.DbAdaptor) error {
+	errLoc := "ExpensesByCategory"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	from, to := MonthBoundaryOf(ceq.Dttm)
+	err := iadp.AggregateAll([]bson.M{
+		{"$match": bson.M{"dttm": bson.M{"$gte": from, "$lte": to}, "status": bson.M{"$nin": EXPNS_UNAPPROVED}}},
+		{"$group": bson.M{"_id": bson.M{"$ifNull": []interface{}{"$cat", ""}}, "total": bson.M{"$sum": "$inr"}}},
+		{"$sort": bson.M{"total": -1}},
+	}, &ceq.Totals)
+	if err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(FAIL_QRY_EXPNS).SetLogEntry(log.Fields{
+			"dt": ceq.Dttm,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package biz
+
+/* ==================================
+Cost mode decides how the cost of the month is shared by the group
+estimates	: each playday is debited as per the share of the player estimates, the adjustment recovers the deficit from the attendees
+attendance	: cost of the day is split evenly among the attendees, playdays are only marked and the adjustment posts the debits
+Groups that havent chosen a mode share by the estimates
+====================================*/
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/kneerunjun/botmincock/dbadp"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ValidCostMode : true when the mode is one of the known cost modes
+func ValidCostMode(mode string) bool {
+	return mode == COSTMODE_ESTIMATES || mode == COSTMODE_ATTENDANCE
+}
+
+// SetCostMode : sets the cost mode for the group, replaces the earlier mode if any
+// cm		: group id, mode and the account setting it
+// Errors when the mode is unknown or the query fails
+func SetCostMode(cm *CostMode, iadp dbadp.DbAdaptor) error {
+	errLoc := "SetCostMode"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if !ValidCostMode(cm.Mode) {
+		return NewDomainError(ERR_INVLPARAM, nil).SetLoc(errLoc).SetUsrMsg(invalid_costmode(cm.Mode))
+	}
+	count := 0
+	if err := iadp.GetCount(bson.M{"grpid": cm.GrpID}, &count); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the cost mode"))
+	}
+	var err error
+	if count == 0 {
+		err = iadp.AddOne(cm)
+	} else {
+		err = iadp.UpdateOne(bson.M{"grpid": cm.GrpID}, bson.M{"mode": cm.Mode, "by": cm.SetBy, "dttm": cm.DtTm})
+	}
+	if err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("setting the cost mode")).SetLogEntry(log.Fields{
+			"grpid": cm.GrpID,
+			"mode":  cm.Mode,
+		})
+	}
+	return nil
+}
+
+// CostModeOf : gets the cost mode of the group
+// cm		: in/out param, send in the group id, gets back the mode
+// groups that havent set the mode get COSTMODE_ESTIMATES, errors only when the query fails
+func CostModeOf(cm *CostMode, iadp dbadp.DbAdaptor) error {
+	errLoc := "CostModeOf"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if err := iadp.Aggregate([]bson.M{{"$match": bson.M{"grpid": cm.GrpID}}}, cm); err != nil {
+		if errors.Is(err, mgo.ErrNotFound) {
+			cm.Mode = COSTMODE_ESTIMATES
+			return nil
+		}
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the cost mode"))
+	}
+	return nil
+}
+
+// AttendanceDayCost : in the attendance mode, cost of the day to be split among the attendees
+// cost of the month yet to be recovered spread over the days left in the month including today
+// NOTE: this deviates from a flat monthly cost / days in the month, its the same only on the first day of the month
+// days no one attended are thus recovered over the days ahead, instead of the month falling short
+// recoveries are only till yesterday, debits and adjustments of today do not alter the cost of today
+// iadp		: adaptor to any collection, switches to transacs, budgets, expenses and recurexpenses
+// day		: day for which the cost is sought
+func AttendanceDayCost(day time.Time, iadp dbadp.DbAdaptor) (float32, error) {
+	bq := &BudgetQ{Month: PeriodOf(day)}
+	if err := MonthlyBudget(bq, iadp); err != nil {
+		return 0.0, err
+	}
+	var recovery float32
+	if err := RecoveryBefore(day, iadp.Switch("transacs"), &recovery); err != nil {
+		return 0.0, err
+	}
+	due := bq.Cost() - recovery
+	if due <= 0.0 {
+		return 0.0, nil // cost of the month already recovered
+	}
+	return float32(math.Round(float64(due / float32(DaysBeforeMonthEndOf(day))))), nil
+}
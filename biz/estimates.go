@@ -5,26 +5,73 @@ package biz
 > Estimates for each month let the bot decide on how to equitably divide the expenses for each day in the month
 > Estimates also help the bot to arrive at the recovery deficit for each day when a player who has promised to play does not play
 > Estimates once given can be changed, but only the debits ahead of the change will be affected
+> each change is a version effective from the day of change, the version in force on the day prices the debit for the day
 ================= */
 
 import (
-	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/kneerunjun/botmincock/dbadp"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
+// effectiveFrom : day the estimate set now comes into force
+// within the month its today, before the month its the start of the month, after the month its the last day
+func effectiveFrom(month, now time.Time) time.Time {
+	from, to := MonthBoundaryOf(month)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, from.Location())
+	if today.Before(from) {
+		return from
+	} else if today.After(to) {
+		return time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, to.Location())
+	}
+	return today
+}
+
+// estimateVersions : all versions of the estimates in the month of the date, oldest first
+// match	: additional filter, ex: for a single player
+func estimateVersions(match bson.M, month time.Time, iadp dbadp.DbAdaptor) ([]Estimate, error) {
+	from, to := MonthBoundaryOf(month)
+	match["dttm"] = bson.M{"$gte": from, "$lte": to}
+	result := []Estimate{}
+	if err := iadp.AggregateAll([]bson.M{{"$match": match}, {"$sort": bson.M{"eff": 1, "_id": 1}}}, &result); err != nil {
+		return nil, err
+	}
+	for i := range result {
+		if result[i].Eff.IsZero() {
+			result[i].Eff = from
+		}
+	}
+	return result, nil
+}
+
+// inForce : version of the estimate in force for each player on the day
+// versions are expected oldest first
+func inForce(versions []Estimate, on time.Time) map[int64]Estimate {
+	dayEnd := time.Date(on.Year(), on.Month(), on.Day(), 23, 59, 59, 0, on.Location())
+	result := map[int64]Estimate{}
+	for _, v := range versions {
+		if !v.Eff.After(dayEnd) {
+			result[v.TelegID] = v // later versions override the earlier
+		}
+	}
+	return result
+}
+
 // UpsertEstimate 	: Inserts or updates an estimate for the player only for the given month
-// incase the estimate is already added - the estimate is updated
+// est.DtTm is any date in the month of the estimate, zero time is the current month
+// estimate changed within the month is added as a new version effective from today, debits on days before the change are priced by the earlier version
+// estimate changed again on the same day, or before the month has started is updated
 // incase the playdays are invalid - error
 // Incase the db gateway fails - error
 func UpsertEstimate(est *Estimate, iadp dbadp.DbAdaptor) error {
 	errLoc := "UpsertEstimate"
-	est.DtTm = time.Now() // since the estimate is always for the current month only
+	if est.DtTm.IsZero() {
+		est.DtTm = time.Now()
+	}
 	// checking to see if the estimate has 0 <= plydays >= max monthly days
 	if 0 > est.PlyDys || daysInMonth(est.DtTm.Month(), est.DtTm.Year()) < est.PlyDys {
 		// invalid number of play days this needs to send back an error
@@ -33,107 +80,142 @@ func UpsertEstimate(est *Estimate, iadp dbadp.DbAdaptor) error {
 			"tid":      est.TelegID,
 		})
 	}
-	// If no record found for the player we add a new estimate
-	days, err := PlayerPlayDays(est.TelegID, iadp)
-	if err != nil { // cannot be the case when result.Total  ==0
-		if days == 0 {
-			if err := iadp.AddOne(est); err != nil {
-				return NewDomainError(fmt.Errorf("failed UpsertEstimate"), err).SetLoc(errLoc).SetUsrMsg(failed_query("adding the estimates"))
-			}
-			return nil
-		}
+	if err := AssertPeriodOpen(est.DtTm, iadp); err != nil {
 		return err
 	}
-	// If record found for the player, we update the estimate
-	from, to := MonthAsBoundary()
-	selectPlayrEst := bson.M{
-		"dttm": bson.M{
-			"$gte": from,
-			"$lte": to,
-		},
-		"tid": est.TelegID,
-	} // for the current month this can select the player estimate
-	// If there werent an error we just update
-	if err := iadp.UpdateOne(selectPlayrEst, bson.M{"plydys": est.PlyDys}); err != nil {
-		return NewDomainError(fmt.Errorf("failed UpsertEstimate"), err).SetLoc(errLoc).SetUsrMsg(failed_query("updating the estimates"))
+	est.Eff = effectiveFrom(est.DtTm, time.Now())
+	versions, err := estimateVersions(bson.M{"tid": est.TelegID}, est.DtTm, iadp)
+	if err != nil {
+		return NewDomainError(fmt.Errorf("failed UpsertEstimate"), err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the estimates"))
+	}
+	if len(versions) > 0 && versions[len(versions)-1].Eff.Equal(est.Eff) {
+		// version effective from the same day is updated
+		est.Id = versions[len(versions)-1].Id
+		if err := iadp.UpdateOne(bson.M{"_id": est.Id}, bson.M{"plydys": est.PlyDys, "eff": est.Eff}); err != nil {
+			return NewDomainError(fmt.Errorf("failed UpsertEstimate"), err).SetLoc(errLoc).SetUsrMsg(failed_query("updating the estimates"))
+		}
+		return nil
+	}
+	est.Id = bson.NewObjectId()
+	if err := iadp.AddOne(est); err != nil {
+		return NewDomainError(fmt.Errorf("failed UpsertEstimate"), err).SetLoc(errLoc).SetUsrMsg(failed_query("adding the estimates"))
 	}
 	return nil
 }
 
-// TotalPlayDays 	: for the given month the play day estimates are summed up, this is useful when getting the player contribution ratio
+// TotalPlayDays 	: for the current month the play day estimates in force today are summed up, this is useful when getting the player contribution ratio
 // 0, err 			: no records found, implies for the given month everyone has opeted out of play or no one answered the poll
 // -1, err			: error in getting records, gateway query failed.
 func TotalPlayDays(iadp dbadp.DbAdaptor) (int, error) {
+	return TotalPlayDaysOf(time.Now(), iadp)
+}
+
+// TotalPlayDaysOf : same as TotalPlayDays but with the estimates in force on the given day
+func TotalPlayDaysOf(on time.Time, iadp dbadp.DbAdaptor) (int, error) {
 	errLoc := "TotalPlayDays"
-	result := struct {
-		Total int `bson:"total"`
-	}{}
-	from, to := MonthAsBoundary()
-	err := iadp.Aggregate([]bson.M{
-		{"$match": bson.M{
-			"dttm": bson.M{
-				"$gte": from,
-				"$lte": to,
-			},
-		}},
-		{"$group": bson.M{
-			"_id": nil,
-			"total": bson.M{
-				"$sum": "$plydys",
-			},
-		}},
-		{"$project": bson.M{
-			"_id": 0,
-		}},
-	}, &result)
+	versions, err := estimateVersions(bson.M{}, on, iadp)
 	if err != nil {
-		if errors.Is(err, mgo.ErrNotFound) {
-			return 0, NewDomainError(fmt.Errorf("zero TotalPlayDays"), nil).SetLoc(errLoc).SetUsrMsg(zero_playdays())
-		}
-		return -1, NewDomainError(fmt.Errorf("failed TotalPlayDays"), nil).SetLoc(errLoc).SetUsrMsg(failed_query("getting the total monthly playdays"))
+		return -1, NewDomainError(fmt.Errorf("failed TotalPlayDays"), err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the total monthly playdays"))
 	}
-	if result.Total == 0 {
-		// when everyone has opted out of play
+	total := 0
+	for _, v := range inForce(versions, on) {
+		total += v.PlyDys
+	}
+	if total == 0 {
+		// when everyone has opted out of play, or no one answered the poll
 		return 0, NewDomainError(fmt.Errorf("zero TotalPlayDays"), nil).SetLoc(errLoc).SetUsrMsg(zero_playdays())
 	}
-	return result.Total, nil
+	return total, nil
 }
 
 // PlayerShare : for any player that has indicated his efforts estimate, this will get share of his contribution for a given month
 // 0, err 			: no records found, implies the player has not answered the poll
 // -1, err			: error in getting records, gateway query failed.
 func PlayerPlayDays(tID int64, iadp dbadp.DbAdaptor) (int, error) {
+	return PlayerPlayDaysOf(tID, time.Now(), iadp)
+}
+
+// PlayerPlayDaysOf : same as PlayerPlayDays but with the estimate in force on the given day
+func PlayerPlayDaysOf(tID int64, on time.Time, iadp dbadp.DbAdaptor) (int, error) {
 	errLoc := "PlayerPlayDays"
-	result := struct {
-		Total int `bson:"total"`
-	}{}
-	from, to := MonthAsBoundary()
-	err := iadp.Aggregate([]bson.M{
-		{"$match": bson.M{
-			"dttm": bson.M{
-				"$gte": from,
-				"$lte": to,
-			},
-			"tid": tID, // this will get us only the player playdays
-		}},
-		{"$group": bson.M{
-			"_id": nil,
-			"total": bson.M{
-				"$sum": "$plydys",
-			},
-		}},
-		{"$project": bson.M{
-			"_id": 0,
-		}},
-	}, &result)
+	versions, err := estimateVersions(bson.M{"tid": tID}, on, iadp)
 	if err != nil {
-		if errors.Is(err, mgo.ErrNotFound) {
-			return 0, NewDomainError(ERR_NOPLAYERESTM, nil).SetLoc(errLoc).SetUsrMsg(zero_playdays())
-		}
-		return -1, NewDomainError(fmt.Errorf("failed PlayerPlayDays"), nil).SetLoc(errLoc).SetUsrMsg(failed_query("getting the total monthly playdays"))
+		return -1, NewDomainError(fmt.Errorf("failed PlayerPlayDays"), err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the total monthly playdays"))
 	}
-	if result.Total == 0 {
+	est, ok := inForce(versions, on)[tID]
+	if !ok || est.PlyDys == 0 {
 		return 0, NewDomainError(ERR_NOPLAYERESTM, nil).SetLoc(errLoc).SetUsrMsg(zero_playdays())
 	}
-	return result.Total, nil
+	return est.PlyDys, nil
+}
+
+// PlayerShareHistory : versions of the player estimate in the month, with the total playdays of everyone when each came into force
+// sq		: in/out param, send in the TelegID and Month, get back the versions
+// Errors when the month is invalid, the query fails or the player has no estimate for the month
+func PlayerShareHistory(sq *ShareQ, iadp dbadp.DbAdaptor) error {
+	errLoc := "PlayerShareHistory"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	month, err := ParsePeriod(sq.Month)
+	if err != nil {
+		return NewDomainError(ERR_INVLPARAM, err).SetLoc(errLoc).SetUsrMsg(invalid_period(sq.Month))
+	}
+	sq.Month = PeriodOf(month)
+	versions, err := estimateVersions(bson.M{}, month, iadp)
+	if err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the estimates"))
+	}
+	sq.Versions = []ShareVersion{}
+	for _, v := range versions {
+		if v.TelegID != sq.TelegID {
+			continue
+		}
+		// others changing their estimates also change the share, but only own changes are listed
+		total := 0
+		for _, e := range inForce(versions, v.Eff) {
+			total += e.PlyDys
+		}
+		sq.Versions = append(sq.Versions, ShareVersion{Eff: v.Eff, PlyDys: v.PlyDys, Total: total})
+	}
+	if len(sq.Versions) == 0 {
+		return NewDomainError(ERR_NOPLAYERESTM, nil).SetLoc(errLoc).SetUsrMsg(zero_playdays())
+	}
+	return nil
+}
+
+// MonthEstimates : estimates of all the players for the month, most days first
+// eq		: in/out param, send in the month get back the estimates and the total from TotalPlayDaysOf
+// Errors when the month is invalid or the query fails, no estimates for the month isnt an error
+func MonthEstimates(eq *EstimatesQ, iadp dbadp.DbAdaptor) error {
+	errLoc := "MonthEstimates"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	month, err := ParsePeriod(eq.Month)
+	if err != nil {
+		return NewDomainError(ERR_INVLPARAM, err).SetLoc(errLoc).SetUsrMsg(invalid_period(eq.Month))
+	}
+	eq.Month = PeriodOf(month)
+	versions, err := estimateVersions(bson.M{}, month, iadp)
+	if err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the estimates"))
+	}
+	// estimates in force today, or on the last day for a month thats past
+	on := effectiveFrom(month, time.Now())
+	eq.Estimates = []Estimate{}
+	for _, v := range inForce(versions, on) {
+		eq.Estimates = append(eq.Estimates, v)
+	}
+	sort.Slice(eq.Estimates, func(i, j int) bool {
+		if eq.Estimates[i].PlyDys != eq.Estimates[j].PlyDys {
+			return eq.Estimates[i].PlyDys > eq.Estimates[j].PlyDys
+		}
+		return eq.Estimates[i].TelegID < eq.Estimates[j].TelegID
+	})
+	eq.Total, err = TotalPlayDaysOf(on, iadp)
+	if err != nil && eq.Total < 0 {
+		return err
+	} // zero playdays is an error for the debits, but not for the list
+	return nil
 }
@@ -3,6 +3,7 @@ package biz
 import (
 	"errors"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/kneerunjun/botmincock/dbadp"
@@ -16,13 +17,9 @@ import (
 func TeamMonthlyExpense(ue *MnthlyExpnsQry, iadp dbadp.DbAdaptor) error {
 	errLoc := "TeamMonthlyExpense"
 	temp := ue.Dttm
-	yr := temp.Year()
-	mn := temp.Month()
-	loc := temp.Location()
-	fromDt := time.Date(yr, mn, 1, 0, 0, 0, 0, loc)                 //start date for any month is 1
-	toDt := time.Date(yr, mn, daysInMonth(mn, yr), 0, 0, 0, 0, loc) // end date for a month needs some extra calc
+	fromDt, toDt := MonthBoundaryOf(temp) // whole of the month, till the end of the last day
 	pipe := []bson.M{
-		{"$match": bson.M{"dttm": bson.M{"$gte": fromDt, "$lte": toDt}}}, // only matching all the expenses for the month
+		{"$match": bson.M{"dttm": bson.M{"$gte": fromDt, "$lte": toDt}, "status": bson.M{"$nin": EXPNS_UNAPPROVED}}}, // only matching all the approved expenses for the month
 		{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$inr"}}},
 		{"$project": bson.M{"_id": 0}},
 	}
@@ -45,13 +42,9 @@ func TeamMonthlyExpense(ue *MnthlyExpnsQry, iadp dbadp.DbAdaptor) error {
 // ue		: in/out param, send in the id and the month for which expenses are expected, gets back with the aggregate of expenses
 func UserMonthlyExpense(ue *MnthlyExpnsQry, iadp dbadp.DbAdaptor) error {
 	temp := ue.Dttm
-	yr := temp.Year()
-	mn := temp.Month()
-	loc := temp.Location()
-	fromDt := time.Date(yr, mn, 1, 0, 0, 0, 0, loc)                 //start date for any month is 1
-	toDt := time.Date(yr, mn, daysInMonth(mn, yr), 0, 0, 0, 0, loc) // end date for a month needs some extra calc
+	fromDt, toDt := MonthBoundaryOf(temp) // whole of the month, till the end of the last day
 	pipe := []bson.M{
-		{"$match": bson.M{"tid": ue.TelegID, "dttm": bson.M{"$gte": fromDt, "$lte": toDt}}}, // specific user current month
+		{"$match": bson.M{"tid": ue.TelegID, "dttm": bson.M{"$gte": fromDt, "$lte": toDt}, "status": bson.M{"$nin": EXPNS_UNAPPROVED}}}, // specific user current month, approved only
 		{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$inr"}, "tid": bson.M{"$first": "$tid"}}},
 		{"$project": bson.M{"_id": 0}},
 	}
@@ -75,7 +68,8 @@ func UserMonthlyExpense(ue *MnthlyExpnsQry, iadp dbadp.DbAdaptor) error {
 // RecordExpense : recrods a new expense in the database
 // Expenses with default date time , and zero value invalid expenses
 // any user can record expenses and the telegram id of the sender is considered to be the one expending
-// recording expenses on behalf of another user is not possible
+// managers can record on behalf of another member, TelegID then is the member credited and AddedBy the manager
+// expenses sent in as EXPNS_PENDING get no credit till approved, see ReviewExpense
 func RecordExpense(exp *Expense, iadp dbadp.DbAdaptor) error {
 	errLoc := "RecordExpense"
 	if exp == nil {
@@ -90,6 +84,18 @@ func RecordExpense(exp *Expense, iadp dbadp.DbAdaptor) error {
 	}
 	// relational check to be done in the calling package not here
 	// like checkin if the account against which expense is added is not checked here
+	if err := AssertPeriodOpen(exp.DtTm, iadp); err != nil {
+		return err
+	}
+	exp.Cat = strings.ToLower(exp.Cat)
+	if err := AssertExpenseCategory(exp.Cat, iadp.Switch("expcategories")); err != nil {
+		return err
+	}
+	if exp.Status != EXPNS_PENDING {
+		exp.Status = EXPNS_APPROVED
+	}
+	// id for the expense is assigned before its added so that the credit can refer to it
+	exp.Id = bson.NewObjectId()
 	err := iadp.AddOne(exp)
 	if err != nil {
 		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(FAIL_QRY_EXPNS).SetLogEntry(log.Fields{
@@ -98,31 +104,186 @@ func RecordExpense(exp *Expense, iadp dbadp.DbAdaptor) error {
 			"telegid": exp.TelegID,
 		})
 	}
-	/*
-		Adds as a credit for the same account in the transactions as well
-	*/
-	transacs := iadp.Switch("transacs")
-	trnsc := &Transac{TelegID: exp.TelegID, Credit: exp.INR, Desc: exp.Desc, DtTm: exp.DtTm}
-	err = transacs.AddOne(trnsc)
-	if err != nil {
-		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(FAIL_QRY_EXPNS).SetLogEntry(log.Fields{
+	if exp.Status == EXPNS_PENDING {
+		return nil
+	}
+	return creditExpense(exp, iadp.Switch("transacs"))
+}
+
+// creditExpense : adds the approved expense as a credit for the same account in the transactions
+func creditExpense(exp *Expense, transacs dbadp.DbAdaptor) error {
+	trnsc := &Transac{Id: bson.NewObjectId(), TelegID: exp.TelegID, Credit: exp.INR, Desc: exp.Desc, DtTm: exp.DtTm, ExpId: exp.Id}
+	if err := transacs.AddOne(trnsc); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc("creditExpense").SetUsrMsg(FAIL_QRY_EXPNS).SetLogEntry(log.Fields{
 			"inr":     exp.INR,
 			"dt":      exp.DtTm,
 			"telegid": exp.TelegID,
 		})
 	}
-	// sending back the details of the newly added expense
-	// TODO: there has to be an id to identify the expense uniquly, else we would have to use 3 simulteneous fields to pick the expenses
-	// BUG: to uniquely identify the expense we need to add the date of the expense too..
-	newExp, err := iadp.GetOne(&Expense{TelegID: exp.TelegID, INR: exp.INR}, reflect.TypeOf(&Expense{}))
+	return nil
+}
+
+// ReviewExpense : approves / rejects an expense pending approval, approved expense is credited to the account
+// exp		: in/out param, id of the expense with the Status (approved / rejected) and ReviewedBy, gets back the reviewed expense
+// Errors when the expense isnt found, isnt pending, is the reviewer's own, month is closed or the query fails
+func ReviewExpense(exp *Expense, iadp dbadp.DbAdaptor) error {
+	errLoc := "ReviewExpense"
+	if exp == nil || (exp.Status != EXPNS_APPROVED && exp.Status != EXPNS_REJECTED) {
+		return NewDomainError(ERR_INVLPARAM, nil).SetLoc(errLoc).SetUsrMsg(INVL_EXPNS)
+	}
+	status, by := exp.Status, exp.ReviewedBy
+	if err := GetExpense(exp, iadp); err != nil {
+		return err
+	}
+	if exp.Status != EXPNS_PENDING {
+		return NewDomainError(ERR_NOTPENDING, nil).SetLoc(errLoc).SetUsrMsg(expense_reviewed(exp.Id.Hex(), exp.Status))
+	}
+	if exp.TelegID == by {
+		return NewDomainError(ERR_SELFREVIEW, nil).SetLoc(errLoc).SetUsrMsg(self_review("expense"))
+	}
+	if err := AssertPeriodOpen(exp.DtTm, iadp); err != nil {
+		return err
+	}
+	// selecting only the pending expense so that two managers approving together cannot credit twice
+	if err := iadp.UpdateOne(bson.M{"_id": exp.Id, "status": EXPNS_PENDING}, bson.M{"status": status, "reviewedby": by}); err != nil {
+		if errors.Is(err, mgo.ErrNotFound) {
+			return NewDomainError(ERR_NOTPENDING, err).SetLoc(errLoc).SetUsrMsg(expense_reviewed(exp.Id.Hex(), "reviewed"))
+		}
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(FAIL_QRY_EXPNS).SetLogEntry(log.Fields{
+			"id": exp.Id.Hex(),
+		})
+	}
+	exp.Status, exp.ReviewedBy = status, by
+	if status == EXPNS_REJECTED {
+		return nil
+	}
+	return creditExpense(exp, iadp.Switch("transacs"))
+}
+
+// GetExpense : gets the expense by its unique id
+// exp		: in/out param, send in the id of the expense and get back the expense details
+// Errors when the expense isnt found or the query fails
+func GetExpense(exp *Expense, iadp dbadp.DbAdaptor) error {
+	errLoc := "GetExpense"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if exp == nil || !exp.Id.Valid() {
+		return NewDomainError(ERR_INVLPARAM, nil).SetLoc(errLoc).SetUsrMsg(INVL_EXPNS)
+	}
+	found, err := iadp.GetOne(bson.M{"_id": exp.Id}, reflect.TypeOf(&Expense{}))
 	if err != nil {
+		if errors.Is(err, mgo.ErrNotFound) {
+			return NewDomainError(ERR_EXPNS404, err).SetLoc(errLoc).SetUsrMsg(expense_notfound(exp.Id.Hex()))
+		}
 		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(FAIL_GET_EXPNS).SetLogEntry(log.Fields{
-			"inr":     exp.INR,
-			"dt":      exp.DtTm,
-			"telegid": exp.TelegID,
+			"id": exp.Id.Hex(),
 		})
 	}
-	x, _ := newExp.(*Expense)
+	x, _ := found.(*Expense)
 	*exp = *x
 	return nil
 }
+
+// ExpenseReceipt : gets the expense by its id, but only when it has a receipt attached
+// exp		: in/out param, send in the id of the expense and get back the expense details with receipt
+// Errors when the expense isnt found or has no receipt
+func ExpenseReceipt(exp *Expense, iadp dbadp.DbAdaptor) error {
+	if err := GetExpense(exp, iadp); err != nil {
+		return err
+	}
+	if exp.Receipt == "" {
+		return NewDomainError(ERR_RCPT404, nil).SetLoc("ExpenseReceipt").SetUsrMsg(receipt_missing(exp.Id.Hex()))
+	}
+	return nil
+}
+
+// EditExpense : corrects the amount and the description of an expense already recorded
+// matching credit in the transactions is corrected as well, the expense as it was before is kept as a revision
+// exp		: in/out param, id of the expense with the corrected amount & description, gets back the edited expense
+// Errors when expense isnt found, the month of the expense is closed or the query fails
+func EditExpense(exp *Expense, iadp dbadp.DbAdaptor) error {
+	errLoc := "EditExpense"
+	if exp == nil || exp.INR <= float32(0.0) {
+		return NewDomainError(ERR_INVLPARAM, nil).SetLoc(errLoc).SetUsrMsg(INVL_EXPNS)
+	}
+	orig := &Expense{Id: exp.Id}
+	if err := GetExpense(orig, iadp); err != nil {
+		return err
+	}
+	if err := AssertPeriodOpen(orig.DtTm, iadp); err != nil {
+		return err
+	}
+	if err := appendExpenseRev(orig, EXPREV_EDIT, iadp.Switch("expenserevs")); err != nil {
+		return err
+	}
+	if err := iadp.UpdateOne(bson.M{"_id": orig.Id}, bson.M{"inr": exp.INR, "desc": exp.Desc}); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(FAIL_QRY_EXPNS).SetLogEntry(log.Fields{
+			"id":  orig.Id.Hex(),
+			"inr": exp.INR,
+		})
+	}
+	if err := reviseExpenseCredit(orig, exp.INR, iadp.Switch("transacs")); err != nil {
+		return err
+	}
+	orig.INR, orig.Desc = exp.INR, exp.Desc
+	*exp = *orig
+	return nil
+}
+
+// appendExpenseRev : keeps the expense as it is before it's edited / deleted
+// the revision is appended ahead of the change, if that fails the expense is left untouched
+func appendExpenseRev(prior *Expense, action string, revs dbadp.DbAdaptor) error {
+	errLoc := "appendExpenseRev"
+	rev := &ExpenseRev{Id: bson.NewObjectId(), ExpId: prior.Id, Prior: *prior, Action: action, Posted: time.Now()}
+	if err := revs.AddOne(rev); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(FAIL_QRY_EXPNS).SetLogEntry(log.Fields{
+			"id":     prior.Id.Hex(),
+			"action": action,
+		})
+	}
+	return nil
+}
+
+// DeleteExpense : removes an expense and reverses the matching credit in the transactions
+// the expense removed is kept as a revision
+// exp		: in/out param, id of the expense to remove, gets back the details of the expense removed
+// Errors when expense isnt found, the month of the expense is closed or the query fails
+func DeleteExpense(exp *Expense, iadp dbadp.DbAdaptor) error {
+	errLoc := "DeleteExpense"
+	if err := GetExpense(exp, iadp); err != nil {
+		return err
+	}
+	if err := AssertPeriodOpen(exp.DtTm, iadp); err != nil {
+		return err
+	}
+	if err := appendExpenseRev(exp, EXPREV_DELETE, iadp.Switch("expenserevs")); err != nil {
+		return err
+	}
+	if err := iadp.RemoveOne(bson.M{"_id": exp.Id}); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(FAIL_QRY_EXPNS).SetLogEntry(log.Fields{
+			"id": exp.Id.Hex(),
+		})
+	}
+	return reviseExpenseCredit(exp, 0.0, iadp.Switch("transacs"))
+}
+
+// reviseExpenseCredit : credit for the expense is never altered in place, a correction is appended that refers to the original credit
+// inr		: revised credit for the expense, 0 reverses the credit entirely
+func reviseExpenseCredit(exp *Expense, inr float32, transacs dbadp.DbAdaptor) error {
+	errLoc := "reviseExpenseCredit"
+	found, err := transacs.GetOne(bson.M{"expid": exp.Id, "ref": bson.M{"$exists": false}}, reflect.TypeOf(&Transac{}))
+	if err != nil {
+		if !errors.Is(err, mgo.ErrNotFound) {
+			return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(FAIL_QRY_EXPNS).SetLogEntry(log.Fields{
+				"id": exp.Id.Hex(),
+			})
+		}
+		// expenses recorded before the ids were introduced have no credit linked to it
+		log.WithFields(log.Fields{
+			"id": exp.Id.Hex(),
+		}).Warn("no credit linked to the expense, only the expense is altered")
+		return nil
+	}
+	return ReviseTransac(found.(*Transac), inr, 0.0, transacs)
+}
@@ -0,0 +1,74 @@
+package biz
+
+/* ==================================
+Scheduled jobs remember their runs here, the scheduler itself is in the sched package
+====================================*/
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/kneerunjun/botmincock/dbadp"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// JobRunOf : gets the run of the job by its name
+// jr		: in/out param, send in the name get back the run
+// Errors with ERR_JOB404 when the job was never scheduled
+func JobRunOf(jr *JobRun, iadp dbadp.DbAdaptor) error {
+	errLoc := "JobRunOf"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	found, err := iadp.GetOne(bson.M{"name": jr.Name}, reflect.TypeOf(&JobRun{}))
+	if err != nil {
+		if errors.Is(err, mgo.ErrNotFound) {
+			return NewDomainError(ERR_JOB404, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the job"))
+		}
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the job"))
+	}
+	*jr = *(found.(*JobRun))
+	return nil
+}
+
+// SaveJobRun : adds / updates the run of the job by its name
+func SaveJobRun(jr *JobRun, iadp dbadp.DbAdaptor) error {
+	errLoc := "SaveJobRun"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if jr.Name == "" {
+		return NewDomainError(ERR_INVLPARAM, nil).SetLoc(errLoc).SetUsrMsg(TRY_AGAIN)
+	}
+	count := 0
+	if err := iadp.GetCount(bson.M{"name": jr.Name}, &count); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the job"))
+	}
+	var err error
+	if count == 0 {
+		err = iadp.AddOne(jr)
+	} else {
+		err = iadp.UpdateOne(bson.M{"name": jr.Name}, bson.M{"spec": jr.Spec, "lastrun": jr.LastRun, "nextrun": jr.NextRun, "lasterr": jr.LastErr, "due": jr.Due})
+	}
+	if err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("saving the job")).SetLogEntry(log.Fields{
+			"job": jr.Name,
+		})
+	}
+	return nil
+}
+
+// JobRuns : runs of all the jobs, sorted by the next run
+func JobRuns(iadp dbadp.DbAdaptor) ([]JobRun, error) {
+	errLoc := "JobRuns"
+	if iadp == nil {
+		return nil, NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	result := []JobRun{}
+	if err := iadp.AggregateAll([]bson.M{{"$sort": bson.M{"nextrun": 1}}}, &result); err != nil {
+		return nil, NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the jobs"))
+	}
+	return result, nil
+}
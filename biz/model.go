@@ -2,7 +2,12 @@ package biz
 
 import (
 	"fmt"
+	"math"
+	"sort"
+	"strings"
 	"time"
+
+	"gopkg.in/mgo.v2/bson"
 )
 
 /* ==================================
@@ -46,6 +51,7 @@ type UserAccount struct {
 	TelegID  int64    `bson:"tid,omitempty" json:"tid"`         // telegram chat id for personal conversations, unique
 	Email    string   `bson:"email,omitempty" json:"email"`     // email of the user, for reports, unique
 	Name     string   `bson:"name,omitempty" json:"name"`       // name for addressing the user in any conversation, unique
+	UName    string   `bson:"uname,omitempty" json:"uname"`     // telegram @username without the @, lower case, not all users have one
 	Elevtn   *AccElev `bson:"elevtn,omitempty" json:"elevtn"`   // priveleges for the user account 0-user, 1-manager, 2-admin, from specified enumerated values only
 	Archived *bool    `bson:"archive,omitempty" json:"archive"` // default setting is false here
 }
@@ -62,11 +68,25 @@ func (ua *UserAccount) ToMsgTxt() string {
 // Transac :towards account maintenance - each row is a credit / debit attributed to the account
 // denormalized this has to be aggregated to see the balance of the account
 type Transac struct {
-	TelegID int64     `bson:"tid" json:"tid"`
-	Credit  float32   `bson:"credit" json:"credit"`
-	Debit   float32   `bson:"debit" json:"debit"`
-	Desc    string    `bson:"desc,omitempty" json:"desc"`
-	DtTm    time.Time `bson:"dttm" json:"dttm"`
+	Id      bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	TelegID int64         `bson:"tid" json:"tid"`
+	Credit  float32       `bson:"credit" json:"credit"`
+	Debit   float32       `bson:"debit" json:"debit"`
+	Desc    string        `bson:"desc,omitempty" json:"desc"`
+	DtTm    time.Time     `bson:"dttm" json:"dttm"`
+	ExpId   bson.ObjectId `bson:"expid,omitempty" json:"expid"` // when the transaction is the credit for an expense
+	// Transactions are never altered in place, corrections are appended as new transactions
+	// Ref points to the original transaction that is being corrected, Posted is when the correction was appended
+	// DtTm of the correction is the same as the original so that it nets within the same day / month
+	Ref    bson.ObjectId `bson:"ref,omitempty" json:"ref"`
+	Posted time.Time     `bson:"posted,omitempty" json:"posted"`
+	// transactions settled between members and not part of the shared monthly pool have a kind
+	// Batch groups all the transactions posted together, for a split its the id of the split
+	Kind  string        `bson:"kind,omitempty" json:"kind"`
+	Batch bson.ObjectId `bson:"batch,omitempty" json:"batch"`
+	// playdays marked after the attendance window closed, or backfilled by a manager
+	Late     bool  `bson:"late,omitempty" json:"late"`
+	MarkedBy int64 `bson:"by,omitempty" json:"by"`
 }
 
 func (t *Transac) ToMsgTxt() string {
@@ -84,6 +104,23 @@ type TransacQ struct {
 	To      time.Time
 }
 
+// PlaydayUndo : playday of the player reversed, and what was spread over the rest attending the day
+type PlaydayUndo struct {
+	TelegID  int64
+	Day      time.Time
+	Reversed float32 // playday debit and its adjustments reversed
+	Spread   float32 // added to each one still attending, when the day was already adjusted
+	Count    int     // attending the day after the undo
+}
+
+func (pu *PlaydayUndo) ToMsgTxt() string {
+	msg := fmt.Sprintf("Attendance of %d on %s undone, %.2f INR reversed", pu.TelegID, pu.Day.Format("02-Jan-2006"), pu.Reversed)
+	if pu.Spread != 0.0 {
+		msg = fmt.Sprintf("%s%%0A%.2f INR each added to the %d who played that day", msg, pu.Spread, pu.Count)
+	}
+	return msg
+}
+
 type Balance struct {
 	TelegID int64     `bson:"tid" json:"tid"`
 	Due     float32   `bson:"due"`
@@ -97,22 +134,298 @@ func (b *Balance) ToMsgTxt() string {
 // Start of each month the playdays are estimated from polls in the group
 // each month - each acccount the play days help you arrive at the share holding of the account in the monthly expenditure
 // Estimates are pivotal to calculating the monthly paybacks or dues
+// Estimates changed mid-month are versions effective from the day of change, earlier versions still price the days before
 type Estimate struct {
-	TelegID int64     `bson:"tid" json:"tid"`
-	PlyDys  int       `bson:"plydys" json:"plydys"`
-	DtTm    time.Time `bson:"dttm"`
+	Id      bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	TelegID int64         `bson:"tid" json:"tid"`
+	PlyDys  int           `bson:"plydys" json:"plydys"`
+	DtTm    time.Time     `bson:"dttm"`
+	Eff     time.Time     `bson:"eff,omitempty" json:"eff"` // effective from, estimates without this are effective from the start of the month
+}
+
+// ShareVersion : share of the player in the monthly cost, from the version of the estimate in force
+type ShareVersion struct {
+	Eff    time.Time // effective from
+	PlyDys int       // playdays of the player
+	Total  int       // total playdays of everyone on the day the version came into force
+}
+
+// ShareQ : how the share of the player changed during the month
+type ShareQ struct {
+	TelegID  int64
+	Month    string // YYYY-MM, empty for the current month
+	Versions []ShareVersion
+}
+
+func (sq *ShareQ) ToMsgTxt() string {
+	lines := []string{}
+	for _, v := range sq.Versions {
+		share := 0.0
+		if v.Total > 0 {
+			share = 100.0 * float64(v.PlyDys) / float64(v.Total)
+		}
+		lines = append(lines, fmt.Sprintf("from %s: %d/%d days, %.1f%%25", v.Eff.Format("02-Jan"), v.PlyDys, v.Total, share))
+	}
+	return fmt.Sprintf("Share of %d in %s%%0A%s", sq.TelegID, sq.Month, strings.Join(lines, "%0A"))
+}
+
+// EstimatesQ : everyone's estimates for the month and the total playdays
+type EstimatesQ struct {
+	Month     string // YYYY-MM, empty for the current month
+	Estimates []Estimate
+	Total     int
+}
+
+func (eq *EstimatesQ) ToMsgTxt() string {
+	lines := []string{}
+	for _, e := range eq.Estimates {
+		lines = append(lines, fmt.Sprintf("%d: %d days", e.TelegID, e.PlyDys))
+	}
+	return fmt.Sprintf("Estimates for %s%%0A%s%%0ATotal: %d days", eq.Month, strings.Join(lines, "%0A"), eq.Total)
 }
 
 // Any purchases on behalf of the bot as a manager by any account towards goods/services shared by the group is recorded as an expense
 type Expense struct {
-	TelegID int64     `bson:"tid,omitempty" json:"tid"`
-	DtTm    time.Time `bson:"dttm,omitempty" json:"dttm"`
-	Desc    string    `bson:"desc,omitempty" json:"desc"`
-	INR     float32   `bson:"inr,omitempty" json:"inr"`
+	Id      bson.ObjectId `bson:"_id,omitempty" json:"id"` // unique id of the expense, this is what the user refers to when editing
+	TelegID int64         `bson:"tid,omitempty" json:"tid"`
+	DtTm    time.Time     `bson:"dttm,omitempty" json:"dttm"`
+	Desc    string        `bson:"desc,omitempty" json:"desc"`
+	INR     float32       `bson:"inr,omitempty" json:"inr"`
+	Cat     string        `bson:"cat,omitempty" json:"cat"` // optional category of the expense, from the list admins maintain
+	// receipt for the expense as attached when recording the expense, this is the telegram file id
+	Receipt  string `bson:"receipt,omitempty" json:"receipt"`
+	RcptKind string `bson:"rcptkind,omitempty" json:"rcptkind"` // photo / document
+	// when a manager records the expense on behalf of the member, this is the manager's account
+	AddedBy int64 `bson:"addedby,omitempty" json:"addedby"`
+	// expenses above the approval limit are pending till a manager reviews them, no credit is added till approved
+	Status     string `bson:"status,omitempty" json:"status"`
+	ReviewedBy int64  `bson:"reviewedby,omitempty" json:"reviewedby"`
 }
 
 func (exp *Expense) ToMsgTxt() string {
-	return fmt.Sprintf("total expense %.2f for account %d", exp.INR, exp.TelegID)
+	status := ""
+	if exp.Status == EXPNS_PENDING || exp.Status == EXPNS_REJECTED {
+		status = fmt.Sprintf(" (%s)", exp.Status)
+	}
+	if exp.Cat != "" {
+		return fmt.Sprintf("total expense %.2f %%23%s for account %d%s%%0AExpense ID: %s", exp.INR, exp.Cat, exp.TelegID, status, exp.Id.Hex())
+	}
+	return fmt.Sprintf("total expense %.2f for account %d%s%%0AExpense ID: %s", exp.INR, exp.TelegID, status, exp.Id.Hex())
+}
+
+// ExpenseRev : version of an expense as it was before an edit / delete
+// revisions are only ever appended, the history of an expense is all its revisions in the order posted followed by the expense itself
+type ExpenseRev struct {
+	Id     bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	ExpId  bson.ObjectId `bson:"expid" json:"expid"`   // expense this is a revision of
+	Prior  Expense       `bson:"prior" json:"prior"`   // expense as it was before the change
+	Action string        `bson:"action" json:"action"` // EXPREV_EDIT / EXPREV_DELETE
+	Posted time.Time     `bson:"posted" json:"posted"` // when the change was made
+}
+
+// RecurringExpense : expense that repeats every month on the same day, court rent for example
+// admins define it once and the scheduler posts it as an Expense when its due
+type RecurringExpense struct {
+	Id         bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	TelegID    int64         `bson:"telegid" json:"telegid"` // account of the member who pays
+	INR        float32       `bson:"inr" json:"inr"`
+	Desc       string        `bson:"desc" json:"desc"`
+	Cat        string        `bson:"cat,omitempty" json:"cat"`
+	Day        int           `bson:"day" json:"day"` // day of the month the expense is due on, 1-MAX_RECUR_DAY
+	Paused     bool          `bson:"paused" json:"paused"`
+	AddedBy    int64         `bson:"by" json:"by"`
+	DtTm       time.Time     `bson:"dttm" json:"dttm"`
+	LastPosted string        `bson:"lastposted,omitempty" json:"lastposted"` // period in which it was last posted as an expense
+}
+
+func (re *RecurringExpense) ToMsgTxt() string {
+	status := ""
+	if re.Paused {
+		status = " (paused)"
+	}
+	return fmt.Sprintf("%.2f on day %d for account %d, %s%s%%0AID: %s", re.INR, re.Day, re.TelegID, re.Desc, status, re.Id.Hex())
+}
+
+// Split : ad-hoc expense paid by one member and shared by a few, tournament entry, team dinner..
+// payer is credited the entire amount and each member is debited their share, none of it counts towards the monthly pool
+type Split struct {
+	Id     bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	PaidBy int64         `bson:"paidby" json:"paidby"`
+	INR    float32       `bson:"inr" json:"inr"`
+	Desc   string        `bson:"desc" json:"desc"`
+	Shares []SplitShare  `bson:"shares" json:"shares"`
+	DtTm   time.Time     `bson:"dttm" json:"dttm"`
+}
+
+// SplitShare : member's share of the split, weight 1 is an equal share
+type SplitShare struct {
+	TelegID int64   `bson:"tid" json:"tid"`
+	Weight  int     `bson:"weight" json:"weight"`
+	INR     float32 `bson:"inr" json:"inr"`
+}
+
+// Apportion : computes the share of each member in proportion to the weights
+// shares are rounded down to the rupee, the rupees left over go one at a time to the members with the largest fractions
+// ties go to the members later in the list, paise left after that are added to the last member
+// no share is ever negative, negative weights or amounts leave all the shares at 0
+func (sp *Split) Apportion() {
+	total := 0
+	for _, s := range sp.Shares {
+		if s.Weight < 0 {
+			return
+		}
+		total += s.Weight
+	}
+	if total <= 0 || sp.INR < 0 || len(sp.Shares) == 0 {
+		return
+	}
+	fracs := make([]float64, len(sp.Shares))
+	order := make([]int, len(sp.Shares))
+	leftover := float64(sp.INR)
+	for i := range sp.Shares {
+		exact := float64(sp.INR) * float64(sp.Shares[i].Weight) / float64(total)
+		floor := math.Floor(exact)
+		sp.Shares[i].INR = float32(floor)
+		fracs[i], order[i] = exact-floor, i
+		leftover -= floor
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		if fracs[order[a]] == fracs[order[b]] {
+			return order[a] > order[b]
+		}
+		return fracs[order[a]] > fracs[order[b]]
+	})
+	for i := 0; leftover >= 1.0 && i < len(order); i++ {
+		sp.Shares[order[i]].INR += 1.0
+		leftover -= 1.0
+	}
+	if leftover > 0 {
+		sp.Shares[len(sp.Shares)-1].INR += float32(leftover)
+	}
+}
+
+func (sp *Split) ToMsgTxt() string {
+	lines := []string{}
+	for _, s := range sp.Shares {
+		lines = append(lines, fmt.Sprintf("%d: %.2f", s.TelegID, s.INR))
+	}
+	return fmt.Sprintf("%s %.2f paid by %d%%0A%s%%0ASplit ID: %s", sp.Desc, sp.INR, sp.PaidBy, strings.Join(lines, "%0A"), sp.Id.Hex())
+}
+
+// Transfer : money paid directly by one member to another
+// payer is credited and the payee debited, both transactions carry the Id as the batch
+type Transfer struct {
+	Id    bson.ObjectId
+	Payer int64
+	Payee int64
+	INR   float32
+	DtTm  time.Time
+}
+
+// Settlement : one payment that settles the debts, From pays To
+type Settlement struct {
+	From int64   `json:"from"`
+	To   int64   `json:"to"`
+	INR  float32 `json:"inr"`
+}
+
+// PendingPayment : payment towards dues as claimed by the member, credited only when a manager confirms it
+type PendingPayment struct {
+	Id      bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	TelegID int64         `bson:"tid" json:"tid"`
+	INR     float32       `bson:"inr" json:"inr"`
+	DtTm    time.Time     `bson:"dttm" json:"dttm"` // when the member paid
+	Status  string        `bson:"status" json:"status"`
+	// manager who confirmed / declined and when
+	ReviewedBy int64     `bson:"reviewedby,omitempty" json:"reviewedby"`
+	ReviewedOn time.Time `bson:"reviewedon,omitempty" json:"reviewedon"`
+	GwId       string    `bson:"gwid,omitempty" json:"gwid"` // payment id on the gateway, when paid over the gateway
+}
+
+func (pp *PendingPayment) ToMsgTxt() string {
+	if pp.TelegID == 0 {
+		return fmt.Sprintf("%.2f paid on the gateway on %s, member unknown (%s)%%0APayment ID: %s", pp.INR, pp.DtTm.Format("02-Jan 15:04"), pp.Status, pp.Id.Hex())
+	}
+	return fmt.Sprintf("%.2f paid by %d on %s (%s)%%0APayment ID: %s", pp.INR, pp.TelegID, pp.DtTm.Format("02-Jan 15:04"), pp.Status, pp.Id.Hex())
+}
+
+// JobRun : scheduled job as persisted, runs missed while the bot was down are caught up from this
+type JobRun struct {
+	Name    string    `bson:"name" json:"name"`
+	Spec    string    `bson:"spec" json:"spec"` // cron expression
+	LastRun time.Time `bson:"lastrun,omitempty" json:"lastrun"`
+	NextRun time.Time `bson:"nextrun,omitempty" json:"nextrun"`
+	LastErr string    `bson:"lasterr,omitempty" json:"lasterr"`
+	Due     time.Time `bson:"due,omitempty" json:"due"` // failed run being retried was due at this time
+}
+
+func (jr *JobRun) ToMsgTxt() string {
+	last := "never"
+	if !jr.LastRun.IsZero() {
+		last = jr.LastRun.Format("02-Jan 15:04")
+	}
+	txt := fmt.Sprintf("%s (%s)%%0Alast: %s, next: %s", jr.Name, jr.Spec, last, jr.NextRun.Format("02-Jan 15:04"))
+	if jr.LastErr != "" {
+		txt = fmt.Sprintf("%s%%0Afailed: %s", txt, jr.LastErr)
+	}
+	return txt
+}
+
+// PollOption : option on the estimates poll and the playdays it stands for
+type PollOption struct {
+	Text     string `bson:"text" json:"text"`
+	Playdays int    `bson:"plydys" json:"plydys"`
+}
+
+// SentPoll : estimates poll as sent on the group, answers to the poll are decoded with the options stored here
+type SentPoll struct {
+	PollId   string       `bson:"pollid" json:"pollid"` // id telegram assigns to the poll
+	Month    string       `bson:"month" json:"month"`   // YYYY-MM the poll asks about, estimates from the answers are for this month
+	Question string       `bson:"qs" json:"qs"`
+	Options  []PollOption `bson:"options" json:"options"`
+	DtTm     time.Time    `bson:"dttm" json:"dttm"` // when the poll was sent
+	// non-respondents are given default estimates once the deadline is past
+	Defaulted bool `bson:"defaulted,omitempty" json:"defaulted"`
+}
+
+// OptionTexts : just the text of the options in order, as sent on the poll
+func (sp *SentPoll) OptionTexts() []string {
+	texts := []string{}
+	for _, o := range sp.Options {
+		texts = append(texts, o.Text)
+	}
+	return texts
+}
+
+// ExpenseCategory : admins maintain a list of categories that expenses can be tagged with
+type ExpenseCategory struct {
+	Name    string    `bson:"name" json:"name"` // lower case, single word
+	AddedBy int64     `bson:"by" json:"by"`
+	DtTm    time.Time `bson:"dttm" json:"dttm"`
+}
+
+// CtgryExpnsQry : break down of the monthly expenses by category
+type CtgryExpnsQry struct {
+	Dttm   time.Time // any date in the month for which the break down is sought
+	Totals []struct {
+		Cat   string  `bson:"_id"`
+		Total float32 `bson:"total"`
+	}
+}
+
+func (ceq *CtgryExpnsQry) ToMsgTxt() string {
+	if len(ceq.Totals) == 0 {
+		return fmt.Sprintf("%c No expenses recorded for %s", EMOJI_warning, ceq.Dttm.Format("Jan 2006"))
+	}
+	txt := fmt.Sprintf("Expenses for %s by category", ceq.Dttm.Format("Jan 2006"))
+	for _, t := range ceq.Totals {
+		cat := t.Cat
+		if cat == "" {
+			cat = "uncategorised"
+		}
+		txt = fmt.Sprintf("%s%%0A%%23%s: %c%.2f", txt, cat, EMOJI_rupee, t.Total)
+	}
+	return txt
 }
 
 // MnthlyExpnsQry : when querying for the monthly expense aggregates this serves as the flywheel object
@@ -132,3 +445,103 @@ func (ume *MnthlyExpnsQry) ToMsgTxt() string {
 	}
 
 }
+
+// PeriodLock : a book keeping period (month) once closed is locked for writes
+// No transactions, expenses or estimates can then be recorded with a date inside the locked month
+type PeriodLock struct {
+	Month    string    `bson:"month" json:"month"` // YYYY-MM
+	LockedBy int64     `bson:"by" json:"by"`       // telegram id of the admin who locked the period
+	DtTm     time.Time `bson:"dttm" json:"dttm"`   // when the period was locked
+}
+
+func (pl *PeriodLock) ToMsgTxt() string {
+	return fmt.Sprintf("%c Books for %s are now closed", EMOJI_greentick, pl.Month)
+}
+
+// CostMode : how the cost of the month is shared in the group
+type CostMode struct {
+	GrpID int64     `bson:"grpid" json:"grpid"`
+	Mode  string    `bson:"mode" json:"mode"` // COSTMODE_ESTIMATES or COSTMODE_ATTENDANCE
+	SetBy int64     `bson:"by" json:"by"`
+	DtTm  time.Time `bson:"dttm" json:"dttm"`
+}
+
+// Budget : projected expenses for the month as set by the admins
+// daily debits are computed on the budget till actual expenses exceed it
+type Budget struct {
+	Month string    `bson:"month" json:"month"` // YYYY-MM
+	INR   float32   `bson:"inr" json:"inr"`
+	SetBy int64     `bson:"by" json:"by"`
+	DtTm  time.Time `bson:"dttm" json:"dttm"`
+}
+
+// BudgetQ : budget and the actual expenses for the month
+// when admins have not set the budget its derived from last month's expenses and the recurring expenses
+type BudgetQ struct {
+	Month   string  // YYYY-MM
+	Budget  float32 // as set by admins or derived
+	Derived bool    // true when the budget wasnt set by admins
+	Actual  float32 // total expenses recorded in the month so far
+}
+
+// Cost : cost of the month that the daily debits have to recover
+// budget till the actual expenses exceed it
+func (bq *BudgetQ) Cost() float32 {
+	if bq.Actual > bq.Budget {
+		return bq.Actual
+	}
+	return bq.Budget
+}
+
+func (bq *BudgetQ) ToMsgTxt() string {
+	src := "set by admin"
+	if bq.Derived {
+		src = "derived from last month & recurring expenses"
+	}
+	return fmt.Sprintf("Budget for %s: %.2f (%s)%%0AActual expenses: %.2f%%0ADaily debits recover: %.2f", bq.Month, bq.Budget, src, bq.Actual, bq.Cost())
+}
+
+// AuditLog : every command executed by the bot is recorded in the audit trail
+// who executed it, from which chat/message, with what arguments and what came out of it
+// for commands that mutate state, the state before and after is snapshotted as well
+type AuditLog struct {
+	Id      bson.ObjectId          `bson:"_id,omitempty" json:"id"`
+	Cmd     string                 `bson:"cmd" json:"cmd"`                 // type of the command executed
+	TelegID int64                  `bson:"tid" json:"tid"`                 // sender of the command
+	ChatId  int64                  `bson:"chat" json:"chat"`               // chat in which the command was sent
+	MsgId   int64                  `bson:"msg" json:"msg"`                 // message that carried the command
+	Args    map[string]interface{} `bson:"args,omitempty" json:"args"`     // parsed arguments of the command
+	Outcome string                 `bson:"outcome" json:"outcome"`         // AUDIT_OK / AUDIT_ERR
+	Err     string                 `bson:"err,omitempty" json:"err"`       // error when the command failed
+	ErrLoc  string                 `bson:"errloc,omitempty" json:"errloc"` // location of the domain error
+	UserMsg string                 `bson:"usrmsg,omitempty" json:"usrmsg"` // message the user got back
+	Before  interface{}            `bson:"before,omitempty" json:"before"` // state before the mutation
+	After   interface{}            `bson:"after,omitempty" json:"after"`   // state after the mutation
+	DtTm    time.Time              `bson:"dttm" json:"dttm"`
+}
+
+func (al *AuditLog) ToMsgTxt() string {
+	txt := fmt.Sprintf("%s %s by %d: %s", al.DtTm.Format("02-Jan 15:04"), al.Cmd, al.TelegID, al.Outcome)
+	if al.ErrLoc != "" {
+		txt = fmt.Sprintf("%s (%s)", txt, al.ErrLoc)
+	}
+	return txt
+}
+
+// AuditQ : querying the audit trail, either for an account or for everyone
+type AuditQ struct {
+	TelegID int64      // 0 for all the accounts
+	Limit   int        // latest n entries
+	Logs    []AuditLog // result of the query
+}
+
+func (aq *AuditQ) ToMsgTxt() string {
+	if len(aq.Logs) == 0 {
+		return fmt.Sprintf("%c Nothing in the audit trail", EMOJI_warning)
+	}
+	txt := fmt.Sprintf("Latest %d entries in the audit trail", len(aq.Logs))
+	for _, l := range aq.Logs {
+		txt = fmt.Sprintf("%s%%0A%s", txt, l.ToMsgTxt())
+	}
+	return txt
+}
@@ -0,0 +1,265 @@
+package biz
+
+/* ==================================
+Payments towards dues: a member claims to have paid, a manager confirms it's received
+Dues are cleared only when the payment is confirmed
+Members pay the treasurer over UPI, links are generated for the exact amount due
+====================================*/
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kneerunjun/botmincock/dbadp"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// RequestPayment : records the payment as pending confirmation
+// pp		: in/out param, member & amount, gets back the id of the payment
+// iadp		: adaptor to the payments collection
+// Errors when the amount is invalid, account isnt registered or the query fails
+func RequestPayment(pp *PendingPayment, iadp dbadp.DbAdaptor) error {
+	errLoc := "RequestPayment"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if pp == nil || pp.INR <= float32(0.0) || pp.DtTm.IsZero() {
+		return NewDomainError(ERR_INVLPARAM, nil).SetLoc(errLoc).SetUsrMsg(invalid_transfer("amount has to be non zero"))
+	}
+	if err := AccountInfo(&UserAccount{TelegID: pp.TelegID}, iadp.Switch("accounts")); err != nil {
+		return err
+	}
+	pp.Id = bson.NewObjectId()
+	pp.Status = PAYMNT_PENDING
+	if err := iadp.AddOne(pp); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("recording the payment")).SetLogEntry(log.Fields{
+			"telegid": pp.TelegID,
+			"inr":     pp.INR,
+		})
+	}
+	return nil
+}
+
+// ReviewPayment : confirms / declines the pending payment, confirmed payment clears the dues
+// pp		: in/out param, id of the payment with Status (confirmed / declined) and ReviewedBy, gets back the reviewed payment
+// iadp		: adaptor to the payments collection
+// Errors when the payment isnt found pending, is the reviewer's own, the dues cannot be cleared or the query fails
+func ReviewPayment(pp *PendingPayment, iadp dbadp.DbAdaptor) error {
+	errLoc := "ReviewPayment"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if pp == nil || (pp.Status != PAYMNT_CONFIRMED && pp.Status != PAYMNT_DECLINED) {
+		return NewDomainError(ERR_INVLPARAM, nil).SetLoc(errLoc).SetUsrMsg(TRY_AGAIN)
+	}
+	// account is only taken from the reviewer for gateway payments that could not be mapped to a member
+	status, by, on, acc := pp.Status, pp.ReviewedBy, time.Now(), pp.TelegID
+	// selecting only the pending payment so that two managers confirming together cannot credit twice
+	pending := bson.M{"_id": pp.Id, "status": PAYMNT_PENDING}
+	found, err := iadp.GetOne(pending, reflect.TypeOf(&PendingPayment{}))
+	if err != nil {
+		if errors.Is(err, mgo.ErrNotFound) {
+			return NewDomainError(ERR_PAYMNT404, err).SetLoc(errLoc).SetUsrMsg(payment_notfound(pp.Id.Hex()))
+		}
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the payment"))
+	}
+	*pp = *(found.(*PendingPayment))
+	patch := bson.M{"status": status, "reviewedby": by, "reviewedon": on}
+	if pp.TelegID == 0 && status == PAYMNT_CONFIRMED {
+		if acc == 0 {
+			return NewDomainError(ERR_INVLPARAM, nil).SetLoc(errLoc).SetUsrMsg(payment_noaccount(pp.Id.Hex()))
+		}
+		if err := AccountInfo(&UserAccount{TelegID: acc}, iadp.Switch("accounts")); err != nil {
+			return err
+		}
+		pp.TelegID, patch["tid"] = acc, acc
+	}
+	// member's own word is not enough to clear their dues, another manager has to confirm it
+	if pp.TelegID == by {
+		return NewDomainError(ERR_SELFREVIEW, nil).SetLoc(errLoc).SetUsrMsg(self_review("payment"))
+	}
+	if err := iadp.UpdateOne(pending, patch); err != nil {
+		if errors.Is(err, mgo.ErrNotFound) {
+			return NewDomainError(ERR_PAYMNT404, err).SetLoc(errLoc).SetUsrMsg(payment_notfound(pp.Id.Hex()))
+		}
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("reviewing the payment"))
+	}
+	pp.Status, pp.ReviewedBy, pp.ReviewedOn = status, by, on
+	if status == PAYMNT_DECLINED {
+		return nil
+	}
+	// credit is dated when the member paid, unless that month is closed since - then its in the month of confirmation
+	dt := pp.DtTm
+	if err := AssertPeriodOpen(dt, iadp); err != nil {
+		if de, _ := err.(*DomainError); !errors.Is(de.Err, ERR_PERIODLOCKED) {
+			return err
+		}
+		dt = on
+	}
+	if err := ClearDues(&Transac{TelegID: pp.TelegID, Credit: pp.INR, DtTm: dt, Desc: CLEAR_DUES_DESC}, iadp.Switch("transacs")); err != nil {
+		// payment goes back to pending so that it can be confirmed again
+		revert := bson.M{"status": PAYMNT_PENDING}
+		if _, ok := patch["tid"]; ok {
+			revert["tid"] = int64(0)
+		}
+		if rerr := iadp.UpdateOne(bson.M{"_id": pp.Id}, revert); rerr != nil {
+			log.WithFields(log.Fields{
+				"payid":  pp.Id.Hex(),
+				"status": status,
+				"err":    rerr,
+			}).Error("failed to revert the payment to pending, dues are not cleared")
+		}
+		return err
+	}
+	return nil
+}
+
+// PendingPayments : payments pending confirmation since before the given time, oldest first
+// before	: zero time gets all the pending payments
+func PendingPayments(before time.Time, iadp dbadp.DbAdaptor) ([]PendingPayment, error) {
+	errLoc := "PendingPayments"
+	if iadp == nil {
+		return nil, NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	match := bson.M{"status": PAYMNT_PENDING}
+	if !before.IsZero() {
+		match["dttm"] = bson.M{"$lte": before}
+	}
+	result := []PendingPayment{}
+	if err := iadp.AggregateAll([]bson.M{{"$match": match}, {"$sort": bson.M{"dttm": 1}}}, &result); err != nil {
+		return nil, NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting pending payments"))
+	}
+	return result, nil
+}
+
+// UPILink : deep link that opens the UPI app with the payee and amount filled in
+// vpa		: virtual payment address of the treasurer, name is the payee name shown on the app
+// note		: transaction note, helps the treasurer identify whose payment it is
+func UPILink(vpa, name string, inr float32, note string) string {
+	link := fmt.Sprintf("upi://pay?pa=%s&am=%.2f&cu=INR", url.QueryEscape(vpa), inr)
+	if name != "" {
+		link = fmt.Sprintf("%s&pn=%s", link, url.QueryEscape(name))
+	}
+	if note != "" {
+		link = fmt.Sprintf("%s&tn=%s", link, url.QueryEscape(note))
+	}
+	return link
+}
+
+// DuesNote : transaction note for the payments towards dues from the account
+func DuesNote(tid int64) string {
+	return fmt.Sprintf("Dues %d", tid)
+}
+
+// TelegIDFromNote : reads back the account from the transaction note, false when the note isnt a dues note
+func TelegIDFromNote(note string) (int64, bool) {
+	matches := REGX_DUES_NOTE.FindStringSubmatch(strings.TrimSpace(note))
+	if matches == nil {
+		return 0, false
+	}
+	tid, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return tid, true
+}
+
+// PAYMENT_GWID_INDEX : a gateway payment is recorded only once, payments confirmed by managers have no gwid
+var PAYMENT_GWID_INDEX = mgo.Index{Key: []string{"gwid"}, Unique: true, Sparse: true}
+
+// GatewayPayment : payment received on the gateway clears the dues without a manager's confirmation
+// pp		: in/out param, INR, DtTm and GwId are required, TelegID is 0 when the payment could not be mapped to a member
+// iadp		: adaptor to the payments collection
+// Gateway retries the webhooks, hence this is idempotent on GwId - a payment already recorded is sent back as is, with no error
+// deliveries racing each other are caught by the unique PAYMENT_GWID_INDEX on the payments collection
+// Money received is never dropped - payment that cannot be credited (unknown / archived account, closed month) is left pending for the managers to confirm
+// Errors when the payment is invalid, or a query fails and the gateway has to retry
+func GatewayPayment(pp *PendingPayment, iadp dbadp.DbAdaptor) error {
+	errLoc := "GatewayPayment"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if pp == nil || pp.GwId == "" || pp.INR <= float32(0.0) || pp.DtTm.IsZero() {
+		return NewDomainError(ERR_INVLPARAM, nil).SetLoc(errLoc).SetUsrMsg(invalid_transfer("amount has to be non zero"))
+	}
+	found, err := iadp.GetOne(bson.M{"gwid": pp.GwId}, reflect.TypeOf(&PendingPayment{}))
+	if err == nil {
+		*pp = *(found.(*PendingPayment))
+		log.WithFields(log.Fields{
+			"gwid": pp.GwId,
+		}).Warn("gateway payment already recorded, ignoring")
+		return nil
+	} else if !errors.Is(err, mgo.ErrNotFound) {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the payment"))
+	}
+	pp.Id = bson.NewObjectId()
+	pp.Status = PAYMNT_GATEWAY
+	pp.ReviewedOn = time.Now()
+	if pp.TelegID == 0 {
+		pp.Status, pp.ReviewedOn = PAYMNT_PENDING, time.Time{}
+	} else if err := AccountInfo(&UserAccount{TelegID: pp.TelegID}, iadp.Switch("accounts")); err != nil {
+		if isQryFail(err) {
+			return err
+		}
+		// unknown / archived account, managers confirm it against the right account
+		pp.Status, pp.ReviewedOn, pp.TelegID = PAYMNT_PENDING, time.Time{}, 0
+	}
+	if err := iadp.AddOne(pp); err != nil {
+		if mgo.IsDup(err) {
+			// same webhook delivered concurrently, the other delivery has recorded it
+			log.WithFields(log.Fields{
+				"gwid": pp.GwId,
+			}).Warn("gateway payment already recorded, ignoring")
+			return nil
+		}
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("recording the payment")).SetLogEntry(log.Fields{
+			"gwid":    pp.GwId,
+			"telegid": pp.TelegID,
+		})
+	}
+	if pp.Status == PAYMNT_PENDING {
+		log.WithFields(log.Fields{
+			"gwid":  pp.GwId,
+			"inr":   pp.INR,
+			"payid": pp.Id.Hex(),
+		}).Warn("gateway payment without account, left pending for the managers to confirm")
+		return nil
+	}
+	err = ClearDues(&Transac{TelegID: pp.TelegID, Credit: pp.INR, DtTm: pp.DtTm, Desc: CLEAR_DUES_DESC}, iadp.Switch("transacs"))
+	if err == nil {
+		return nil
+	}
+	if !isQryFail(err) {
+		// dues cannot be cleared for now (closed month for ex), managers confirm it later
+		if uerr := iadp.UpdateOne(bson.M{"_id": pp.Id}, bson.M{"status": PAYMNT_PENDING}); uerr == nil {
+			pp.Status = PAYMNT_PENDING
+			log.WithFields(log.Fields{
+				"gwid":  pp.GwId,
+				"payid": pp.Id.Hex(),
+				"err":   err,
+			}).Warn("gateway payment could not clear the dues, left pending for the managers to confirm")
+			return nil
+		}
+	}
+	// payment is removed so that the gateway retrying the webhook can clear the dues
+	if rerr := iadp.RemoveOne(bson.M{"_id": pp.Id}); rerr != nil {
+		log.WithFields(log.Fields{
+			"gwid": pp.GwId,
+			"err":  rerr,
+		}).Error("failed to remove the gateway payment, dues are not cleared")
+	}
+	return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("clearing the dues"))
+}
+
+// isQryFail : errors for which the operation can be tried again, as against the ones that would fail the same way again
+func isQryFail(err error) bool {
+	de, ok := err.(*DomainError)
+	return !ok || errors.Is(de.Err, ERR_QRYFAIL) || errors.Is(de.Err, ERR_DBCONN)
+}
@@ -0,0 +1,94 @@
+package biz
+
+/* ==================================
+Book keeping periods are months, once the accounts for a month are settled the month can be locked
+All the write paths in the business layer check the lock before writing
+Locked periods make sure that offsetted transactions / expenses cannot alter the closed books
+====================================*/
+
+import (
+	"time"
+
+	"github.com/kneerunjun/botmincock/dbadp"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// AssertPeriodOpen : checks if the period (month) of the given date is not locked
+// iadp		: adaptor to any collection, switches to periodlocks collection
+// Errors with ERR_PERIODLOCKED when the month is closed, or when the query fails
+func AssertPeriodOpen(dt time.Time, iadp dbadp.DbAdaptor) error {
+	errLoc := "AssertPeriodOpen"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	locks := iadp.Switch("periodlocks")
+	if locks == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	month := PeriodOf(dt)
+	count := 0
+	if err := locks.GetCount(bson.M{"month": month}, &count); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("checking if the month is closed")).SetLogEntry(log.Fields{
+			"month": month,
+		})
+	}
+	if count > 0 {
+		return NewDomainError(ERR_PERIODLOCKED, nil).SetLoc(errLoc).SetUsrMsg(period_locked(month)).SetLogEntry(log.Fields{
+			"month": month,
+		})
+	}
+	return nil
+}
+
+// LockPeriod : closes the books for the month
+// pl		: month to lock as YYYY-MM, and the account that locks it
+// Errors when the month is invalid, already locked or the query fails
+func LockPeriod(pl *PeriodLock, iadp dbadp.DbAdaptor) error {
+	errLoc := "LockPeriod"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if _, err := ParsePeriod(pl.Month); err != nil || pl.Month == "" {
+		return NewDomainError(ERR_INVLPARAM, err).SetLoc(errLoc).SetUsrMsg(invalid_period(pl.Month))
+	}
+	count := 0
+	if err := iadp.GetCount(bson.M{"month": pl.Month}, &count); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("checking if the month is closed"))
+	}
+	if count > 0 {
+		return NewDomainError(ERR_PERIODDUPLC, nil).SetLoc(errLoc).SetUsrMsg(period_locked(pl.Month))
+	}
+	if err := iadp.AddOne(pl); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("closing the month")).SetLogEntry(log.Fields{
+			"month": pl.Month,
+			"by":    pl.LockedBy,
+		})
+	}
+	return nil
+}
+
+// UnlockPeriod : re-opens the books for the month so that corrections can be made
+// Unlocking a month that isnt locked is not an error
+func UnlockPeriod(pl *PeriodLock, iadp dbadp.DbAdaptor) error {
+	errLoc := "UnlockPeriod"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if _, err := ParsePeriod(pl.Month); err != nil || pl.Month == "" {
+		return NewDomainError(ERR_INVLPARAM, err).SetLoc(errLoc).SetUsrMsg(invalid_period(pl.Month))
+	}
+	count := 0
+	if err := iadp.GetCount(bson.M{"month": pl.Month}, &count); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("checking if the month is closed"))
+	}
+	if count == 0 {
+		return nil
+	}
+	if err := iadp.RemoveOne(bson.M{"month": pl.Month}); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("re-opening the month")).SetLogEntry(log.Fields{
+			"month": pl.Month,
+		})
+	}
+	return nil
+}
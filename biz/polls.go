@@ -0,0 +1,213 @@
+package biz
+
+/* ==================================
+Estimates poll sent on the group each month
+Poll definition - question, options and the playdays for each option - is one config, stored along with the poll when sent
+Answers to the poll are decoded with the stored options, so that what is asked and what is recorded cannot drift apart
+Poll sent on the 26th asks about the next month, answers are estimates for the month the poll asks about
+Members who do not answer by the deadline are given default estimates
+====================================*/
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/kneerunjun/botmincock/dbadp"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// weekendDays : number of saturdays and sundays in the month of the date
+func weekendDays(dt time.Time) int {
+	count := 0
+	for d := 1; d <= daysInMonth(dt.Month(), dt.Year()); d++ {
+		switch time.Date(dt.Year(), dt.Month(), d, 0, 0, 0, 0, dt.Location()).Weekday() {
+		case time.Saturday, time.Sunday:
+			count++
+		}
+	}
+	return count
+}
+
+// EstimatePoll : default estimates poll for the month of the date
+// playdays for each option are from the actual length of the month and its weekends
+func EstimatePoll(month time.Time) *SentPoll {
+	days := daysInMonth(month.Month(), month.Year())
+	return &SentPoll{
+		Month:    PeriodOf(month),
+		Question: fmt.Sprintf("Availability for %s ?", month.Month().String()),
+		Options: []PollOption{
+			{Text: fmt.Sprintf("All days (%d)", days), Playdays: days},
+			{Text: fmt.Sprintf("Half the days (%d)", days/2), Playdays: days / 2},
+			{Text: fmt.Sprintf("Only on weekends (%d)", weekendDays(month)), Playdays: weekendDays(month)},
+			{Text: "Out for the month", Playdays: 0},
+		},
+	}
+}
+
+// Playdays : playdays for the option chosen on the poll, options are zero indexed
+func (sp *SentPoll) Playdays(opt int) (int, error) {
+	if opt < 0 || opt >= len(sp.Options) {
+		return -1, NewDomainError(ERR_POLLOPT, nil).SetLoc("Playdays").SetUsrMsg(poll_unknown()).SetLogEntry(log.Fields{
+			"pollid": sp.PollId,
+			"opt":    opt,
+		})
+	}
+	return sp.Options[opt].Playdays, nil
+}
+
+// Expired : answers to the poll are taken only till the end of the month it asks about
+func (sp *SentPoll) Expired(now time.Time) bool {
+	month, err := ParsePeriod(sp.Month)
+	if err != nil || sp.Month == "" {
+		return true
+	}
+	_, to := MonthBoundaryOf(month)
+	return now.After(to)
+}
+
+// EstimateMonth : date on the estimate for an answer to the poll
+// answer within the month is dated now, else to the start of the month the poll asks about
+func (sp *SentPoll) EstimateMonth(now time.Time) time.Time {
+	if sp.Month == PeriodOf(now) {
+		return now
+	}
+	month, _ := ParsePeriod(sp.Month)
+	return month
+}
+
+// RecordPoll : stores the poll as sent, PollId and Month are required
+func RecordPoll(sp *SentPoll, iadp dbadp.DbAdaptor) error {
+	errLoc := "RecordPoll"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if _, err := ParsePeriod(sp.Month); err != nil || sp.PollId == "" || sp.Month == "" || len(sp.Options) == 0 {
+		return NewDomainError(ERR_INVLPARAM, nil).SetLoc(errLoc).SetUsrMsg(TRY_AGAIN)
+	}
+	if err := iadp.AddOne(sp); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("recording the poll")).SetLogEntry(log.Fields{
+			"pollid": sp.PollId,
+		})
+	}
+	return nil
+}
+
+// PollOf : gets the poll as it was sent
+// sp		: in/out param, send in the PollId get back the poll
+// Errors with ERR_POLL404 when the poll wasnt sent by the bot
+func PollOf(sp *SentPoll, iadp dbadp.DbAdaptor) error {
+	errLoc := "PollOf"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	found, err := iadp.GetOne(bson.M{"pollid": sp.PollId}, reflect.TypeOf(&SentPoll{}))
+	if err != nil {
+		if errors.Is(err, mgo.ErrNotFound) {
+			return NewDomainError(ERR_POLL404, err).SetLoc(errLoc).SetUsrMsg(poll_unknown()).SetLogEntry(log.Fields{
+				"pollid": sp.PollId,
+			})
+		}
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the poll"))
+	}
+	*sp = *(found.(*SentPoll))
+	return nil
+}
+
+// PollOfMonth : latest poll sent for the month
+// sp		: in/out param, send in the Month get back the poll
+// Errors with ERR_POLL404 when no poll was sent for the month
+func PollOfMonth(sp *SentPoll, iadp dbadp.DbAdaptor) error {
+	errLoc := "PollOfMonth"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	result := SentPoll{}
+	if err := iadp.Aggregate([]bson.M{{"$match": bson.M{"month": sp.Month}}, {"$sort": bson.M{"dttm": -1}}, {"$limit": 1}}, &result); err != nil {
+		if errors.Is(err, mgo.ErrNotFound) {
+			return NewDomainError(ERR_POLL404, err).SetLoc(errLoc).SetUsrMsg(poll_unknown()).SetLogEntry(log.Fields{
+				"month": sp.Month,
+			})
+		}
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the poll"))
+	}
+	*sp = result
+	return nil
+}
+
+// NonRespondents : registered accounts with no estimate for the month
+// iadp		: adaptor to any collection, switches to accounts and estimates
+func NonRespondents(month time.Time, iadp dbadp.DbAdaptor) ([]UserAccount, error) {
+	errLoc := "NonRespondents"
+	if iadp == nil {
+		return nil, NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	accounts := []UserAccount{}
+	if err := iadp.Switch("accounts").AggregateAll([]bson.M{{"$match": bson.M{"archive": false}}, {"$sort": bson.M{"tid": 1}}}, &accounts); err != nil {
+		return nil, NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the accounts"))
+	}
+	from, to := MonthBoundaryOf(month)
+	estimates := []Estimate{}
+	if err := iadp.Switch("estimates").AggregateAll([]bson.M{{"$match": bson.M{"dttm": bson.M{"$gte": from, "$lte": to}}}}, &estimates); err != nil {
+		return nil, NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the estimates"))
+	}
+	responded := map[int64]bool{}
+	for _, e := range estimates {
+		responded[e.TelegID] = true
+	}
+	result := []UserAccount{}
+	for _, ua := range accounts {
+		if !responded[ua.TelegID] {
+			result = append(result, ua)
+		}
+	}
+	return result, nil
+}
+
+// DefaultEstimates : estimates for the members who did not answer the poll, once for each poll
+// sp		: poll past its deadline, marked defaulted when done
+// dflt		: playdays for the non-respondents, when negative its the same as their last month
+// members without an estimate last month are left as is, they are charged as guests
+// iadp		: adaptor to any collection, switches to polls, accounts and estimates
+// sends back the estimates added
+func DefaultEstimates(sp *SentPoll, dflt int, iadp dbadp.DbAdaptor) ([]Estimate, error) {
+	errLoc := "DefaultEstimates"
+	if iadp == nil {
+		return nil, NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	month, err := ParsePeriod(sp.Month)
+	if err != nil || sp.Month == "" {
+		return nil, NewDomainError(ERR_INVLPARAM, err).SetLoc(errLoc).SetUsrMsg(invalid_period(sp.Month))
+	}
+	added := []Estimate{}
+	if sp.Defaulted {
+		return added, nil
+	}
+	members, err := NonRespondents(month, iadp)
+	if err != nil {
+		return nil, err
+	}
+	estimates := iadp.Switch("estimates")
+	for _, ua := range members {
+		days := dflt
+		if dflt < 0 {
+			days, _ = PlayerPlayDaysOf(ua.TelegID, month.AddDate(0, 0, -1), estimates)
+			if days <= 0 {
+				continue
+			}
+		}
+		est := &Estimate{TelegID: ua.TelegID, PlyDys: days, DtTm: month}
+		if err := UpsertEstimate(est, estimates); err != nil {
+			return added, err
+		}
+		added = append(added, *est)
+	}
+	if err := iadp.Switch("polls").UpdateOne(bson.M{"pollid": sp.PollId}, bson.M{"defaulted": true}); err != nil {
+		return added, NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("updating the poll"))
+	}
+	sp.Defaulted = true
+	return added, nil
+}
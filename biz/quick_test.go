@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
+	"sync"
 	"testing"
 	"time"
 
@@ -50,6 +52,27 @@ func TestAdjustDayDebit(t *testing.T) {
 		t.Error(err)
 		return
 	}
+	// TEST: playday debits are left as is, adjustments are appended referring to them
+	count, _ := coll.Find(bson.M{"desc": PLAYDAY_DESC, "debit": 150.00}).Count()
+	assert.Equal(t, len(data), count, "Unexpected playday debits altered by AdjustDayDebit")
+	count, _ = coll.Find(bson.M{"desc": ADJUST_DESC, "ref": bson.M{"$exists": true}}).Count()
+	assert.Equal(t, len(data), count, "Unexpected number of adjustments appended")
+	trq = &TransacQ{From: from, To: to}
+	TotalPlaydayDebits(trq, adp)
+	assert.Equal(t, float32(1000.00), trq.Debits, "Unexpected total debits after adjustment")
+
+	// TEST: reversing a playday debit leaves it out of the playdays
+	orig := &Transac{}
+	coll.Find(bson.M{"tid": 961044876, "desc": PLAYDAY_DESC}).One(orig)
+	assert.Nil(t, ReviseTransac(orig, 0.0, 0.0, adp), "Unexpected error when reversing a transaction")
+	playdays, err := PlaydayDebits(&TransacQ{From: from, To: to}, adp)
+	assert.Nil(t, err, "Unexpected error when getting the playday debits")
+	assert.Equal(t, len(data)-1, len(playdays), "Unexpected number of playdays after reversal")
+
+	// TEST: balance as of before the reversal still has the reversed debit
+	bl := &Balance{TelegID: 961044876, DtTm: time.Now()}
+	assert.Nil(t, BalanceAsOf(bl, adp), "Unexpected error getting the balance as of now")
+	assert.Equal(t, float32(-100.00), bl.Due, "Unexpected balance after reversal")
 }
 
 func TestErrType(t *testing.T) {
@@ -531,6 +554,9 @@ func TestTeamMonthlyExpense(t *testing.T) {
 		{TelegID: 5157350442, Desc: "Bouchetia erecta DC", INR: 300, DtTm: time.Now()},
 		{TelegID: 5157350442, Desc: "Chromolaena corymbosa", INR: 300, DtTm: time.Now()},
 	}
+	// expense on the evening of the last day still belongs to the month
+	_, lastDay := MonthAsBoundary()
+	okData = append(okData, &Expense{TelegID: 5157350442, Desc: "Zeltnera beyrichii", INR: 300, DtTm: lastDay.Add(-4 * time.Hour)})
 	testSum := float32(0.0)
 	for _, d := range okData {
 		if coll.Insert(d) == nil {
@@ -887,3 +913,999 @@ func TestEmailRegx(t *testing.T) {
 		assert.False(t, REGX_EMAIL.MatchString(d), fmt.Sprintf("email %s shouldn't have passed the test", d))
 	}
 }
+
+// TestPeriodLock : once the month is locked none of the write paths should be able to write into it
+func TestPeriodLock(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	locks := sess.DB("").C("periodlocks")
+	locks.RemoveAll(bson.M{})
+	defer locks.RemoveAll(bson.M{})
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "periodlocks")
+
+	// TEST: invalid months cannot be locked
+	for _, m := range []string{"", "2023-13", "June", "2023/06"} {
+		err := LockPeriod(&PeriodLock{Month: m, LockedBy: 5157350442, DtTm: time.Now()}, adp)
+		assert.NotNil(t, err, "Unexpected nil error when locking invalid month %s", m)
+	}
+	// TEST: locking the current month, none of the writes should go thru
+	pl := &PeriodLock{Month: PeriodOf(time.Now()), LockedBy: 5157350442, DtTm: time.Now()}
+	assert.Nil(t, LockPeriod(pl, adp), "Unexpected error when locking the current month")
+	assert.NotNil(t, LockPeriod(pl, adp), "Unexpected nil error when locking the month twice")
+
+	err := RecordExpense(&Expense{INR: 100.00, TelegID: 5157350442, DtTm: time.Now(), Desc: "test expense"}, adp.Switch("expenses"))
+	assert.NotNil(t, err, "Unexpected nil error when recording expense in a locked month")
+	err = MarkPlayday(&Transac{TelegID: 5157350442, Debit: 100.00, Desc: PLAYDAY_DESC, DtTm: TodayAtSevenAM()}, adp.Switch("transacs"))
+	assert.NotNil(t, err, "Unexpected nil error when marking playday in a locked month")
+	from, to := TodayAsBoundary()
+	err = AdjustDayDebit(&TransacQ{Desc: PLAYDAY_DESC, From: from, To: to, Debits: 10.0}, adp.Switch("transacs"))
+	assert.NotNil(t, err, "Unexpected nil error when adjusting debits in a locked month")
+	err = UpsertEstimate(&Estimate{TelegID: 5157350442, PlyDys: 10}, adp.Switch("estimates"))
+	assert.NotNil(t, err, "Unexpected nil error when upserting estimate in a locked month")
+	de, ok := err.(*DomainError)
+	assert.True(t, ok && errors.Is(de.Err, ERR_PERIODLOCKED), "Unexpected error type for locked month")
+
+	// TEST: unlocking the month lets the writes thru again
+	assert.Nil(t, UnlockPeriod(pl, adp), "Unexpected error when unlocking the month")
+	assert.Nil(t, AssertPeriodOpen(time.Now(), adp), "Unexpected error for an unlocked month")
+}
+
+// TestEditDeleteExpense : expenses are referred to by their unique ids, editing/deleting should also alter the matching credit
+func TestEditDeleteExpense(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	expenses := sess.DB("").C("expenses")
+	transacs := sess.DB("").C("transacs")
+	revs := sess.DB("").C("expenserevs")
+	expenses.RemoveAll(bson.M{})
+	transacs.RemoveAll(bson.M{})
+	revs.RemoveAll(bson.M{})
+	defer expenses.RemoveAll(bson.M{})
+	defer transacs.RemoveAll(bson.M{})
+	defer revs.RemoveAll(bson.M{})
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "expenses")
+
+	exp := &Expense{INR: 1055.00, TelegID: 5157350442, DtTm: time.Now(), Desc: "purchase of shuttles"}
+	assert.Nil(t, RecordExpense(exp, adp), "unexpected error when recording an expense")
+	assert.True(t, exp.Id.Valid(), "unexpected invalid id for the recorded expense")
+	// TEST: expense recorded without an attachment has no receipt
+	err := ExpenseReceipt(&Expense{Id: exp.Id}, adp)
+	de, ok := err.(*DomainError)
+	assert.True(t, ok && errors.Is(de.Err, ERR_RCPT404), "unexpected error for an expense without receipt")
+	withRcpt := &Expense{INR: 300.00, TelegID: 5157350442, DtTm: time.Now(), Desc: "court booking", Receipt: "AgACAgUAAxkBAAIBZ2", RcptKind: "photo"}
+	assert.Nil(t, RecordExpense(withRcpt, adp), "unexpected error when recording an expense with receipt")
+	rcpt := &Expense{Id: withRcpt.Id}
+	assert.Nil(t, ExpenseReceipt(rcpt, adp), "unexpected error when getting the receipt")
+	assert.Equal(t, "AgACAgUAAxkBAAIBZ2", rcpt.Receipt, "unexpected receipt file id")
+
+	netCredit := func() float32 {
+		net := struct {
+			Credits float32 `bson:"credits"`
+		}{}
+		transacs.Pipe([]bson.M{
+			{"$match": bson.M{"expid": exp.Id}},
+			{"$group": bson.M{"_id": nil, "credits": bson.M{"$sum": "$credit"}}},
+		}).One(&net)
+		return net.Credits
+	}
+	// TEST: editing the expense appends a correction to the matching credit
+	edit := &Expense{Id: exp.Id, INR: 1000.00, Desc: "purchase of shuttles, discounted"}
+	assert.Nil(t, EditExpense(edit, adp), "unexpected error when editing an expense")
+	assert.Equal(t, float32(1000.00), netCredit(), "unexpected credit after editing the expense")
+	count, _ := transacs.Find(bson.M{"expid": exp.Id}).Count()
+	assert.Equal(t, 2, count, "unexpected number of transactions, correction should have been appended")
+
+	// TEST: deleting the expense reverses the matching credit
+	assert.Nil(t, DeleteExpense(&Expense{Id: exp.Id}, adp), "unexpected error when deleting an expense")
+	assert.Equal(t, float32(0.0), netCredit(), "unexpected credit remaining after deleting the expense")
+	count, _ = transacs.Find(bson.M{"expid": exp.Id, "ref": bson.M{"$exists": true}}).Count()
+	assert.Equal(t, 2, count, "unexpected number of corrections to the credit")
+	// TEST: each version replaced is kept as a revision, in order
+	history := []ExpenseRev{}
+	revs.Find(bson.M{"expid": exp.Id}).Sort("posted").All(&history)
+	assert.Equal(t, 2, len(history), "unexpected number of revisions for the expense")
+	if len(history) == 2 {
+		assert.Equal(t, EXPREV_EDIT, history[0].Action, "unexpected action for the first revision")
+		assert.Equal(t, float32(1055.00), history[0].Prior.INR, "unexpected amount before the edit")
+		assert.Equal(t, EXPREV_DELETE, history[1].Action, "unexpected action for the second revision")
+		assert.Equal(t, float32(1000.00), history[1].Prior.INR, "unexpected amount before the delete")
+	}
+
+	// TEST: expenses that arent found
+	assert.NotNil(t, EditExpense(&Expense{Id: bson.NewObjectId(), INR: 100.0}, adp), "unexpected nil error when editing missing expense")
+	assert.NotNil(t, DeleteExpense(&Expense{Id: bson.NewObjectId()}, adp), "unexpected nil error when deleting missing expense")
+}
+
+// TestAuditTrail : audit logs are appended and queried latest first
+func TestAuditTrail(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	coll := sess.DB("").C("audit")
+	coll.RemoveAll(bson.M{})
+	defer coll.RemoveAll(bson.M{})
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "audit")
+
+	dataOk := []*AuditLog{
+		{Cmd: "AddExpenseBotCmd", TelegID: 5157350442, Args: map[string]interface{}{"inr": 100.0}, Outcome: AUDIT_OK, After: &Expense{INR: 100.0}, DtTm: time.Now().Add(-2 * time.Minute)},
+		{Cmd: "PayDuesBotCmd", TelegID: 498116745, Outcome: AUDIT_ERR, Err: "account not found", ErrLoc: "ClearDues", DtTm: time.Now().Add(-1 * time.Minute)},
+		{Cmd: "MyInfoBotCmd", TelegID: 5157350442, Outcome: AUDIT_OK, DtTm: time.Now()},
+	}
+	for _, d := range dataOk {
+		assert.Nil(t, RecordAudit(d, adp), "Unexpected error when recording audit")
+	}
+	// TEST: logs without the command or time are rejected
+	assert.NotNil(t, RecordAudit(&AuditLog{TelegID: 5157350442}, adp), "Unexpected nil error for invalid audit log")
+
+	aq := &AuditQ{Limit: 10}
+	assert.Nil(t, AuditTrail(aq, adp), "Unexpected error when getting audit trail")
+	assert.Equal(t, len(dataOk), len(aq.Logs), "Unexpected number of audit logs")
+	assert.Equal(t, "MyInfoBotCmd", aq.Logs[0].Cmd, "Unexpected order of the audit trail, expected latest first")
+
+	aq = &AuditQ{TelegID: 5157350442, Limit: 1}
+	assert.Nil(t, AuditTrail(aq, adp), "Unexpected error when getting audit trail")
+	assert.Equal(t, 1, len(aq.Logs), "Unexpected number of audit logs for the account")
+}
+
+// TestExpenseCategories : expenses tagged with categories, and the break down of monthly expenses by category
+func TestExpenseCategories(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	for _, c := range []string{"expenses", "transacs", "expcategories"} {
+		sess.DB("").C(c).RemoveAll(bson.M{})
+		defer sess.DB("").C(c).RemoveAll(bson.M{})
+	}
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "expenses")
+	ctgry := adp.Switch("expcategories")
+
+	// TEST: with no categories maintained, defaults apply
+	cats, err := ExpenseCategories(ctgry)
+	assert.Nil(t, err, "Unexpected error when getting categories")
+	assert.Equal(t, DEFAULT_EXPNS_CTGRY, cats, "Unexpected categories when none maintained")
+
+	dataOk := []*Expense{
+		{INR: 9000.00, TelegID: 5157350442, DtTm: time.Now(), Desc: "court booking", Cat: "court"},
+		{INR: 1000.00, TelegID: 5157350442, DtTm: time.Now(), Desc: "court booking extra", Cat: "Court"},
+		{INR: 1050.00, TelegID: 498116745, DtTm: time.Now(), Desc: "mavis 350", Cat: "shuttles"},
+		{INR: 200.00, TelegID: 498116745, DtTm: time.Now(), Desc: "water bottles"},
+	}
+	for _, d := range dataOk {
+		assert.Nil(t, RecordExpense(d, adp), "Unexpected error when recording expense with category")
+	}
+	err = RecordExpense(&Expense{INR: 200.00, TelegID: 498116745, DtTm: time.Now(), Desc: "beer", Cat: "party"}, adp)
+	assert.NotNil(t, err, "Unexpected nil error when recording expense with unknown category")
+
+	// TEST: adding a category keeps the defaults
+	assert.Nil(t, AddExpenseCategory(&ExpenseCategory{Name: "party", AddedBy: 5157350442, DtTm: time.Now()}, ctgry), "Unexpected error adding category")
+	cats, _ = ExpenseCategories(ctgry)
+	assert.Equal(t, len(DEFAULT_EXPNS_CTGRY)+1, len(cats), "Unexpected number of categories after adding one")
+	assert.NotNil(t, AddExpenseCategory(&ExpenseCategory{Name: "party time"}, ctgry), "Unexpected nil error for invalid category name")
+	assert.Nil(t, RemoveExpenseCategory(&ExpenseCategory{Name: "party"}, ctgry), "Unexpected error removing category")
+	assert.NotNil(t, RemoveExpenseCategory(&ExpenseCategory{Name: "party"}, ctgry), "Unexpected nil error removing missing category")
+	// TEST: default category can be removed before any category is added, the others stay
+	sess.DB("").C("expcategories").RemoveAll(bson.M{})
+	assert.Nil(t, RemoveExpenseCategory(&ExpenseCategory{Name: "misc", AddedBy: 5157350442}, ctgry), "Unexpected error removing a default category")
+	cats, _ = ExpenseCategories(ctgry)
+	assert.Equal(t, []string{"court", "shuttles"}, cats, "Unexpected categories after removing a default")
+	// TEST: last category cannot be removed, the defaults would come back otherwise
+	assert.Nil(t, RemoveExpenseCategory(&ExpenseCategory{Name: "shuttles"}, ctgry), "Unexpected error removing category")
+	assert.NotNil(t, RemoveExpenseCategory(&ExpenseCategory{Name: "court"}, ctgry), "Unexpected nil error removing the last category")
+	sess.DB("").C("expcategories").RemoveAll(bson.M{})
+
+	ceq := &CtgryExpnsQry{Dttm: time.Now()}
+	assert.Nil(t, ExpensesByCategory(ceq, adp), "Unexpected error getting expenses by category")
+	assert.Equal(t, 3, len(ceq.Totals), "Unexpected number of categories in the break down")
+	assert.Equal(t, "court", ceq.Totals[0].Cat, "Unexpected top category")
+	assert.Equal(t, float32(10000.00), ceq.Totals[0].Total, "Unexpected total for the top category")
+}
+
+func TestRecurringExpenses(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	for _, c := range []string{"expenses", "transacs", "recurexpenses"} {
+		sess.DB("").C(c).RemoveAll(bson.M{})
+		defer sess.DB("").C(c).RemoveAll(bson.M{})
+	}
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "recurexpenses")
+
+	// TEST: invalid definitions are rejected
+	dataNotOk := []*RecurringExpense{
+		{TelegID: 5157350442, Day: 0, INR: 9000.00, Desc: "court rent"},
+		{TelegID: 5157350442, Day: 31, INR: 9000.00, Desc: "court rent"},
+		{TelegID: 5157350442, Day: 5, INR: 0.0, Desc: "court rent"},
+		{TelegID: 5157350442, Day: 5, INR: 9000.00, Desc: " "},
+	}
+	for _, d := range dataNotOk {
+		assert.NotNil(t, AddRecurringExpense(d, adp), "Unexpected nil error for invalid recurring expense")
+	}
+	rent := &RecurringExpense{TelegID: 5157350442, Day: 1, INR: 9000.00, Desc: "court rent", AddedBy: 5157350442, DtTm: time.Now()}
+	coach := &RecurringExpense{TelegID: 498116745, Day: 1, INR: 3000.00, Desc: "coaching fees", AddedBy: 5157350442, DtTm: time.Now()}
+	for _, d := range []*RecurringExpense{rent, coach} {
+		assert.Nil(t, AddRecurringExpense(d, adp), "Unexpected error when adding recurring expense")
+	}
+	all, err := RecurringExpenses(adp)
+	assert.Nil(t, err, "Unexpected error when listing recurring expenses")
+	assert.Equal(t, 2, len(all), "Unexpected count of recurring expenses")
+
+	// TEST: paused ones are not posted, posting twice in the same month posts only once
+	coach.Paused = true
+	assert.Nil(t, PauseRecurringExpense(coach, adp), "Unexpected error when pausing recurring expense")
+	posted, err := PostDueRecurring(time.Now(), adp)
+	assert.Nil(t, err, "Unexpected error when posting due recurring expenses")
+	assert.Equal(t, 1, len(posted), "Unexpected count of posted recurring expenses")
+	posted, err = PostDueRecurring(time.Now(), adp)
+	assert.Nil(t, err, "Unexpected error when posting due recurring expenses again")
+	assert.Equal(t, 0, len(posted), "Recurring expense posted twice in the same month")
+	count, _ := sess.DB("").C("expenses").Find(bson.M{"desc": "court rent"}).Count()
+	assert.Equal(t, 1, count, "Unexpected count of expenses from recurring")
+
+	// TEST: deleting unknown / known recurring expense
+	assert.NotNil(t, DeleteRecurringExpense(&RecurringExpense{Id: bson.NewObjectId()}, adp), "Unexpected nil error deleting unknown recurring expense")
+	assert.Nil(t, DeleteRecurringExpense(coach, adp), "Unexpected error deleting recurring expense")
+}
+
+func TestMonthlyBudget(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	for _, c := range []string{"expenses", "transacs", "budgets", "recurexpenses", "periodlocks"} {
+		sess.DB("").C(c).RemoveAll(bson.M{})
+		defer sess.DB("").C(c).RemoveAll(bson.M{})
+	}
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "budgets")
+	thisMonth, _ := ParsePeriod("")
+	lastMonth := thisMonth.AddDate(0, -1, 0)
+	sess.DB("").C("expenses").Insert(&Expense{INR: 8000.00, TelegID: 5157350442, DtTm: lastMonth.AddDate(0, 0, 2), Desc: "court booking"})
+	sess.DB("").C("recurexpenses").Insert(&RecurringExpense{INR: 9000.00, TelegID: 5157350442, Day: 1, Desc: "court rent"})
+
+	// TEST: budget derived from the larger of last month & recurring expenses
+	bq := &BudgetQ{Month: PeriodOf(thisMonth)}
+	assert.Nil(t, MonthlyBudget(bq, adp), "Unexpected error getting derived budget")
+	assert.True(t, bq.Derived, "Unexpected budget set when none was set")
+	assert.Equal(t, float32(9000.00), bq.Budget, "Unexpected derived budget")
+	assert.Equal(t, float32(9000.00), bq.Cost(), "Unexpected cost of the month")
+
+	// TEST: budget set by admin, actual expenses exceeding it drive the cost
+	assert.NotNil(t, SetBudget(&Budget{Month: PeriodOf(thisMonth), INR: 0.0}, adp), "Unexpected nil error for zero budget")
+	assert.Nil(t, SetBudget(&Budget{Month: PeriodOf(thisMonth), INR: 10000.00, SetBy: 5157350442, DtTm: time.Now()}, adp), "Unexpected error setting budget")
+	assert.Nil(t, SetBudget(&Budget{Month: PeriodOf(thisMonth), INR: 11000.00, SetBy: 5157350442, DtTm: time.Now()}, adp), "Unexpected error revising budget")
+	bq = &BudgetQ{Month: PeriodOf(thisMonth)}
+	assert.Nil(t, MonthlyBudget(bq, adp), "Unexpected error getting budget")
+	assert.False(t, bq.Derived, "Unexpected derived budget when set by admin")
+	assert.Equal(t, float32(11000.00), bq.Cost(), "Unexpected cost of the month")
+	sess.DB("").C("expenses").Insert(&Expense{INR: 12000.00, TelegID: 5157350442, DtTm: thisMonth, Desc: "court booking"})
+	assert.Nil(t, MonthlyBudget(bq, adp), "Unexpected error getting budget")
+	assert.Equal(t, float32(12000.00), bq.Cost(), "Unexpected cost of the month when actual exceeds budget")
+
+	// TEST: true-up of last month distributes the difference in proportion to the debits
+	for _, tr := range []*Transac{
+		{TelegID: 5157350442, Debit: 6000.00, Desc: PLAYDAY_DESC, DtTm: lastMonth.AddDate(0, 0, 3)},
+		{TelegID: 498116745, Debit: 4000.00, Desc: PLAYDAY_DESC, DtTm: lastMonth.AddDate(0, 0, 3)},
+	} {
+		sess.DB("").C("transacs").Insert(tr)
+	}
+	trueups, err := TrueUpMonth(PeriodOf(lastMonth), adp)
+	assert.Nil(t, err, "Unexpected error truing up the month")
+	assert.Equal(t, 2, len(trueups), "Unexpected number of true-up transactions")
+	net := float32(0.0)
+	for _, tr := range trueups {
+		net += tr.Debit
+	}
+	assert.Equal(t, float32(-2000.00), net, "Unexpected net true-up, over recovery should be returned")
+	trueups, _ = TrueUpMonth(PeriodOf(lastMonth), adp)
+	assert.Equal(t, 0, len(trueups), "Unexpected true-up of a month already settled")
+}
+
+func TestExpenseApproval(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	for _, c := range []string{"expenses", "transacs"} {
+		sess.DB("").C(c).RemoveAll(bson.M{})
+		defer sess.DB("").C(c).RemoveAll(bson.M{})
+	}
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "expenses")
+	transacs := sess.DB("").C("transacs")
+
+	small := &Expense{INR: 500.00, TelegID: 5157350442, DtTm: time.Now(), Desc: "shuttles"}
+	large := &Expense{INR: 9000.00, TelegID: 5157350442, DtTm: time.Now(), Desc: "court rent", Status: EXPNS_PENDING}
+	rejected := &Expense{INR: 7000.00, TelegID: 498116745, DtTm: time.Now(), Desc: "new racquet", Status: EXPNS_PENDING}
+	for _, e := range []*Expense{small, large, rejected} {
+		assert.Nil(t, RecordExpense(e, adp), "Unexpected error when recording expense")
+	}
+	// TEST: pending expenses have no credit and do not count in the team expense
+	count, _ := transacs.Find(bson.M{"expid": large.Id}).Count()
+	assert.Equal(t, 0, count, "Unexpected credit for a pending expense")
+	mq := &MnthlyExpnsQry{Dttm: time.Now()}
+	assert.Nil(t, TeamMonthlyExpense(mq, adp), "Unexpected error getting team expense")
+	assert.Equal(t, float32(500.00), mq.Total, "Unexpected team expense with pending expenses")
+
+	// TEST: member cannot approve their own expense
+	err := ReviewExpense(&Expense{Id: large.Id, Status: EXPNS_APPROVED, ReviewedBy: 5157350442}, adp)
+	de, ok := err.(*DomainError)
+	assert.True(t, ok && errors.Is(de.Err, ERR_SELFREVIEW), "Unexpected error approving own expense")
+	// TEST: approving credits the expense, reviewing again is an error
+	assert.Nil(t, ReviewExpense(&Expense{Id: large.Id, Status: EXPNS_APPROVED, ReviewedBy: 498116745}, adp), "Unexpected error approving expense")
+	count, _ = transacs.Find(bson.M{"expid": large.Id}).Count()
+	assert.Equal(t, 1, count, "Unexpected credit count for an approved expense")
+	err = ReviewExpense(&Expense{Id: large.Id, Status: EXPNS_REJECTED, ReviewedBy: 498116745}, adp)
+	de, ok = err.(*DomainError)
+	assert.True(t, ok && errors.Is(de.Err, ERR_NOTPENDING), "Unexpected error reviewing an expense already approved")
+	// TEST: managers approving together credit the expense only once
+	racing := &Expense{INR: 6000.00, TelegID: 5157350442, DtTm: time.Now(), Desc: "nets", Status: EXPNS_PENDING}
+	assert.Nil(t, RecordExpense(racing, adp), "Unexpected error when recording expense")
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ReviewExpense(&Expense{Id: racing.Id, Status: EXPNS_APPROVED, ReviewedBy: 498116745}, adp)
+		}()
+	}
+	wg.Wait()
+	count, _ = transacs.Find(bson.M{"expid": racing.Id}).Count()
+	assert.Equal(t, 1, count, "Unexpected credit count for an expense approved together")
+
+	// TEST: rejected expense has no credit and does not count
+	assert.Nil(t, ReviewExpense(&Expense{Id: rejected.Id, Status: EXPNS_REJECTED, ReviewedBy: 5157350442}, adp), "Unexpected error rejecting expense")
+	count, _ = transacs.Find(bson.M{"expid": rejected.Id}).Count()
+	assert.Equal(t, 0, count, "Unexpected credit for a rejected expense")
+	assert.Nil(t, TeamMonthlyExpense(mq, adp), "Unexpected error getting team expense")
+	assert.Equal(t, float32(15500.00), mq.Total, "Unexpected team expense after reviews")
+}
+
+func TestAccountByUName(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	coll := sess.DB("").C(TEST_MONGO_COLL)
+	coll.RemoveAll(bson.M{})
+	defer coll.RemoveAll(bson.M{})
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, TEST_MONGO_COLL)
+	assert.Nil(t, RegisterNewAccount(&UserAccount{TelegID: 5435345, Email: "cayce0@bbb.org", Name: "Conrado Ayce", UName: "ConradoA"}, adp), "Unexpected error when registering new account")
+	assert.Nil(t, RegisterNewAccount(&UserAccount{TelegID: 5435346, Email: "rscimoni1@paypal.com", Name: "Reagen Scimon"}, adp), "Unexpected error when registering new account")
+
+	// TEST: username is matched case insensitive, with or without @
+	for _, uname := range []string{"conradoa", "@ConradoA"} {
+		ua := &UserAccount{UName: uname}
+		assert.Nil(t, AccountByUName(ua, adp), "Unexpected error getting account by username")
+		assert.Equal(t, int64(5435345), ua.TelegID, "Unexpected account for the username")
+	}
+	// TEST: unknown and archived accounts are not found
+	assert.NotNil(t, AccountByUName(&UserAccount{UName: "reagens"}, adp), "Unexpected nil error for unknown username")
+	archive := true
+	coll.Update(bson.M{"tid": 5435345}, bson.M{"$set": bson.M{"archive": archive}})
+	assert.NotNil(t, AccountByUName(&UserAccount{UName: "conradoa"}, adp), "Unexpected nil error for archived account")
+}
+
+func TestSplitApportion(t *testing.T) {
+	sp := &Split{INR: 1000.00, Shares: []SplitShare{{TelegID: 1, Weight: 1}, {TelegID: 2, Weight: 1}, {TelegID: 3, Weight: 1}}}
+	sp.Apportion()
+	assert.Equal(t, float32(333.00), sp.Shares[0].INR, "Unexpected equal share")
+	assert.Equal(t, float32(334.00), sp.Shares[2].INR, "Unexpected share of the last member, should take the rounding difference")
+	sp = &Split{INR: 900.00, Shares: []SplitShare{{TelegID: 1, Weight: 2}, {TelegID: 2, Weight: 1}}}
+	sp.Apportion()
+	assert.Equal(t, float32(600.00), sp.Shares[0].INR, "Unexpected weighted share")
+	assert.Equal(t, float32(300.00), sp.Shares[1].INR, "Unexpected weighted share")
+	// TEST: amounts smaller than or close to the member count, no share goes negative or gets skewed
+	equal := func(inr float32, n int) []float32 {
+		sp := &Split{INR: inr}
+		for i := 0; i < n; i++ {
+			sp.Shares = append(sp.Shares, SplitShare{TelegID: int64(i + 1), Weight: 1})
+		}
+		sp.Apportion()
+		shares := []float32{}
+		for _, s := range sp.Shares {
+			shares = append(shares, s.INR)
+		}
+		return shares
+	}
+	assert.Equal(t, []float32{0, 0, 1, 1, 1}, equal(3.00, 5), "Unexpected shares for an amount smaller than the member count")
+	assert.Equal(t, []float32{1, 1, 1, 1, 2, 2, 2}, equal(10.00, 7), "Unexpected shares, leftover should be spread one rupee at a time")
+	assert.Equal(t, []float32{0, 0, 0, 0}, equal(0.00, 4), "Unexpected shares for nothing to split")
+	sp = &Split{INR: 11.00, Shares: []SplitShare{{TelegID: 1, Weight: 3}, {TelegID: 2, Weight: 1}, {TelegID: 3, Weight: 1}}}
+	sp.Apportion()
+	assert.Equal(t, float32(7.00), sp.Shares[0].INR, "Unexpected weighted share, largest fraction gets the leftover")
+	assert.Equal(t, float32(2.00), sp.Shares[1].INR, "Unexpected weighted share")
+	assert.Equal(t, float32(2.00), sp.Shares[2].INR, "Unexpected weighted share")
+	sp = &Split{INR: 100.00, Shares: []SplitShare{{TelegID: 1, Weight: 1}, {TelegID: 2, Weight: -1}}}
+	sp.Apportion()
+	assert.Equal(t, float32(0.00), sp.Shares[0].INR, "Unexpected share for negative weights")
+}
+
+func TestRecordSplit(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	for _, c := range []string{"splits", "transacs"} {
+		sess.DB("").C(c).RemoveAll(bson.M{})
+		defer sess.DB("").C(c).RemoveAll(bson.M{})
+	}
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "splits")
+	// TEST: invalid splits
+	dataNotOk := []*Split{
+		{PaidBy: 5157350442, INR: 0.0, DtTm: time.Now(), Shares: []SplitShare{{TelegID: 498116745, Weight: 1}}},
+		{PaidBy: 5157350442, INR: 900.0, DtTm: time.Now()},
+		{PaidBy: 5157350442, INR: 900.0, DtTm: time.Now(), Shares: []SplitShare{{TelegID: 498116745, Weight: 0}}},
+		{PaidBy: 5157350442, INR: 900.0, DtTm: time.Now(), Shares: []SplitShare{{TelegID: 498116745, Weight: 1}, {TelegID: 498116745, Weight: 1}}},
+	}
+	for _, d := range dataNotOk {
+		assert.NotNil(t, RecordSplit(d, adp), "Unexpected nil error for invalid split")
+	}
+	// TEST: split is posted, and stays out of the recovery
+	sp := &Split{PaidBy: 5157350442, INR: 900.0, Desc: PLAYDAY_DESC, DtTm: time.Now(), Shares: []SplitShare{{TelegID: 5157350442, Weight: 1}, {TelegID: 498116745, Weight: 2}}}
+	assert.Nil(t, RecordSplit(sp, adp), "Unexpected error recording split")
+	count, _ := sess.DB("").C("transacs").Find(bson.M{"batch": sp.Id, "kind": KIND_SPLIT}).Count()
+	assert.Equal(t, 3, count, "Unexpected number of split transactions")
+	from, to := TodayAsBoundary()
+	trq := &TransacQ{From: from, To: to}
+	assert.Nil(t, TotalPlaydayDebits(trq, adp.Switch("transacs")), "Unexpected error getting playday debits")
+	assert.Equal(t, float32(0.0), trq.Debits, "Split debits counted towards the recovery")
+}
+
+func TestSimplifyDebts(t *testing.T) {
+	// TEST: one creditor, many debtors
+	balances := []Balance{{TelegID: 1, Due: 600.00}, {TelegID: 2, Due: -200.00}, {TelegID: 3, Due: -400.00}}
+	settlements := SimplifyDebts(balances)
+	assert.Equal(t, []Settlement{{From: 3, To: 1, INR: 400.00}, {From: 2, To: 1, INR: 200.00}}, settlements, "Unexpected settlements")
+	// TEST: chain of debts collapses, A owes B owes C
+	balances = []Balance{{TelegID: 1, Due: -100.00}, {TelegID: 2, Due: 0.00}, {TelegID: 3, Due: 100.00}}
+	settlements = SimplifyDebts(balances)
+	assert.Equal(t, []Settlement{{From: 1, To: 3, INR: 100.00}}, settlements, "Unexpected settlements for chained debts")
+	// TEST: balances that do not add up, remaining is with the pool
+	balances = []Balance{{TelegID: 1, Due: 1000.00}, {TelegID: 2, Due: -300.00}, {TelegID: 3, Due: 0.40}}
+	settlements = SimplifyDebts(balances)
+	assert.Equal(t, []Settlement{{From: 2, To: 1, INR: 300.00}}, settlements, "Unexpected settlements when balances do not add up")
+	assert.Equal(t, 0, len(SimplifyDebts([]Balance{})), "Unexpected settlements for no balances")
+}
+
+func TestRecordTransfer(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	coll := sess.DB("").C("transacs")
+	coll.RemoveAll(bson.M{})
+	defer coll.RemoveAll(bson.M{})
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "transacs")
+	assert.NotNil(t, RecordTransfer(&Transfer{Payer: 5157350442, Payee: 5157350442, INR: 100.00, DtTm: time.Now()}, adp), "Unexpected nil error paying self")
+	assert.NotNil(t, RecordTransfer(&Transfer{Payer: 5157350442, Payee: 498116745, INR: 0.0, DtTm: time.Now()}, adp), "Unexpected nil error paying zero")
+	coll.Insert(&Transac{TelegID: 498116745, Credit: 500.00, DtTm: time.Now(), Desc: "shuttles"})
+	coll.Insert(&Transac{TelegID: 5157350442, Debit: 500.00, DtTm: time.Now(), Desc: PLAYDAY_DESC})
+	// TEST: paying the member settles the debt between them
+	assert.Nil(t, RecordTransfer(&Transfer{Payer: 5157350442, Payee: 498116745, INR: 500.00, DtTm: time.Now()}, adp), "Unexpected error recording transfer")
+	balances, err := MemberBalances(adp)
+	assert.Nil(t, err, "Unexpected error getting member balances")
+	assert.Equal(t, 0, len(SimplifyDebts(balances)), "Unexpected settlements after the debt was paid")
+}
+
+func TestPaymentConfirmation(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	for _, c := range []string{"payments", "transacs"} {
+		sess.DB("").C(c).RemoveAll(bson.M{})
+		defer sess.DB("").C(c).RemoveAll(bson.M{})
+	}
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "payments")
+	assert.NotNil(t, RequestPayment(&PendingPayment{TelegID: 5157350442, INR: 0.0, DtTm: time.Now()}, adp), "Unexpected nil error for zero payment")
+	pp := &PendingPayment{TelegID: 5157350442, INR: 500.00, DtTm: time.Now()}
+	assert.Nil(t, RequestPayment(pp, adp), "Unexpected error requesting payment")
+	// TEST: pending payment isnt credited
+	count, _ := sess.DB("").C("transacs").Find(bson.M{"tid": 5157350442}).Count()
+	assert.Equal(t, 0, count, "Unexpected credit before the payment is confirmed")
+	pending, err := PendingPayments(time.Time{}, adp)
+	assert.Nil(t, err, "Unexpected error getting pending payments")
+	assert.Equal(t, 1, len(pending), "Unexpected number of pending payments")
+	pending, _ = PendingPayments(time.Now().Add(-24*time.Hour), adp)
+	assert.Equal(t, 0, len(pending), "Unexpected payment due for reminder")
+	// TEST: manager cannot confirm their own payment
+	err = ReviewPayment(&PendingPayment{Id: pp.Id, Status: PAYMNT_CONFIRMED, ReviewedBy: 5157350442}, adp)
+	de, ok := err.(*DomainError)
+	assert.True(t, ok && errors.Is(de.Err, ERR_SELFREVIEW), "Unexpected error confirming own payment")
+	// TEST: confirming credits the dues, confirming again is an error
+	assert.Nil(t, ReviewPayment(&PendingPayment{Id: pp.Id, Status: PAYMNT_CONFIRMED, ReviewedBy: 498116745}, adp), "Unexpected error confirming payment")
+	count, _ = sess.DB("").C("transacs").Find(bson.M{"tid": 5157350442, "credit": 500.00}).Count()
+	assert.Equal(t, 1, count, "Unexpected credit after the payment is confirmed")
+	err = ReviewPayment(&PendingPayment{Id: pp.Id, Status: PAYMNT_DECLINED, ReviewedBy: 498116745}, adp)
+	de, ok = err.(*DomainError)
+	assert.True(t, ok && errors.Is(de.Err, ERR_PAYMNT404), "Unexpected error reviewing a payment already confirmed")
+	// TEST: payment claimed in a month closed before the confirmation is credited in the month confirmed
+	thisMonth, _ := MonthAsBoundary()
+	lastMonth := thisMonth.AddDate(0, -1, 20)
+	sess.DB("").C("periodlocks").Insert(&PeriodLock{Month: PeriodOf(lastMonth), LockedBy: 498116745, DtTm: time.Now()})
+	defer sess.DB("").C("periodlocks").RemoveAll(bson.M{})
+	pp = &PendingPayment{TelegID: 5157350442, INR: 300.00, DtTm: lastMonth}
+	assert.Nil(t, RequestPayment(pp, adp), "Unexpected error requesting payment")
+	assert.Nil(t, ReviewPayment(&PendingPayment{Id: pp.Id, Status: PAYMNT_CONFIRMED, ReviewedBy: 498116745}, adp), "Unexpected error confirming payment claimed in a closed month")
+	count, _ = sess.DB("").C("transacs").Find(bson.M{"tid": 5157350442, "credit": 300.00, "dttm": bson.M{"$gte": thisMonth}}).Count()
+	assert.Equal(t, 1, count, "Unexpected credit not in the month of confirmation")
+}
+
+func TestUPILink(t *testing.T) {
+	link := UPILink("treasurer@upi", "PSA Badminton", 1234.5, "Dues 5157350442")
+	u, err := url.Parse(link)
+	assert.Nil(t, err, "Unexpected error parsing UPI link")
+	assert.Equal(t, "upi", u.Scheme, "Unexpected scheme for UPI link")
+	q := u.Query()
+	assert.Equal(t, "treasurer@upi", q.Get("pa"), "Unexpected payee address")
+	assert.Equal(t, "1234.50", q.Get("am"), "Unexpected amount, expected 2 decimals")
+	assert.Equal(t, "PSA Badminton", q.Get("pn"), "Unexpected payee name")
+	assert.Equal(t, "Dues 5157350442", q.Get("tn"), "Unexpected transaction note")
+	// TEST: name and note are optional
+	assert.Equal(t, "upi://pay?pa=treasurer%40upi&am=100.00&cu=INR", UPILink("treasurer@upi", "", 100.0, ""), "Unexpected link without name and note")
+}
+
+func TestDuesNote(t *testing.T) {
+	tid, ok := TelegIDFromNote(DuesNote(5157350442))
+	assert.True(t, ok, "Unexpected fail reading back the dues note")
+	assert.Equal(t, int64(5157350442), tid, "Unexpected account from the dues note")
+	for _, note := range []string{"", "Dues", "Dues abc", "shuttles 5157350442", "Dues 5157350442 extra"} {
+		_, ok := TelegIDFromNote(note)
+		assert.False(t, ok, "Unexpected pass reading note %s", note)
+	}
+}
+
+func TestGatewayPayment(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	for _, c := range []string{"payments", "transacs"} {
+		sess.DB("").C(c).RemoveAll(bson.M{})
+		defer sess.DB("").C(c).RemoveAll(bson.M{})
+	}
+	sess.DB("").C("payments").EnsureIndex(PAYMENT_GWID_INDEX)
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "payments")
+	assert.NotNil(t, GatewayPayment(&PendingPayment{TelegID: 5157350442, INR: 500.00, DtTm: time.Now()}, adp), "Unexpected nil error for payment without gateway id")
+	// TEST: webhook delivered twice credits only once
+	for i := 0; i < 2; i++ {
+		pp := &PendingPayment{TelegID: 5157350442, INR: 500.00, DtTm: time.Now(), GwId: "pay_MZ1sVxLhJ5XaEW"}
+		assert.Nil(t, GatewayPayment(pp, adp), "Unexpected error recording gateway payment")
+		assert.Equal(t, PAYMNT_GATEWAY, pp.Status, "Unexpected status of gateway payment")
+	}
+	count, _ := sess.DB("").C("transacs").Find(bson.M{"tid": 5157350442, "credit": 500.00}).Count()
+	assert.Equal(t, 1, count, "Unexpected number of credits for the same gateway payment")
+	// TEST: webhook deliveries racing each other credit only once
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pp := &PendingPayment{TelegID: 5157350442, INR: 300.00, DtTm: time.Now(), GwId: "pay_MZ2aBcLhJ5XaEW"}
+			assert.Nil(t, GatewayPayment(pp, dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "payments")), "Unexpected error recording concurrent gateway payment")
+		}()
+	}
+	wg.Wait()
+	count, _ = sess.DB("").C("transacs").Find(bson.M{"tid": 5157350442, "credit": 300.00}).Count()
+	assert.Equal(t, 1, count, "Unexpected number of credits for concurrent deliveries of the same gateway payment")
+	// TEST: payment not mapped to a member / for an unknown member is left pending, not dropped
+	for _, tid := range []int64{0, 1001} {
+		pp := &PendingPayment{TelegID: tid, INR: 700.00, DtTm: time.Now(), GwId: fmt.Sprintf("pay_MZ3unknown%d", tid)}
+		assert.Nil(t, GatewayPayment(pp, adp), "Unexpected error recording gateway payment without member")
+		assert.Equal(t, PAYMNT_PENDING, pp.Status, "Unexpected status of gateway payment without member")
+		assert.Equal(t, int64(0), pp.TelegID, "Unexpected member of gateway payment without member")
+	}
+	unknown := &PendingPayment{}
+	sess.DB("").C("payments").Find(bson.M{"gwid": "pay_MZ3unknown0"}).One(unknown)
+	// TEST: managers confirm it against the member, member is required
+	err := ReviewPayment(&PendingPayment{Id: unknown.Id, Status: PAYMNT_CONFIRMED, ReviewedBy: 498116745}, adp)
+	de, ok := err.(*DomainError)
+	assert.True(t, ok && errors.Is(de.Err, ERR_INVLPARAM), "Unexpected error confirming gateway payment without member")
+	assert.Nil(t, ReviewPayment(&PendingPayment{Id: unknown.Id, TelegID: 5157350442, Status: PAYMNT_CONFIRMED, ReviewedBy: 498116745}, adp), "Unexpected error confirming gateway payment against member")
+	count, _ = sess.DB("").C("transacs").Find(bson.M{"tid": 5157350442, "credit": 700.00}).Count()
+	assert.Equal(t, 1, count, "Unexpected credit for the gateway payment confirmed against member")
+	// TEST: payment that cannot clear the dues in a closed month is left pending
+	sess.DB("").C("periodlocks").Insert(&PeriodLock{Month: PeriodOf(time.Now()), LockedBy: 498116745, DtTm: time.Now()})
+	defer sess.DB("").C("periodlocks").RemoveAll(bson.M{})
+	pp := &PendingPayment{TelegID: 5157350442, INR: 900.00, DtTm: time.Now(), GwId: "pay_MZ4lockedEW"}
+	assert.Nil(t, GatewayPayment(pp, adp), "Unexpected error recording gateway payment in a closed month")
+	assert.Equal(t, PAYMNT_PENDING, pp.Status, "Unexpected status of gateway payment in a closed month")
+	count, _ = sess.DB("").C("payments").Find(bson.M{"gwid": "pay_MZ4lockedEW", "status": PAYMNT_PENDING}).Count()
+	assert.Equal(t, 1, count, "Gateway payment in a closed month was not kept")
+}
+
+func TestJobRuns(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	coll := sess.DB("").C("jobs")
+	coll.RemoveAll(bson.M{})
+	defer coll.RemoveAll(bson.M{})
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "jobs")
+	jr := &JobRun{Name: "debit-adjust"}
+	err := JobRunOf(jr, adp)
+	de, ok := err.(*DomainError)
+	assert.True(t, ok && errors.Is(de.Err, ERR_JOB404), "Unexpected error for job never scheduled")
+	next := time.Now().Add(time.Hour).Truncate(time.Second)
+	assert.Nil(t, SaveJobRun(&JobRun{Name: "debit-adjust", Spec: "30 11 * * *", NextRun: next}, adp), "Unexpected error saving job run")
+	// TEST: saving again updates the same job
+	assert.Nil(t, SaveJobRun(&JobRun{Name: "debit-adjust", Spec: "30 11 * * *", LastRun: time.Now(), NextRun: next.Add(24 * time.Hour)}, adp), "Unexpected error saving job run")
+	assert.Nil(t, JobRunOf(jr, adp), "Unexpected error getting job run")
+	assert.True(t, next.Add(24*time.Hour).Equal(jr.NextRun), "Unexpected next run of the job")
+	runs, err := JobRuns(adp)
+	assert.Nil(t, err, "Unexpected error getting job runs")
+	assert.Equal(t, 1, len(runs), "Unexpected number of jobs")
+}
+
+func TestEstimatePoll(t *testing.T) {
+	data := []struct {
+		month    time.Time
+		days     int
+		weekends int
+	}{
+		{time.Date(2023, time.July, 1, 0, 0, 0, 0, time.Local), 31, 10},
+		{time.Date(2023, time.February, 1, 0, 0, 0, 0, time.Local), 28, 8},
+		{time.Date(2024, time.February, 1, 0, 0, 0, 0, time.Local), 29, 8},
+		{time.Date(2023, time.September, 1, 0, 0, 0, 0, time.Local), 30, 9},
+	}
+	for _, d := range data {
+		sp := EstimatePoll(d.month)
+		assert.Equal(t, 4, len(sp.Options), "Unexpected number of poll options")
+		assert.Equal(t, len(sp.Options), len(sp.OptionTexts()), "Unexpected number of option texts")
+		for i, want := range []int{d.days, d.days / 2, d.weekends, 0} {
+			got, err := sp.Playdays(i)
+			assert.Nil(t, err, "Unexpected error getting playdays for option")
+			assert.Equal(t, want, got, "Unexpected playdays for option %d in %s", i, d.month.Month())
+		}
+		// TEST: options not on the poll are errors, not some default playdays
+		_, err := sp.Playdays(len(sp.Options))
+		assert.NotNil(t, err, "Unexpected nil error for option out of range")
+		_, err = sp.Playdays(-1)
+		assert.NotNil(t, err, "Unexpected nil error for negative option")
+	}
+
+	// TEST: poll asks about the month, answers are taken till the month ends
+	sp := EstimatePoll(time.Date(2023, time.July, 1, 0, 0, 0, 0, time.Local))
+	assert.Equal(t, "2023-07", sp.Month, "Unexpected month on the poll")
+	sentOn := time.Date(2023, time.June, 26, 11, 0, 0, 0, time.Local)
+	assert.False(t, sp.Expired(sentOn), "Unexpected expired poll when sent")
+	assert.False(t, sp.Expired(time.Date(2023, time.July, 31, 22, 0, 0, 0, time.Local)), "Unexpected expired poll within the month")
+	assert.True(t, sp.Expired(time.Date(2023, time.August, 1, 0, 0, 0, 0, time.Local)), "Unexpected poll not expired after the month")
+	assert.True(t, (&SentPoll{}).Expired(sentOn), "Unexpected poll without month not expired")
+	// TEST: answers before the month are estimates for the month, not the month they were answered in
+	assert.Equal(t, "2023-07", PeriodOf(sp.EstimateMonth(sentOn)), "Unexpected month for the estimate answered before the month")
+	midMonth := time.Date(2023, time.July, 10, 9, 0, 0, 0, time.Local)
+	assert.Equal(t, midMonth, sp.EstimateMonth(midMonth), "Unexpected date for the estimate answered within the month")
+}
+
+func TestRecordPoll(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	coll := sess.DB("").C("polls")
+	coll.RemoveAll(bson.M{})
+	defer coll.RemoveAll(bson.M{})
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "polls")
+	sp := EstimatePoll(time.Date(2023, time.July, 1, 0, 0, 0, 0, time.Local))
+	assert.NotNil(t, RecordPoll(sp, adp), "Unexpected nil error recording poll without id")
+	sp.PollId, sp.DtTm = "5350906283714150403", time.Now()
+	assert.NotNil(t, RecordPoll(&SentPoll{PollId: sp.PollId, Options: sp.Options}, adp), "Unexpected nil error recording poll without month")
+	assert.Nil(t, RecordPoll(sp, adp), "Unexpected error recording poll")
+	found := &SentPoll{PollId: sp.PollId}
+	assert.Nil(t, PollOf(found, adp), "Unexpected error getting poll")
+	assert.Equal(t, sp.Options, found.Options, "Unexpected options on the stored poll")
+	err := PollOf(&SentPoll{PollId: "unknown"}, adp)
+	de, ok := err.(*DomainError)
+	assert.True(t, ok && errors.Is(de.Err, ERR_POLL404), "Unexpected error for unknown poll")
+}
+
+func TestEstimateForNextMonth(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	coll := sess.DB("").C("estimates")
+	coll.RemoveAll(bson.M{})
+	defer coll.RemoveAll(bson.M{})
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "estimates")
+	thisMonth, _ := MonthAsBoundary()
+	nextMonth := thisMonth.AddDate(0, 1, 0)
+	assert.Nil(t, UpsertEstimate(&Estimate{TelegID: 5157350442, PlyDys: 10, DtTm: nextMonth}, adp), "Unexpected error upserting estimate for next month")
+	// TEST: estimate for next month isnt counted in this month
+	_, err := PlayerPlayDays(5157350442, adp)
+	assert.NotNil(t, err, "Unexpected nil error, estimate for next month counted in this month")
+	days, err := PlayerPlayDaysOf(5157350442, nextMonth, adp)
+	assert.Nil(t, err, "Unexpected error getting estimate for next month")
+	assert.Equal(t, 10, days, "Unexpected playdays for next month")
+	assert.Nil(t, UpsertEstimate(&Estimate{TelegID: 5157350442, PlyDys: 12, DtTm: nextMonth}, adp), "Unexpected error updating estimate for next month")
+	total, _ := TotalPlayDaysOf(nextMonth, adp)
+	assert.Equal(t, 12, total, "Unexpected total playdays for next month after update")
+}
+
+func TestMonthEstimates(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	coll := sess.DB("").C("estimates")
+	coll.RemoveAll(bson.M{})
+	defer coll.RemoveAll(bson.M{})
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "estimates")
+	eq := &EstimatesQ{}
+	assert.Nil(t, MonthEstimates(eq, adp), "Unexpected error when there are no estimates")
+	assert.Equal(t, 0, len(eq.Estimates), "Unexpected estimates for the month")
+	assert.Equal(t, PeriodOf(time.Now()), eq.Month, "Unexpected month for the estimates")
+	assert.NotNil(t, MonthEstimates(&EstimatesQ{Month: "2023-13"}, adp), "Unexpected nil error for invalid month")
+	for _, e := range []*Estimate{{TelegID: 5157350442, PlyDys: 8}, {TelegID: 498116745, PlyDys: 20}} {
+		assert.Nil(t, UpsertEstimate(e, adp), "Unexpected error upserting estimate")
+	}
+	assert.Nil(t, MonthEstimates(eq, adp), "Unexpected error getting estimates")
+	assert.Equal(t, 2, len(eq.Estimates), "Unexpected number of estimates")
+	assert.Equal(t, int64(498116745), eq.Estimates[0].TelegID, "Unexpected order, expected most days first")
+	assert.Equal(t, 28, eq.Total, "Unexpected total playdays")
+}
+
+func TestDefaultEstimates(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	for _, c := range []string{"accounts", "estimates", "polls"} {
+		sess.DB("").C(c).RemoveAll(bson.M{})
+		defer sess.DB("").C(c).RemoveAll(bson.M{})
+	}
+	active, archived := false, true
+	sess.DB("").C("accounts").Insert(
+		&UserAccount{TelegID: 5157350442, Name: "niranjan", Archived: &active},
+		&UserAccount{TelegID: 498116745, Name: "kedar", Archived: &active},
+		&UserAccount{TelegID: 1165670463, Name: "newbie", Archived: &active},
+		&UserAccount{TelegID: 6040151178, Name: "leftus", Archived: &archived},
+	)
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "polls")
+	thisMonth, _ := MonthAsBoundary()
+	sp := EstimatePoll(thisMonth)
+	sp.PollId, sp.DtTm = "5350906283714150403", time.Now()
+	assert.Nil(t, RecordPoll(sp, adp), "Unexpected error recording poll")
+	// kedar answered, niranjan played last month but did not answer, newbie has no history
+	assert.Nil(t, UpsertEstimate(&Estimate{TelegID: 498116745, PlyDys: 20}, adp.Switch("estimates")), "Unexpected error upserting estimate")
+	sess.DB("").C("estimates").Insert(&Estimate{TelegID: 5157350442, PlyDys: 8, DtTm: thisMonth.AddDate(0, -1, 2)})
+
+	members, err := NonRespondents(thisMonth, adp)
+	assert.Nil(t, err, "Unexpected error getting non-respondents")
+	assert.Equal(t, 2, len(members), "Unexpected number of non-respondents, archived accounts arent counted")
+	// TEST: default as last month, members without history are left out
+	added, err := DefaultEstimates(sp, -1, adp)
+	assert.Nil(t, err, "Unexpected error defaulting estimates")
+	assert.Equal(t, 1, len(added), "Unexpected number of default estimates")
+	days, _ := PlayerPlayDays(5157350442, adp.Switch("estimates"))
+	assert.Equal(t, 8, days, "Unexpected default estimate, expected same as last month")
+	assert.True(t, sp.Defaulted, "Unexpected poll not marked defaulted")
+	// TEST: defaults are applied only once for the poll
+	found := &SentPoll{Month: sp.Month}
+	assert.Nil(t, PollOfMonth(found, adp), "Unexpected error getting poll of the month")
+	added, _ = DefaultEstimates(found, 10, adp)
+	assert.Equal(t, 0, len(added), "Unexpected defaults applied again for the same poll")
+}
+
+func TestEstimateInForce(t *testing.T) {
+	month := time.Date(2023, time.August, 1, 0, 0, 0, 0, time.Local)
+	// TEST: estimates set before, within and after the month
+	assert.Equal(t, month, effectiveFrom(month, month.AddDate(0, 0, -5)), "Unexpected effective date for estimate ahead of the month")
+	assert.Equal(t, month.AddDate(0, 0, 14), effectiveFrom(month, month.AddDate(0, 0, 14).Add(10*time.Hour)), "Unexpected effective date for estimate within the month")
+	assert.Equal(t, month.AddDate(0, 0, 30), effectiveFrom(month, month.AddDate(0, 1, 3)), "Unexpected effective date for estimate after the month")
+	versions := []Estimate{
+		{TelegID: 5157350442, PlyDys: 10, Eff: month},
+		{TelegID: 498116745, PlyDys: 20, Eff: month},
+		{TelegID: 5157350442, PlyDys: 4, Eff: month.AddDate(0, 0, 14)},
+	}
+	// TEST: days before the change are priced by the earlier version
+	before := inForce(versions, month.AddDate(0, 0, 13).Add(20*time.Hour))
+	assert.Equal(t, 10, before[5157350442].PlyDys, "Unexpected version in force before the change")
+	after := inForce(versions, month.AddDate(0, 0, 14).Add(6*time.Hour))
+	assert.Equal(t, 4, after[5157350442].PlyDys, "Unexpected version in force on the day of change")
+	assert.Equal(t, 20, after[498116745].PlyDys, "Unexpected version for player who did not change")
+	assert.Equal(t, 0, len(inForce(versions, month.AddDate(0, 0, -1))), "Unexpected versions in force before the month")
+	// TEST: share in the message is url encoded, a raw percent sign would break the message
+	sq := &ShareQ{TelegID: 5157350442, Month: "2023-08", Versions: []ShareVersion{{Eff: month, PlyDys: 10, Total: 30}}}
+	assert.Contains(t, sq.ToMsgTxt(), "33.3%25", "Unexpected share in the message")
+	_, err := url.QueryUnescape(sq.ToMsgTxt())
+	assert.Nil(t, err, "Unexpected invalid escape in the message")
+}
+
+func TestPlayerShareHistory(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	coll := sess.DB("").C("estimates")
+	coll.RemoveAll(bson.M{})
+	defer coll.RemoveAll(bson.M{})
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "estimates")
+	from, _ := MonthAsBoundary()
+	today := effectiveFrom(from, time.Now())
+	// estimate answered on the poll ahead of the month, changed today
+	coll.Insert(&Estimate{TelegID: 5157350442, PlyDys: 10, DtTm: from, Eff: from}, &Estimate{TelegID: 498116745, PlyDys: 20, DtTm: from})
+	assert.Nil(t, UpsertEstimate(&Estimate{TelegID: 5157350442, PlyDys: 4}, adp), "Unexpected error changing estimate")
+	assert.Nil(t, UpsertEstimate(&Estimate{TelegID: 5157350442, PlyDys: 5}, adp), "Unexpected error changing estimate again on the same day")
+	count, _ := coll.Find(bson.M{"tid": 5157350442}).Count()
+	if today.Equal(from) {
+		assert.Equal(t, 1, count, "Unexpected versions when changed on the first day of the month")
+	} else {
+		assert.Equal(t, 2, count, "Unexpected versions, changes on the same day are expected to update")
+		days, _ := PlayerPlayDaysOf(5157350442, today.AddDate(0, 0, -1), adp)
+		assert.Equal(t, 10, days, "Unexpected playdays before the change")
+	}
+	days, _ := PlayerPlayDays(5157350442, adp)
+	assert.Equal(t, 5, days, "Unexpected playdays after the change")
+	total, _ := TotalPlayDays(adp)
+	assert.Equal(t, 25, total, "Unexpected total playdays after the change")
+
+	sq := &ShareQ{TelegID: 5157350442}
+	assert.Nil(t, PlayerShareHistory(sq, adp), "Unexpected error getting share history")
+	assert.Equal(t, 25, sq.Versions[len(sq.Versions)-1].Total, "Unexpected total for the latest version")
+	assert.NotNil(t, PlayerShareHistory(&ShareQ{TelegID: 1165670463}, adp), "Unexpected nil error for player without estimates")
+	t.Log(sq.ToMsgTxt())
+}
+
+func TestCostMode(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	coll := sess.DB("").C("costmodes")
+	coll.RemoveAll(bson.M{})
+	defer coll.RemoveAll(bson.M{})
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "costmodes")
+	grp := int64(-902469479)
+	// TEST: groups that havent chosen share by estimates
+	cm := &CostMode{GrpID: grp}
+	assert.Nil(t, CostModeOf(cm, adp), "Unexpected error getting cost mode")
+	assert.Equal(t, COSTMODE_ESTIMATES, cm.Mode, "Unexpected default cost mode")
+	assert.NotNil(t, SetCostMode(&CostMode{GrpID: grp, Mode: "random"}, adp), "Unexpected nil error for unknown cost mode")
+	for _, mode := range []string{COSTMODE_ATTENDANCE, COSTMODE_ESTIMATES, COSTMODE_ATTENDANCE} {
+		assert.Nil(t, SetCostMode(&CostMode{GrpID: grp, Mode: mode, SetBy: 5157350442, DtTm: time.Now()}, adp), "Unexpected error setting cost mode")
+	}
+	count, _ := coll.Find(bson.M{"grpid": grp}).Count()
+	assert.Equal(t, 1, count, "Unexpected number of cost modes for the group, expected mode to be replaced")
+	cm = &CostMode{GrpID: grp}
+	assert.Nil(t, CostModeOf(cm, adp), "Unexpected error getting cost mode")
+	assert.Equal(t, COSTMODE_ATTENDANCE, cm.Mode, "Unexpected cost mode")
+}
+
+func TestAttendWindow(t *testing.T) {
+	aw, err := ParseAttendWindow("05:00-10:00")
+	assert.Nil(t, err, "Unexpected error parsing attendance window")
+	assert.Equal(t, "05:00-10:00", aw.String(), "Unexpected attendance window")
+	day := time.Date(2023, time.August, 14, 0, 0, 0, 0, time.Local)
+	assert.True(t, aw.Early(day), "Unexpected gm at midnight not early")
+	assert.True(t, aw.Early(day.Add(4*time.Hour+59*time.Minute)), "Unexpected gm at 04:59 not early")
+	inWindow := []time.Time{day.Add(5 * time.Hour), day.Add(7*time.Hour + 30*time.Minute), day.Add(10 * time.Hour)}
+	for _, tm := range inWindow {
+		assert.False(t, aw.Early(tm) || aw.Late(tm), "Unexpected gm outside the window at %s", tm.Format("15:04"))
+	}
+	assert.True(t, aw.Late(day.Add(10*time.Hour+time.Minute)), "Unexpected gm at 10:01 not late")
+	assert.True(t, aw.Late(day.Add(19*time.Hour)), "Unexpected gm in the evening not late")
+	for _, s := range []string{"", "5:00-10:00", "10:00-05:00", "05:00-05:00", "05:00-24:00", "05:60-10:00"} {
+		_, err := ParseAttendWindow(s)
+		assert.NotNil(t, err, "Unexpected nil error for invalid window %s", s)
+	}
+}
+
+func TestDayShare(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	coll := sess.DB("").C("transacs")
+	coll.RemoveAll(bson.M{})
+	defer coll.RemoveAll(bson.M{})
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "transacs")
+	day := TodayAtSevenAM().AddDate(0, 0, -1)
+	share, err := DayShare(day, adp)
+	assert.Nil(t, err, "Unexpected error when no one attended")
+	assert.Equal(t, float32(0.0), share, "Unexpected share when no one attended")
+	ids := []bson.ObjectId{bson.NewObjectId(), bson.NewObjectId()}
+	coll.Insert(
+		&Transac{Id: ids[0], TelegID: 5157350442, Debit: 60, Desc: PLAYDAY_DESC, DtTm: day},
+		&Transac{Id: ids[1], TelegID: 498116745, Debit: 100, Desc: PLAYDAY_DESC, DtTm: day},
+		&Transac{Id: bson.NewObjectId(), TelegID: 5157350442, Debit: 20, Desc: ADJUST_DESC, DtTm: day, Ref: ids[0]},
+		&Transac{Id: bson.NewObjectId(), TelegID: 498116745, Debit: 20, Desc: ADJUST_DESC, DtTm: day, Ref: ids[1]},
+	)
+	share, err = DayShare(day, adp)
+	assert.Nil(t, err, "Unexpected error getting day share")
+	assert.Equal(t, float32(100.0), share, "Unexpected day share")
+	marked, _ := IsPlayMarkedOn(adp, 5157350442, day)
+	assert.True(t, marked, "Unexpected playday not marked on the day")
+	marked, _ = IsPlayMarkedOn(adp, 1165670463, day)
+	assert.False(t, marked, "Unexpected playday marked for player who did not attend")
+}
+
+func TestUndoPlayday(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	coll := sess.DB("").C("transacs")
+	coll.RemoveAll(bson.M{})
+	defer coll.RemoveAll(bson.M{})
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, "transacs")
+	day := TodayAtSevenAM()
+	for _, tid := range []int64{5157350442, 498116745, 1165670463} {
+		assert.Nil(t, MarkPlayday(&Transac{TelegID: tid, Debit: 60, Desc: PLAYDAY_DESC, DtTm: day}, adp), "Unexpected error marking playday")
+	}
+	// TEST: undo before the adjustment, nothing to spread
+	pu := &PlaydayUndo{TelegID: 1165670463, Day: day}
+	assert.Nil(t, UndoPlayday(pu, adp), "Unexpected error undoing playday")
+	assert.Equal(t, float32(60), pu.Reversed, "Unexpected debit reversed")
+	assert.Equal(t, float32(0), pu.Spread, "Unexpected spread before the adjustment")
+	assert.Equal(t, 2, pu.Count, "Unexpected attendance after the undo")
+	marked, _ := IsPlayMarkedOn(adp, 1165670463, day)
+	assert.False(t, marked, "Unexpected playday still marked after undo")
+	assert.NotNil(t, UndoPlayday(&PlaydayUndo{TelegID: 1165670463, Day: day}, adp), "Unexpected nil error undoing playday again")
+	// TEST: undo after the adjustment, what the player paid is spread over the rest
+	assert.Nil(t, AdjustDayDebit(&TransacQ{From: day, To: day.Add(time.Hour), Debits: 40}, adp), "Unexpected error adjusting the day")
+	before, _ := DayShare(day, adp)
+	pu = &PlaydayUndo{TelegID: 498116745, Day: day}
+	assert.Nil(t, UndoPlayday(pu, adp), "Unexpected error undoing adjusted playday")
+	assert.Equal(t, float32(100), pu.Reversed, "Unexpected debit reversed, expected playday with its adjustment")
+	assert.Equal(t, float32(100), pu.Spread, "Unexpected spread over the only one left")
+	after, _ := DayShare(day, adp)
+	assert.Equal(t, 2*before, after, "Unexpected recovery of the day changed after undo")
+	t.Log(pu.ToMsgTxt())
+}
+
+func TestRefreshUName(t *testing.T) {
+	sess, _ := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{TEST_MONGO_HOST},
+		Timeout:  4 * time.Second,
+		Database: TEST_MONGO_DB,
+	})
+	coll := sess.DB("").C(TEST_MONGO_COLL)
+	coll.RemoveAll(bson.M{})
+	defer coll.RemoveAll(bson.M{})
+	adp := dbadp.NewMongoAdpator(TEST_MONGO_HOST, TEST_MONGO_DB, TEST_MONGO_COLL)
+	// account registered before the usernames were stored, as in the seeds
+	active, user := false, AccElev(User)
+	coll.Insert(bson.M{"tid": 5157350442, "email": "kneerunjun@gmail.com", "name": "niranjan", "elevtn": user, "archive": active})
+	assert.NotNil(t, AccountByUName(&UserAccount{UName: "kneerunjun"}, adp), "Unexpected nil error for account without username")
+	// TEST: any command from the member refreshes the username
+	assert.Nil(t, RefreshUName(&UserAccount{TelegID: 5157350442, UName: "Kneerunjun"}, adp), "Unexpected error refreshing username")
+	ua := &UserAccount{UName: "@kneerunjun"}
+	assert.Nil(t, AccountByUName(ua, adp), "Unexpected error getting account by refreshed username")
+	assert.Equal(t, int64(5157350442), ua.TelegID, "Unexpected account for the username")
+	// TEST: same username again, unregistered senders and senders without usernames arent errors
+	assert.Nil(t, RefreshUName(&UserAccount{TelegID: 5157350442, UName: "kneerunjun"}, adp), "Unexpected error refreshing the same username")
+	assert.Nil(t, RefreshUName(&UserAccount{TelegID: 1165670463, UName: "newbie"}, adp), "Unexpected error for unregistered sender")
+	assert.Nil(t, RefreshUName(&UserAccount{TelegID: 5157350442}, adp), "Unexpected error for sender without username")
+	// TEST: changed username replaces the old one
+	assert.Nil(t, RefreshUName(&UserAccount{TelegID: 5157350442, UName: "niranjan_a"}, adp), "Unexpected error refreshing changed username")
+	assert.NotNil(t, AccountByUName(&UserAccount{UName: "kneerunjun"}, adp), "Unexpected nil error for the old username")
+}
@@ -0,0 +1,145 @@
+package biz
+
+/* ==================================
+Recurring expenses: court rent, coaching fees that are the same every month
+Admins define them once, the scheduler posts them as expenses on the day they are due
+Posting is idempotent for the month, LastPosted records the period it was posted in
+====================================*/
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kneerunjun/botmincock/dbadp"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	MAX_RECUR_DAY = 28 // so that the recurring expense is due in every month of the year
+)
+
+// AddRecurringExpense : defines a new recurring expense
+// re		: in/out param, gets back the id of the recurring expense
+// iadp		: adaptor to the recurexpenses collection
+// Errors when the day, amount or category is invalid or the query fails
+func AddRecurringExpense(re *RecurringExpense, iadp dbadp.DbAdaptor) error {
+	errLoc := "AddRecurringExpense"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if re == nil || re.INR <= float32(0.0) || re.Day < 1 || re.Day > MAX_RECUR_DAY || strings.TrimSpace(re.Desc) == "" {
+		return NewDomainError(ERR_INVLPARAM, nil).SetLoc(errLoc).SetUsrMsg(invalid_recurring())
+	}
+	re.Cat = strings.ToLower(re.Cat)
+	if err := AssertExpenseCategory(re.Cat, iadp.Switch("expcategories")); err != nil {
+		return err
+	}
+	re.Id = bson.NewObjectId()
+	if err := iadp.AddOne(re); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("adding recurring expense")).SetLogEntry(log.Fields{
+			"inr":     re.INR,
+			"day":     re.Day,
+			"telegid": re.TelegID,
+		})
+	}
+	return nil
+}
+
+// RecurringExpenses : all the recurring expenses defined, sorted by the day of month
+func RecurringExpenses(iadp dbadp.DbAdaptor) ([]RecurringExpense, error) {
+	errLoc := "RecurringExpenses"
+	if iadp == nil {
+		return nil, NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	result := []RecurringExpense{}
+	if err := iadp.AggregateAll([]bson.M{{"$sort": bson.M{"day": 1}}}, &result); err != nil {
+		return nil, NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting recurring expenses"))
+	}
+	return result, nil
+}
+
+// PauseRecurringExpense : pauses / resumes the recurring expense as per re.Paused
+// paused recurring expenses are not posted till resumed
+// Errors when the recurring expense isnt found or the query fails
+func PauseRecurringExpense(re *RecurringExpense, iadp dbadp.DbAdaptor) error {
+	errLoc := "PauseRecurringExpense"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if err := iadp.UpdateOne(bson.M{"_id": re.Id}, bson.M{"paused": re.Paused}); err != nil {
+		if errors.Is(err, mgo.ErrNotFound) {
+			return NewDomainError(ERR_RECUR404, err).SetLoc(errLoc).SetUsrMsg(recurring_notfound(re.Id.Hex()))
+		}
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("pausing recurring expense"))
+	}
+	return nil
+}
+
+// DeleteRecurringExpense : removes the definition, expenses already posted are left as is
+// Errors when the recurring expense isnt found or the query fails
+func DeleteRecurringExpense(re *RecurringExpense, iadp dbadp.DbAdaptor) error {
+	errLoc := "DeleteRecurringExpense"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if err := iadp.RemoveOne(bson.M{"_id": re.Id}); err != nil {
+		if errors.Is(err, mgo.ErrNotFound) {
+			return NewDomainError(ERR_RECUR404, err).SetLoc(errLoc).SetUsrMsg(recurring_notfound(re.Id.Hex()))
+		}
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("deleting recurring expense"))
+	}
+	return nil
+}
+
+// PostDueRecurring : posts all the recurring expenses that are due as of the date and not yet posted for the month
+// Expenses are recorded via RecordExpense dated on the day they are due
+// asof		: date as of which the recurring expenses are checked
+// iadp		: adaptor to the recurexpenses collection
+// Sends back the expenses posted, a failure to post one does not stop the others but the last error is sent back
+func PostDueRecurring(asof time.Time, iadp dbadp.DbAdaptor) ([]Expense, error) {
+	errLoc := "PostDueRecurring"
+	if iadp == nil {
+		return nil, NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	period := PeriodOf(asof)
+	due := []RecurringExpense{}
+	err := iadp.AggregateAll([]bson.M{
+		{"$match": bson.M{"paused": false, "day": bson.M{"$lte": asof.Day()}, "lastposted": bson.M{"$ne": period}}},
+		{"$sort": bson.M{"day": 1}},
+	}, &due)
+	if err != nil {
+		return nil, NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting due recurring expenses"))
+	}
+	posted := []Expense{}
+	var lastErr error
+	for _, re := range due {
+		exp := Expense{
+			TelegID: re.TelegID,
+			INR:     re.INR,
+			Desc:    re.Desc,
+			Cat:     re.Cat,
+			DtTm:    time.Date(asof.Year(), asof.Month(), re.Day, asof.Hour(), asof.Minute(), asof.Second(), 0, asof.Location()),
+		}
+		if err := RecordExpense(&exp, iadp.Switch("expenses")); err != nil {
+			log.WithFields(log.Fields{
+				"recurring": re.Id.Hex(),
+				"err":       err,
+			}).Error("failed to post recurring expense")
+			lastErr = err
+			continue
+		}
+		if err := iadp.UpdateOne(bson.M{"_id": re.Id}, bson.M{"lastposted": period}); err != nil {
+			// expense is posted but not marked, next run would post it again hence the error cannot be ignored
+			lastErr = NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("marking recurring expense posted")).SetLogEntry(log.Fields{
+				"recurring": re.Id.Hex(),
+				"expense":   exp.Id.Hex(),
+			})
+			lastErr.(*DomainError).LogE()
+		}
+		posted = append(posted, exp)
+	}
+	return posted, lastErr
+}
@@ -0,0 +1,123 @@
+package biz
+
+/* ==================================
+Members can pay each other directly instead of everything passing through the treasurer
+Balances of all the members can be simplified to the fewest payments that settle everyone
+====================================*/
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/kneerunjun/botmincock/dbadp"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// RecordTransfer : records money paid by one member to another
+// tf		: in/out param, payer, payee & amount, gets back the id of the batch
+// iadp		: adaptor to the transacs collection
+// Errors when the amount is invalid, payer and payee are the same, month is closed or the query fails
+func RecordTransfer(tf *Transfer, iadp dbadp.DbAdaptor) error {
+	errLoc := "RecordTransfer"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if tf == nil || tf.INR <= float32(0.0) || tf.DtTm.IsZero() {
+		return NewDomainError(ERR_INVLTRANSFER, nil).SetLoc(errLoc).SetUsrMsg(invalid_transfer("amount has to be non zero"))
+	}
+	if tf.Payer == tf.Payee {
+		return NewDomainError(ERR_INVLTRANSFER, nil).SetLoc(errLoc).SetUsrMsg(invalid_transfer("cannot pay yourself"))
+	}
+	if err := AssertPeriodOpen(tf.DtTm, iadp); err != nil {
+		return err
+	}
+	tf.Id = bson.NewObjectId()
+	posts := []*Transac{
+		{Id: bson.NewObjectId(), TelegID: tf.Payer, Credit: tf.INR, Desc: fmt.Sprintf("paid to %d", tf.Payee), DtTm: tf.DtTm, Kind: KIND_TRANSFER, Batch: tf.Id},
+		{Id: bson.NewObjectId(), TelegID: tf.Payee, Debit: tf.INR, Desc: fmt.Sprintf("received from %d", tf.Payer), DtTm: tf.DtTm, Kind: KIND_TRANSFER, Batch: tf.Id},
+	}
+	for _, tr := range posts {
+		if err := iadp.AddOne(tr); err != nil {
+			return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("recording the payment")).SetLogEntry(log.Fields{
+				"payer": tf.Payer,
+				"payee": tf.Payee,
+				"inr":   tf.INR,
+			})
+		}
+	}
+	return nil
+}
+
+// MemberBalances : outstanding balance of every member across all the transactions till date
+// positive balance is owed to the member, negative the member owes
+// iadp		: adaptor to the transacs collection
+func MemberBalances(iadp dbadp.DbAdaptor) ([]Balance, error) {
+	errLoc := "MemberBalances"
+	if iadp == nil {
+		return nil, NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	result := []Balance{}
+	err := iadp.AggregateAll([]bson.M{
+		{"$group": bson.M{
+			"_id":     "$tid",
+			"credits": bson.M{"$sum": "$credit"},
+			"debits":  bson.M{"$sum": "$debit"},
+		}},
+		{"$project": bson.M{
+			"_id": 0,
+			"tid": "$_id",
+			"due": bson.M{"$subtract": []interface{}{"$credits", "$debits"}},
+		}},
+	}, &result)
+	if err != nil {
+		return nil, NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting balances of all members"))
+	}
+	return result, nil
+}
+
+// SimplifyDebts : fewest payments that settle the balances
+// largest debtor pays the largest creditor till either is settled, repeated till no one owes
+// balances under a rupee are taken as settled
+// when the balances do not add up to zero (expenses yet to be recovered) whats left is with the pool
+func SimplifyDebts(balances []Balance) []Settlement {
+	type party struct {
+		tid int64
+		amt float64
+	}
+	creditors, debtors := []*party{}, []*party{}
+	for _, b := range balances {
+		amt := math.Round(float64(b.Due))
+		if amt >= 1.0 {
+			creditors = append(creditors, &party{b.TelegID, amt})
+		} else if amt <= -1.0 {
+			debtors = append(debtors, &party{b.TelegID, -amt})
+		}
+	}
+	byAmt := func(p []*party) func(i, j int) bool {
+		return func(i, j int) bool {
+			if p[i].amt == p[j].amt {
+				return p[i].tid < p[j].tid // so that the result is deterministic
+			}
+			return p[i].amt > p[j].amt
+		}
+	}
+	result := []Settlement{}
+	for len(creditors) > 0 && len(debtors) > 0 {
+		sort.SliceStable(creditors, byAmt(creditors))
+		sort.SliceStable(debtors, byAmt(debtors))
+		cr, dr := creditors[0], debtors[0]
+		amt := math.Min(cr.amt, dr.amt)
+		result = append(result, Settlement{From: dr.tid, To: cr.tid, INR: float32(amt)})
+		cr.amt -= amt
+		dr.amt -= amt
+		if cr.amt < 1.0 {
+			creditors = creditors[1:]
+		}
+		if dr.amt < 1.0 {
+			debtors = debtors[1:]
+		}
+	}
+	return result
+}
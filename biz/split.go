@@ -0,0 +1,65 @@
+package biz
+
+/* ==================================
+Ad-hoc expenses split amongst a few members, these do not go into the shared monthly pool
+Payer is credited the entire amount, each member is debited their share
+All the transactions of a split are of KIND_SPLIT and carry the split id as the batch
+====================================*/
+
+import (
+	"github.com/kneerunjun/botmincock/dbadp"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// RecordSplit : records the split and posts the credit for the payer and debits for the members
+// sp		: in/out param, send in the payer, amount and members with weights, gets back the id and the shares
+// iadp		: adaptor to the splits collection
+// Errors when the amount, members or weights are invalid, month is closed or the query fails
+func RecordSplit(sp *Split, iadp dbadp.DbAdaptor) error {
+	errLoc := "RecordSplit"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if sp == nil || sp.INR <= float32(0.0) || sp.DtTm.IsZero() {
+		return NewDomainError(ERR_INVLSPLIT, nil).SetLoc(errLoc).SetUsrMsg(invalid_split("amount has to be non zero"))
+	}
+	if len(sp.Shares) == 0 {
+		return NewDomainError(ERR_INVLSPLIT, nil).SetLoc(errLoc).SetUsrMsg(invalid_split("no members to split with"))
+	}
+	seen := map[int64]bool{}
+	for _, s := range sp.Shares {
+		if s.Weight <= 0 {
+			return NewDomainError(ERR_INVLSPLIT, nil).SetLoc(errLoc).SetUsrMsg(invalid_split("weights have to be non zero"))
+		}
+		if seen[s.TelegID] {
+			return NewDomainError(ERR_INVLSPLIT, nil).SetLoc(errLoc).SetUsrMsg(invalid_split("members are repeated"))
+		}
+		seen[s.TelegID] = true
+	}
+	if err := AssertPeriodOpen(sp.DtTm, iadp); err != nil {
+		return err
+	}
+	sp.Apportion()
+	sp.Id = bson.NewObjectId()
+	if err := iadp.AddOne(sp); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("recording the split")).SetLogEntry(log.Fields{
+			"inr":    sp.INR,
+			"paidby": sp.PaidBy,
+		})
+	}
+	transacs := iadp.Switch("transacs")
+	posts := []*Transac{{Id: bson.NewObjectId(), TelegID: sp.PaidBy, Credit: sp.INR, Desc: sp.Desc, DtTm: sp.DtTm, Kind: KIND_SPLIT, Batch: sp.Id}}
+	for _, s := range sp.Shares {
+		posts = append(posts, &Transac{Id: bson.NewObjectId(), TelegID: s.TelegID, Debit: s.INR, Desc: sp.Desc, DtTm: sp.DtTm, Kind: KIND_SPLIT, Batch: sp.Id})
+	}
+	for _, tr := range posts {
+		if err := transacs.AddOne(tr); err != nil {
+			return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("posting the split")).SetLogEntry(log.Fields{
+				"split":   sp.Id.Hex(),
+				"telegid": tr.TelegID,
+			})
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package biz
 
 import (
 	"errors"
+	"reflect"
 	"time"
 
 	"github.com/kneerunjun/botmincock/dbadp"
@@ -10,6 +11,15 @@ import (
 	"gopkg.in/mgo.v2/bson"
 )
 
+// netCount : counts the transactions in a group such that a reversal cancels out the original
+// NOTE: playday debits are only ever reversed and never revised, hence any playday with a ref is a reversal
+var netCount = bson.M{"$sum": bson.M{"$cond": []interface{}{bson.M{"$ifNull": []interface{}{"$ref", false}}, -1, 1}}}
+
+// poolOnly : matches only the transactions of the shared monthly pool
+// transactions with a Kind (splits..) are settled between members and never count towards the recovery
+// use as "kind": poolOnly in the match
+var poolOnly = bson.M{"$exists": false}
+
 // ClearDues : adds a simple credit transaction
 // date of the transaction has to be the time when you have added it
 // all transactions are aggregated for the month - if you are recording ofsetted transaction make sure its for the same month
@@ -27,7 +37,10 @@ func ClearDues(tr *Transac, iadp dbadp.DbAdaptor) error {
 			"telegid": ua.TelegID,
 		})
 	}
-	// account is registered, we can now proceed to add transaction
+	// account is registered, transaction cannot be recorded in a closed month
+	if err := AssertPeriodOpen(tr.DtTm, iadp); err != nil {
+		return err
+	}
 	err = iadp.AddOne(tr)
 	if err != nil {
 		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("adding a new transaction")).SetLogEntry(log.Fields{
@@ -59,11 +72,7 @@ func MyDues(bl *Balance, iadp dbadp.DbAdaptor) error {
 	}
 	// account is registered, we can now proceed to add transaction
 	temp := bl.DtTm
-	yr := temp.Year()
-	mn := temp.Month()
-	loc := temp.Location()
-	fromDt := time.Date(yr, mn, 1, 0, 0, 0, 0, loc)                 //start date for any month is 1
-	toDt := time.Date(yr, mn, daysInMonth(mn, yr), 0, 0, 0, 0, loc) // end date for a month needs some extra calc
+	fromDt, toDt := MonthBoundaryOf(temp) // whole of the month, till the end of the last day
 	match := bson.M{
 		"$match": bson.M{
 			"tid": bl.TelegID,
@@ -103,19 +112,24 @@ func MyDues(bl *Balance, iadp dbadp.DbAdaptor) error {
 // returns error if the query fails
 // also returns an error when player found attended, check for ERR_DUPLTRANSAC for knowing what type of error it is
 func IsPlayMarkedToday(iadp dbadp.DbAdaptor, tid int64) (bool, error) {
+	return IsPlayMarkedOn(iadp, tid, time.Now())
+}
+
+// IsPlayMarkedOn : same as IsPlayMarkedToday but for any given day
+func IsPlayMarkedOn(iadp dbadp.DbAdaptor, tid int64, day time.Time) (bool, error) {
 	errLoc := "IsPlayMarkedToday"
 	result := struct {
 		Count int `bson:"count"`
 	}{}
-	fromDt, toDt := TodayAsBoundary()
+	fromDt, toDt := DayAsBoundary(day)
 	err := iadp.Aggregate([]bson.M{
-		{"$match": bson.M{"tid": tid, "desc": PLAYDAY_DESC, "dttm": bson.M{
+		{"$match": bson.M{"tid": tid, "desc": PLAYDAY_DESC, "kind": poolOnly, "dttm": bson.M{
 			"$gte": fromDt,
 			"$lte": toDt,
 		}}},
 		{"$group": bson.M{
 			"_id":   nil,
-			"count": bson.M{"$sum": 1},
+			"count": netCount, // reversed playdays arent counted
 		}},
 		{"$project": bson.M{
 			"_id": 0,
@@ -127,19 +141,28 @@ func IsPlayMarkedToday(iadp dbadp.DbAdaptor, tid int64) (bool, error) {
 		}
 		return false, NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting user's attendance"))
 	}
-	return result.Count > 0, NewDomainError(ERR_DUPLTRANSAC, err).SetLoc(errLoc).SetUsrMsg(duplc_attend())
+	if result.Count <= 0 {
+		return false, nil // playday marked but reversed since
+	}
+	return true, NewDomainError(ERR_DUPLTRANSAC, err).SetLoc(errLoc).SetUsrMsg(duplc_attend())
 }
 
 // RecoveryTillNow : gets the monthly debits for the entire team till date only for the playday
 // error in case the query to database fails
 // this gives us the recovered funds till now
 func RecoveryTillNow(iadp dbadp.DbAdaptor, total *float32) error {
+	return RecoveryBefore(time.Now(), iadp, total)
+}
+
+// RecoveryBefore : same as RecoveryTillNow but from the start of the month till the day before the given day
+func RecoveryBefore(day time.Time, iadp dbadp.DbAdaptor, total *float32) error {
 	errLoc := "RecoveryTillNow"
 	result := struct {
 		TotalDebits float32 `bson:"debits"`
 	}{}
-	from, to := YdayAsBoundary() // all the play debits only till yesterday
-	if from.IsZero() || to.IsZero() {
+	from, _ := MonthBoundaryOf(day)
+	to := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location()).Add(-time.Second) // all the play debits only till the day before
+	if !to.After(from) {
 		// incase day today is first of any month recovery would be zero
 		// we need not consider any rollover from pervious month
 		*total = 0.0
@@ -147,18 +170,23 @@ func RecoveryTillNow(iadp dbadp.DbAdaptor, total *float32) error {
 	}
 	err := iadp.Aggregate([]bson.M{
 		{"$match": bson.M{
-			"desc": PLAYDAY_DESC,
+			"desc": bson.M{"$in": RECOVERY_DESCS},
+			"kind": poolOnly,
 			"dttm": bson.M{
 				"$gte": from,
 				"$lte": to,
 			},
-		}}, // all the transactions for the month marked as playday
+		}}, // all the transactions for the month marked as playday or adjustments to it
 		{"$group": bson.M{
 			"_id":    0,
 			"debits": bson.M{"$sum": "$debit"},
-		}}, // summing the debits of all such transactions
+		}}, // summing the debits of all such transactions, reversals are negative debits
 	}, &result)
 	if err != nil {
+		if errors.Is(err, mgo.ErrNotFound) {
+			*total = 0.0
+			return nil
+		}
 		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting total debits for play day"))
 	}
 	*total = result.TotalDebits
@@ -172,6 +200,9 @@ func MarkPlayday(tr *Transac, iadp dbadp.DbAdaptor) error {
 	if iadp == nil {
 		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
 	}
+	if err := AssertPeriodOpen(tr.DtTm, iadp); err != nil {
+		return err
+	}
 	err := iadp.AddOne(tr)
 	if err != nil {
 		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(FAIL_QRY_EXPNS).SetLogEntry(log.Fields{
@@ -196,16 +227,17 @@ func TotalPlaydayDebits(trq *TransacQ, iadp dbadp.DbAdaptor) error {
 	}{}
 	err := iadp.Aggregate([]bson.M{
 		{"$match": bson.M{
-			"desc": PLAYDAY_DESC,
+			"desc": bson.M{"$in": RECOVERY_DESCS},
+			"kind": poolOnly,
 			"dttm": bson.M{
 				"$gte": trq.From,
 				"$lte": trq.To,
 			},
-		}}, // all the transactions for the month marked as playday
+		}}, // all the transactions in the span marked as playday or adjustments to it
 		{"$group": bson.M{
 			"_id":    0,
 			"debits": bson.M{"$sum": "$debit"},
-		}}, // summing the debits of all such transactions
+		}}, // summing the debits of all such transactions, reversals are negative debits
 	}, &result)
 	if err != nil {
 		if errors.Is(err, mgo.ErrNotFound) {
@@ -221,6 +253,11 @@ func TotalPlaydayDebits(trq *TransacQ, iadp dbadp.DbAdaptor) error {
 // AttendedToday : gets the total number of attendees for today
 // Error only when the query fails
 func AttendedToday(iadp dbadp.DbAdaptor) (int, error) {
+	return AttendedOn(time.Now(), iadp)
+}
+
+// AttendedOn : same as AttendedToday but for any given day
+func AttendedOn(day time.Time, iadp dbadp.DbAdaptor) (int, error) {
 	errLoc := "AttendedToday"
 	if iadp == nil {
 		return 0, NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
@@ -228,10 +265,11 @@ func AttendedToday(iadp dbadp.DbAdaptor) (int, error) {
 	result := struct {
 		Count int `bson:"total"`
 	}{}
-	from, to := TodayAsBoundary()
+	from, to := DayAsBoundary(day)
 	err := iadp.Aggregate([]bson.M{
 		{"$match": bson.M{
 			"desc": PLAYDAY_DESC,
+			"kind": poolOnly,
 			"dttm": bson.M{
 				"$gte": from,
 				"$lte": to,
@@ -239,8 +277,8 @@ func AttendedToday(iadp dbadp.DbAdaptor) (int, error) {
 		}}, // all the transactions for the month marked as playday
 		{"$group": bson.M{
 			"_id":   0,
-			"total": bson.M{"$sum": 1},
-		}}, // summing the debits of all such transactions
+			"total": netCount,
+		}}, // counting the playdays less the ones reversed
 	}, &result)
 	if err != nil {
 		if errors.Is(err, mgo.ErrNotFound) {
@@ -251,26 +289,163 @@ func AttendedToday(iadp dbadp.DbAdaptor) (int, error) {
 	return result.Count, nil
 }
 
-// AdjustDayDebit : for the given adjustment this will find all the day debits and update their debits
-func AdjustDayDebit(trq *TransacQ, iadp dbadp.DbAdaptor) error {
-	errLoc := "AdjustDayDebit"
+// PlaydayDebits : all the playday debits in the given span that havent been reversed
+// trq		: From - To is the span in which the playdays are sought
+// Error only when the query fails, no playdays is an empty slice
+func PlaydayDebits(trq *TransacQ, iadp dbadp.DbAdaptor) ([]Transac, error) {
+	errLoc := "PlaydayDebits"
 	if iadp == nil {
-		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+		return nil, NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
 	}
-	slctr := bson.M{
+	match := bson.M{
 		"desc": PLAYDAY_DESC,
+		"kind": poolOnly,
 		"dttm": bson.M{
 			"$gte": trq.From,
 			"$lte": trq.To,
 		},
 	}
-	return func() error {
-		patch := bson.M{
-			"$inc": bson.M{"debit": trq.Debits},
+	if trq.TelegID != 0 {
+		match["tid"] = trq.TelegID
+	}
+	result := []Transac{}
+	err := iadp.AggregateAll([]bson.M{
+		{"$match": match},
+		{"$group": bson.M{
+			"_id":    bson.M{"$ifNull": []interface{}{"$ref", "$_id"}}, // original and its reversal grouped together
+			"tid":    bson.M{"$first": "$tid"},
+			"dttm":   bson.M{"$first": "$dttm"},
+			"debit":  bson.M{"$sum": "$debit"},
+			"credit": bson.M{"$sum": "$credit"},
+			"count":  netCount,
+		}},
+		{"$match": bson.M{"count": bson.M{"$gt": 0}}},
+		{"$project": bson.M{"count": 0}},
+	}, &result)
+	if err != nil {
+		return nil, NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting playday debits"))
+	}
+	return result, nil
+}
+
+// AdjustDayDebit : for the given adjustment this will find all the day debits and append an adjustment debit for each
+// playday debits are never altered, each adjustment refers to the playday debit it adjusts
+// trq.Debits	: adjustment per playday debit, can be negative when the playdays have over recovered
+func AdjustDayDebit(trq *TransacQ, iadp dbadp.DbAdaptor) error {
+	errLoc := "AdjustDayDebit"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if err := AssertPeriodOpen(trq.From, iadp); err != nil {
+		return err
+	}
+	playdays, err := PlaydayDebits(&TransacQ{From: trq.From, To: trq.To}, iadp)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, pd := range playdays {
+		// distributing the deficits equally among all the attendees
+		adj := &Transac{Id: bson.NewObjectId(), TelegID: pd.TelegID, Debit: trq.Debits, Desc: ADJUST_DESC, DtTm: pd.DtTm, Ref: pd.Id, Posted: now}
+		if err := iadp.AddOne(adj); err != nil {
+			return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(FAIL_QRY_EXPNS).SetLogEntry(log.Fields{
+				"ref":     pd.Id.Hex(),
+				"telegid": pd.TelegID,
+			})
 		}
-		if _, err := iadp.UpdateBulk(slctr, patch); err != nil {
-			return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(FAIL_QRY_EXPNS)
+	}
+	return nil
+}
+
+// transacNet : credits and debits of the transaction netted with all that refer to it - corrections and adjustments
+func transacNet(id bson.ObjectId, iadp dbadp.DbAdaptor) (*TransacQ, error) {
+	net := &TransacQ{}
+	err := iadp.Aggregate([]bson.M{
+		{"$match": bson.M{"$or": []bson.M{{"_id": id}, {"ref": id}}}},
+		{"$group": bson.M{
+			"_id":     nil,
+			"credits": bson.M{"$sum": "$credit"},
+			"debits":  bson.M{"$sum": "$debit"},
+		}},
+	}, net) // original and all the corrections so far
+	return net, err
+}
+
+// ReviseTransac : corrections to a transaction are never made in place
+// an entry is appended that refers to the original, such that the original and all its corrections net to the revised credit & debit
+// orig		: in param, id of the original transaction, gets back the original transaction details
+// cr, dr	: revised credit and debit for the transaction, 0, 0 reverses the transaction entirely
+// Errors when the original isnt found, the month of the original is closed or the query fails
+func ReviseTransac(orig *Transac, cr, dr float32, iadp dbadp.DbAdaptor) error {
+	errLoc := "ReviseTransac"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if orig == nil || !orig.Id.Valid() {
+		return NewDomainError(ERR_INVLPARAM, nil).SetLoc(errLoc).SetUsrMsg(INVLD_PARAM)
+	}
+	found, err := iadp.GetOne(bson.M{"_id": orig.Id}, reflect.TypeOf(&Transac{}))
+	if err != nil {
+		if errors.Is(err, mgo.ErrNotFound) {
+			return NewDomainError(ERR_TRANSAC404, err).SetLoc(errLoc).SetUsrMsg(transac_notfound(orig.Id.Hex()))
 		}
-		return nil
-	}() // distributing the deficits equally among all the attendees
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the transaction"))
+	}
+	*orig = *(found.(*Transac))
+	if err := AssertPeriodOpen(orig.DtTm, iadp); err != nil {
+		return err
+	}
+	net, err := transacNet(orig.Id, iadp)
+	if err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting the transaction"))
+	}
+	if net.Credits == cr && net.Debits == dr {
+		return nil // nothing to correct
+	}
+	crrctn := &Transac{Id: bson.NewObjectId(), TelegID: orig.TelegID, Credit: cr - net.Credits, Debit: dr - net.Debits, Desc: orig.Desc, DtTm: orig.DtTm, ExpId: orig.ExpId, Ref: orig.Id, Posted: time.Now()}
+	if err := iadp.AddOne(crrctn); err != nil {
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("correcting the transaction")).SetLogEntry(log.Fields{
+			"ref":     orig.Id.Hex(),
+			"telegid": orig.TelegID,
+		})
+	}
+	return nil
+}
+
+// BalanceAsOf : balance of the account as it was known at any point in time
+// since transactions are only ever appended, leaving out the ones posted after the given time reconstructs the balance then
+// bl		: in/out param, teleg id and the point in time, gets back the balance
+func BalanceAsOf(bl *Balance, iadp dbadp.DbAdaptor) error {
+	errLoc := "BalanceAsOf"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	asof := bl.DtTm
+	err := iadp.Aggregate([]bson.M{
+		{"$match": bson.M{
+			"tid": bl.TelegID,
+			// corrections have their own posting time, original transactions are posted on the transaction date itself
+			"$expr": bson.M{"$lte": []interface{}{bson.M{"$ifNull": []interface{}{"$posted", "$dttm"}}, asof}},
+		}},
+		{"$group": bson.M{
+			"_id":     nil,
+			"debits":  bson.M{"$sum": "$debit"},
+			"credits": bson.M{"$sum": "$credit"},
+			"tid":     bson.M{"$first": "$tid"},
+		}},
+		{"$project": bson.M{
+			"_id": 0,
+			"tid": 1,
+			"due": bson.M{"$subtract": []interface{}{"$credits", "$debits"}},
+		}},
+	}, bl)
+	bl.DtTm = asof
+	if err != nil {
+		if errors.Is(err, mgo.ErrNotFound) {
+			bl.Due = 0.0
+			return nil
+		}
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting aggregate transactions for account"))
+	}
+	return nil
 }
@@ -10,11 +10,15 @@ All the functions here talk to interfaces and the models
 ====================================*/
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 
 	"github.com/kneerunjun/botmincock/dbadp"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
 // AccountInfo: gets the account information for given unique id
@@ -54,6 +58,55 @@ func AccountInfo(ua *UserAccount, iadp dbadp.DbAdaptor) error {
 	return nil
 }
 
+// AccountByUName : gets the account information for the telegram username
+// ua		: in/out param sends in the username (without @) and gets back the account information
+// Errors when no account with the username is registered or is archived, or the query fails
+func AccountByUName(ua *UserAccount, iadp dbadp.DbAdaptor) error {
+	errLoc := "AccountByUName"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if ua == nil || ua.UName == "" {
+		return NewDomainError(ERR_NILACC, nil).SetLoc(errLoc).SetUsrMsg(invalid_account("getting account information without username"))
+	}
+	archive := false
+	flt := &UserAccount{UName: strings.ToLower(strings.TrimPrefix(ua.UName, "@")), Archived: &archive}
+	info, err := iadp.GetOne(flt, reflect.TypeOf(&UserAccount{}))
+	if err != nil {
+		if errors.Is(err, mgo.ErrNotFound) {
+			return NewDomainError(ERR_ACC404, err).SetLoc(errLoc).SetUsrMsg(uname_notfound(flt.UName))
+		}
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("getting account information")).SetLogEntry(log.Fields{
+			"uname": flt.UName,
+		})
+	}
+	*ua = *(info.(*UserAccount))
+	return nil
+}
+
+// RefreshUName : telegram @username of the active account is kept current with what the sender has now
+// accounts registered before the username was stored, or members who changed their username get the latest one
+// senders without a username or without an active account are left as is, errors only when the query fails
+func RefreshUName(ua *UserAccount, iadp dbadp.DbAdaptor) error {
+	errLoc := "RefreshUName"
+	if iadp == nil {
+		return NewDomainError(ERR_DBCONN, nil).SetLoc(errLoc).SetUsrMsg(gateway_fail())
+	}
+	if ua == nil || ua.TelegID == 0 || ua.UName == "" {
+		return nil
+	}
+	uname := strings.ToLower(strings.TrimPrefix(ua.UName, "@"))
+	err := iadp.UpdateOne(bson.M{"tid": ua.TelegID, "archive": false, "uname": bson.M{"$ne": uname}}, bson.M{"uname": uname})
+	if err != nil && !errors.Is(err, mgo.ErrNotFound) {
+		// not found is when the account isnt registered, or the username is already current
+		return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(failed_query("updating the username")).SetLogEntry(log.Fields{
+			"telegid": ua.TelegID,
+			"uname":   uname,
+		})
+	}
+	return nil
+}
+
 // RegisterNewAccount: checks for the account duplicates, validates the account values and then just pushes that to the database
 // Any account when registered new will be marked archived = false
 // any account when registered new will have elevation =0
@@ -106,7 +159,7 @@ func RegisterNewAccount(ua *UserAccount, iadp dbadp.DbAdaptor) error {
 		// this means the account just needs re-enablement and not registration
 		// this happens with updating the email id as well.
 		archive = false
-		if err := iadp.UpdateOne(&UserAccount{TelegID: ua.TelegID}, &UserAccount{Archived: &archive, Email: ua.Email}); err != nil {
+		if err := iadp.UpdateOne(&UserAccount{TelegID: ua.TelegID}, &UserAccount{Archived: &archive, Email: ua.Email, UName: strings.ToLower(ua.UName)}); err != nil {
 			return NewDomainError(ERR_QRYFAIL, err).SetLoc(errLoc).SetUsrMsg(duplc_account(ua.TelegID, ua.Email)).SetLogEntry(log.Fields{
 				"telegid":  ua.TelegID,
 				"archived": archive,
@@ -115,6 +168,7 @@ func RegisterNewAccount(ua *UserAccount, iadp dbadp.DbAdaptor) error {
 		return NewDomainError(ERR_ACCREENABLE, nil).SetLoc(errLoc).SetUsrMsg(acc_renable(ua.TelegID)).SetLogEntry(log.Fields{"telegid": ua.TelegID})
 	}
 	// defaults when registering new account
+	ua.UName = strings.ToLower(ua.UName)
 	defaultElev := AccElev(User)
 	ua.Elevtn = &defaultElev
 	archive = false
@@ -230,3 +284,21 @@ func DeregisterAccount(ua *UserAccount, iadp dbadp.DbAdaptor) error {
 	}
 	return nil
 }
+
+// AssertElevation : checks the account is registered and is elevated atleast to the level required
+// ua		: in/out param, sends in the teleg id and gets back the account information
+// need		: minimum elevation needed for the operation
+// Errors when the account isnt registered or isnt elevated enough
+func AssertElevation(ua *UserAccount, need AccElev, iadp dbadp.DbAdaptor) error {
+	errLoc := "AssertElevation"
+	if err := AccountInfo(ua, iadp); err != nil {
+		return err
+	}
+	if ua.Elevtn == nil || *ua.Elevtn < need {
+		return NewDomainError(ERR_NOTELEVATED, nil).SetLoc(errLoc).SetUsrMsg(not_elevated(need)).SetLogEntry(log.Fields{
+			"telegid": ua.TelegID,
+			"need":    need,
+		})
+	}
+	return nil
+}
@@ -26,6 +26,35 @@ const (
 	In a day you cannot have more than one attendance marked by the same account. A combination of date, telegid and desc is then used to see if the player has marked the playday already
 	*/
 	PLAYDAY_DESC = "playday"
+	// daily adjustments to the playday debits are appended as separate debits with this description
+	// each adjustment refers to the playday debit it adjusts
+	ADJUST_DESC = "adjustment"
+	// at month close the difference between what was recovered and the actual expenses is settled with this description
+	TRUEUP_DESC = "trueup"
+	PERIOD_FMT  = "2006-01" // book keeping period is a month, YYYY-MM
+	// expenses above the approval limit are pending till a manager approves / rejects them
+	// expenses recorded before approvals were introduced have no status and are taken as approved
+	EXPNS_PENDING  = "pending"
+	EXPNS_APPROVED = "approved"
+	EXPNS_REJECTED = "rejected"
+	KIND_SPLIT     = "split"    // transactions of an ad-hoc expense split amongst members
+	KIND_TRANSFER  = "transfer" // money paid directly from one member to another
+	// payments towards dues are pending till a manager confirms the money is received
+	PAYMNT_PENDING   = "pending"
+	PAYMNT_CONFIRMED = "confirmed"
+	PAYMNT_DECLINED  = "declined"
+	CLEAR_DUES_DESC  = "Clearing dues.."
+	// payments received on the gateway are confirmed by the gateway itself, not a manager
+	PAYMNT_GATEWAY = "gateway"
+	// cost modes of the group, see costmode.go
+	COSTMODE_ESTIMATES  = "estimates"
+	COSTMODE_ATTENDANCE = "attendance"
+	// gm after the attendance window is either rejected or marked late, see attend.go
+	ATTEND_LATE_REJECT = "reject"
+	ATTEND_LATE_MARK   = "late"
+	// expenses are never overwritten, the version replaced is kept as a revision, see expnse.go
+	EXPREV_EDIT   = "edit"
+	EXPREV_DELETE = "delete"
 )
 
 /*====================
@@ -70,6 +99,9 @@ Context of the error still comes from the biz functions - here we have all the c
 func failed_query(operation string) string {
 	return fmt.Sprintf("%c Internal operation: '%s' failed, try after some time%%0AIf this continues you may have to contact an administrator", EMOJI_wilted, operation)
 }
+func uname_notfound(uname string) string {
+	return fmt.Sprintf("%c No account found with the username @%s%%0AMember has to /registerme with a telegram username, else use the ID", EMOJI_warning, uname)
+}
 func account_notfound(id int64) string {
 	return fmt.Sprintf("%c No account found associated with the ID %d%%0A Use /registerme command to register first & then proceed", EMOJI_warning, id)
 }
@@ -99,7 +131,87 @@ func missing_estimate() string {
 }
 
 func duplc_attend() string {
-	return fmt.Sprintf("%c You seem to have already marked your attendance?", EMOJI_warning)
+	return fmt.Sprintf("%c You seem to have already marked your attendance?%%0ASend /ungm if that was by mistake", EMOJI_warning)
+}
+
+func no_attendance(tid int64, day time.Time) string {
+	return fmt.Sprintf("%c No attendance marked for %d on %s", EMOJI_warning, tid, day.Format("02-Jan-2006"))
+}
+
+func transac_notfound(id string) string {
+	return fmt.Sprintf("%c No transaction found with ID %s", EMOJI_warning, id)
+}
+
+func expense_notfound(id string) string {
+	return fmt.Sprintf("%c No expense found with ID %s, check the ID and send again", EMOJI_warning, id)
+}
+
+func recurring_notfound(id string) string {
+	return fmt.Sprintf("%c No recurring expense found with ID %s, check the ID and send again", EMOJI_warning, id)
+}
+
+func invalid_recurring() string {
+	return fmt.Sprintf("%c Recurring expense needs a day of month between 1-%d, a non zero amount and remarks. Kindly check & send again", EMOJI_warning, MAX_RECUR_DAY)
+}
+
+func invalid_budget(month string) string {
+	return fmt.Sprintf("%c Budget for %s needs to be a non zero amount. Kindly check & send again", EMOJI_warning, month)
+}
+
+func expense_reviewed(id, status string) string {
+	return fmt.Sprintf("%c Expense %s isn't pending approval, its already %s", EMOJI_warning, id, status)
+}
+
+func invalid_split(reason string) string {
+	return fmt.Sprintf("%c Cannot split the expense, %s. Kindly check & send again", EMOJI_warning, reason)
+}
+
+func invalid_transfer(reason string) string {
+	return fmt.Sprintf("%c Cannot record the payment, %s. Kindly check & send again", EMOJI_warning, reason)
+}
+
+func self_review(what string) string {
+	return fmt.Sprintf("%c You cannot review your own %s, another manager has to", EMOJI_warning, what)
+}
+
+func payment_noaccount(id string) string {
+	return fmt.Sprintf("%c Payment %s came in on the gateway without a member, confirm it with /confirmpay %s <TelegramID>", EMOJI_warning, id, id)
+}
+
+func payment_notfound(id string) string {
+	return fmt.Sprintf("%c No pending payment found with ID %s, its either confirmed / declined already or the ID is wrong", EMOJI_warning, id)
+}
+
+func poll_unknown() string {
+	return fmt.Sprintf("%c Could not relate your answer to any poll I had sent, kindly check with the managers", EMOJI_warning)
+}
+
+func last_category(cat string) string {
+	return fmt.Sprintf("%c %%23%s is the only category left, add another before removing it", EMOJI_warning, cat)
+}
+
+func invalid_category(cat string, valid []string) string {
+	return fmt.Sprintf("%c %%23%s isn't a known category, use one of %%23%s", EMOJI_warning, cat, strings.Join(valid, " %23"))
+}
+
+func receipt_missing(id string) string {
+	return fmt.Sprintf("%c No receipt was attached to the expense %s", EMOJI_warning, id)
+}
+
+func not_elevated(need AccElev) string {
+	return fmt.Sprintf("%c You haven't got enough privileges for this, needs %s or above. Ask an admin to do this for you", EMOJI_redcross, need.Stringify())
+}
+
+func period_locked(month string) string {
+	return fmt.Sprintf("%c Books for %s are closed, nothing can be recorded in that month.%%0AAsk an admin to unlock the month if this needs correction", EMOJI_warning, month)
+}
+
+func invalid_costmode(mode string) string {
+	return fmt.Sprintf("%c %s isn't a cost mode, expected %s or %s", EMOJI_warning, mode, COSTMODE_ESTIMATES, COSTMODE_ATTENDANCE)
+}
+
+func invalid_period(month string) string {
+	return fmt.Sprintf("%c %s isn't a valid month, expected YYYY-MM", EMOJI_warning, month)
 }
 
 /*====================
@@ -107,6 +219,18 @@ error forming utility functions so that standardised errors are sent across the
 Error messages should be standardised
 ====================*/
 
+var (
+	// expenses can be tagged with these categories unless admins maintain their own list
+	DEFAULT_EXPNS_CTGRY = []string{"court", "shuttles", "misc"}
+	REGX_EXPNS_CTGRY    = regexp.MustCompile(`^[a-z]{2,16}$`)
+	// all the transactions that count towards recovering the monthly expenses from players
+	RECOVERY_DESCS = []string{PLAYDAY_DESC, ADJUST_DESC, TRUEUP_DESC}
+	// transaction note on the payments towards dues, this is how payments on the gateway are traced back to the account
+	REGX_DUES_NOTE = regexp.MustCompile(`^Dues (?P<tid>[\d]+)$`)
+	// expenses that do not count towards the team / user expenses
+	EXPNS_UNAPPROVED = []string{EXPNS_PENDING, EXPNS_REJECTED}
+)
+
 var (
 	ERR_DBCONN       = fmt.Errorf("no adaptor connection, check if database is up")
 	ERR_NILACC       = fmt.Errorf("trying to query with nil account")
@@ -120,6 +244,22 @@ var (
 	ERR_DUPLTRANSAC  = fmt.Errorf("A duplicate transaction was found for the same date")
 	ERR_NOPLAYERESTM = fmt.Errorf("Player has opted not to play or to answer the poll, zero or missing estimate")
 	ERR_NOPLAY       = fmt.Errorf("Either everyone opted out of play, zero play debits")
+	ERR_EXPNS404     = fmt.Errorf("expense not found")
+	ERR_TRANSAC404   = fmt.Errorf("transaction not found")
+	ERR_EXPNSCTGRY   = fmt.Errorf("invalid expense category")
+	ERR_NOTELEVATED  = fmt.Errorf("account not elevated enough for the operation")
+	ERR_PERIODLOCKED = fmt.Errorf("period is locked for writes")
+	ERR_PERIODDUPLC  = fmt.Errorf("period is already locked")
+	ERR_RCPT404      = fmt.Errorf("receipt not attached to expense")
+	ERR_RECUR404     = fmt.Errorf("recurring expense not found")
+	ERR_NOTPENDING   = fmt.Errorf("expense isnt pending approval")
+	ERR_SELFREVIEW   = fmt.Errorf("reviewer cannot review their own claim")
+	ERR_INVLSPLIT    = fmt.Errorf("invalid split")
+	ERR_INVLTRANSFER = fmt.Errorf("invalid transfer")
+	ERR_PAYMNT404    = fmt.Errorf("pending payment not found")
+	ERR_JOB404       = fmt.Errorf("scheduled job not found")
+	ERR_POLL404      = fmt.Errorf("poll not found")
+	ERR_POLLOPT      = fmt.Errorf("poll option out of range")
 )
 
 // daysInMonth: for any month this can give the utmost days in it
@@ -154,11 +294,15 @@ func TodayAtSevenAM() time.Time {
 // this utility function can get you the same
 // returns from, to a set of 2 times
 func TodayAsBoundary() (time.Time, time.Time) {
-	temp := time.Now()
+	return DayAsBoundary(time.Now())
+}
+
+// DayAsBoundary : start and end of the given day
+func DayAsBoundary(temp time.Time) (time.Time, time.Time) {
 	yr := temp.Year()
 	mn := temp.Month()
 	loc := temp.Location()
-	return time.Date(yr, mn, temp.Day(), 0, 0, 0, 0, loc), time.Date(yr, mn, temp.Day(), 23, 59, 59, 0, loc) //start date for any month is 1
+	return time.Date(yr, mn, temp.Day(), 0, 0, 0, 0, loc), time.Date(yr, mn, temp.Day(), 23, 59, 59, 0, loc)
 }
 
 // YdayAsBoundary: returns date set from start of the month to previous day as boundary, but if its the first of any month then will send nil?
@@ -182,11 +326,36 @@ func MonthAsBoundary() (time.Time, time.Time) {
 	return time.Date(yr, mn, 1, 0, 0, 0, 0, loc), time.Date(yr, mn, daysInMonth(mn, yr), 23, 59, 59, 0, loc)
 }
 
+// MonthBoundaryOf : same as MonthAsBoundary but for the month of any given date
+func MonthBoundaryOf(dt time.Time) (time.Time, time.Time) {
+	yr, mn, loc := dt.Year(), dt.Month(), dt.Location()
+	return time.Date(yr, mn, 1, 0, 0, 0, 0, loc), time.Date(yr, mn, daysInMonth(mn, yr), 23, 59, 59, 0, loc)
+}
+
+// PeriodOf : book keeping period (month) for any date as YYYY-MM
+func PeriodOf(dt time.Time) string {
+	return dt.Format(PERIOD_FMT)
+}
+
+// ParsePeriod : from YYYY-MM gets the first day of the month in local time
+// empty string is taken to be the current month
+func ParsePeriod(s string) (time.Time, error) {
+	if s == "" {
+		from, _ := MonthAsBoundary()
+		return from, nil
+	}
+	return time.ParseInLocation(PERIOD_FMT, s, time.Now().Location())
+}
+
 // DaysBeforeMonthEnd: for the current month this returns the number of days left
 // typically used for calculating daily debits for playdays
 func DaysBeforeMonthEnd() int {
-	now := time.Now()
-	return daysInMonth(now.Month(), now.Year()) - now.Day() + 1 // including the todays day
+	return DaysBeforeMonthEndOf(time.Now())
+}
+
+// DaysBeforeMonthEndOf : same as DaysBeforeMonthEnd but from the given day
+func DaysBeforeMonthEndOf(day time.Time) int {
+	return daysInMonth(day.Month(), day.Year()) - day.Day() + 1 // including the day
 }
 
 func readFromJsonF(path string) ([]byte, error) {
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"fmt"
 	"math"
 	"time"
 
@@ -15,6 +16,13 @@ import (
 // example:  if a player has committed for 15 days - the bot considers him playing daily but contributing only 15/total days on each day
 type AdjustPlayDebitBotCmd struct {
 	*core.AnyBotCmd
+	Day time.Time // day of the playdays to adjust, zero time is today
+}
+
+func (abc *AdjustPlayDebitBotCmd) AsMap() map[string]interface{} {
+	base := abc.AnyBotCmd.AsMap()
+	base["day"] = abc.Day
+	return base
 }
 
 // Execute : For any given attendance day, this will get the recovery deficit , distribute that equally to all players who attended
@@ -22,19 +30,37 @@ type AdjustPlayDebitBotCmd struct {
 // This is a better way to calculate the debits for day
 // In reality though not all players play daily, and hence the deficit of the recovery per day has to be distributed amongst players daily after the play is over
 // A simple cron job can do this
+// In the attendance cost mode the playdays arent debited, the cost of the day is split evenly among the attendees
+// Day already adjusted is not adjusted again, the job can be retried / triggered over http more than once a day
+// Day is when the scheduled run was due, runs caught up later still adjust the day they were due for
 func (abc *AdjustPlayDebitBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
 	upon_err := uponErr(abc.ChatId, abc.MsgId) // closure to fill in the error details
 	settledUp := resp.NewTextResponse("We are all settled up for the day", abc.ChatId, abc.MsgId)
+	if abc.Day.IsZero() {
+		abc.Day = time.Now()
+	}
+	adjusted, err := biz.DayAdjusted(abc.Day, ctx.DBAdp.Switch("transacs"))
+	if err != nil {
+		return upon_err(err)
+	} else if adjusted {
+		return settledUp
+	}
+	cm := &biz.CostMode{GrpID: abc.ChatId}
+	if err := biz.CostModeOf(cm, ctx.DBAdp.Switch("costmodes")); err != nil {
+		return upon_err(err)
+	}
+	if cm.Mode == biz.COSTMODE_ATTENDANCE {
+		return abc.splitDayCost(ctx)
+	}
 	// Getting the recovery for the day
 	recovery, err := func() (float32, error) {
-		adp := ctx.DBAdp.Switch("expenses")
-		mq := &biz.MnthlyExpnsQry{TelegID: abc.SenderId, Dttm: time.Now()} // sender ID has no relevance
-		err := biz.TeamMonthlyExpense(mq, adp)
-		if err != nil || mq.Total == 0.0 {
+		bq := &biz.BudgetQ{Month: biz.PeriodOf(abc.Day)}
+		err := biz.MonthlyBudget(bq, ctx.DBAdp)
+		if err != nil || bq.Cost() == 0.0 {
 			return 0.0, err
 		}
-		days := biz.DaysBeforeMonthEnd()
-		dayRecovery := float64(mq.Total / float32(days))
+		days := biz.DaysBeforeMonthEndOf(abc.Day)
+		dayRecovery := float64(bq.Cost() / float32(days))
 		dayRecovery = math.Round(float64(dayRecovery)) // this is what the recovery  should have been
 		return float32(dayRecovery), nil
 	}()
@@ -50,7 +76,7 @@ func (abc *AdjustPlayDebitBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse
 	return func() core.BotResponse {
 		// In the context of transac collection
 		adp := ctx.DBAdp.Switch("transacs")
-		c, err := biz.AttendedToday(adp)
+		c, err := biz.AttendedOn(abc.Day, adp)
 		log.WithFields(log.Fields{
 			"count": c,
 		}).Debug("transacs")
@@ -59,7 +85,7 @@ func (abc *AdjustPlayDebitBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse
 		} else if c == 0 {
 			return settledUp
 		}
-		from, to := biz.TodayAsBoundary()
+		from, to := biz.DayAsBoundary(abc.Day)
 		trq := &biz.TransacQ{Desc: biz.PLAYDAY_DESC, From: from, To: to}
 		err = biz.TotalPlaydayDebits(trq, adp)
 		log.WithFields(log.Fields{
@@ -75,6 +101,44 @@ func (abc *AdjustPlayDebitBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse
 		if err != nil {
 			return upon_err(err)
 		}
+		ctx.Snapshot(nil, trq)
 		return settledUp
 	}()
 }
+
+// splitDayCost : attendance cost mode, cost of the day less the playday debits if any is split evenly among the attendees
+// playdays marked before the group switched the mode could have been debited as per the estimates
+func (abc *AdjustPlayDebitBotCmd) splitDayCost(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(abc.ChatId, abc.MsgId)
+	adp := ctx.DBAdp.Switch("transacs")
+	c, err := biz.AttendedOn(abc.Day, adp)
+	if err != nil {
+		return upon_err(err)
+	} else if c == 0 {
+		return resp.NewTextResponse(fmt.Sprintf("No one played on %s, cost of the day rolls over to the days ahead", abc.Day.Format("02-Jan")), abc.ChatId, abc.MsgId)
+	}
+	dayCost, err := biz.AttendanceDayCost(abc.Day, ctx.DBAdp)
+	if err != nil {
+		return upon_err(err)
+	}
+	from, to := biz.DayAsBoundary(abc.Day)
+	trq := &biz.TransacQ{Desc: biz.PLAYDAY_DESC, From: from, To: to}
+	if err := biz.TotalPlaydayDebits(trq, adp); err != nil {
+		return upon_err(err)
+	}
+	log.WithFields(log.Fields{
+		"cost":   dayCost,
+		"debits": trq.Debits,
+		"count":  c,
+	}).Debug("Day cost split")
+	share := float32(math.Round(float64((dayCost - trq.Debits) / float32(c))))
+	if share == 0.0 {
+		return resp.NewTextResponse("We are all settled up for the day", abc.ChatId, abc.MsgId)
+	}
+	trq.Debits = share
+	if err := biz.AdjustDayDebit(trq, adp); err != nil {
+		return upon_err(err)
+	}
+	ctx.Snapshot(nil, trq)
+	return resp.NewTextResponse(fmt.Sprintf("%c Cost of the day %.2f INR split among %d playing today, %.2f INR each", biz.EMOJI_greentick, dayCost, c, share), abc.ChatId, abc.MsgId)
+}
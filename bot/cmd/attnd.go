@@ -17,6 +17,38 @@ type AttendanceBotCmd struct {
 	*core.AnyBotCmd
 }
 
+// attendWindow : hours of the day in which the gm counts as attendance
+// ATTEND_WINDOW on the environment as HH:MM-HH:MM, 05:00-10:00 when not set or invalid
+func attendWindow() *biz.AttendWindow {
+	aw, err := biz.ParseAttendWindow(os.Getenv("ATTEND_WINDOW"))
+	if err != nil {
+		aw, _ = biz.ParseAttendWindow("05:00-10:00")
+	}
+	return aw
+}
+
+// lateAttendPolicy : what happens to the gm after the attendance window has closed
+// ATTEND_LATE on the environment, gm is marked late only when its biz.ATTEND_LATE_MARK, else its rejected
+func lateAttendPolicy() string {
+	if os.Getenv("ATTEND_LATE") == biz.ATTEND_LATE_MARK {
+		return biz.ATTEND_LATE_MARK
+	}
+	return biz.ATTEND_LATE_REJECT
+}
+
+// guestCharge : daily charge for the players without estimates
+// GUEST_CHARGE on the environment, nominal 110 INR when not set
+func guestCharge() float32 {
+	gc := os.Getenv("GUEST_CHARGE")
+	guestCharge := 0.0
+	if gc == "" {
+		guestCharge = 110.00 // nominal charge if guest charge variale isnt loaded on environment
+	} else {
+		guestCharge, _ = strconv.ParseFloat(gc, 64)
+	}
+	return float32(guestCharge)
+}
+
 func uponErr(chatid, msgid int64) func(error) *resp.ErrBotResp {
 	return func(err error) *resp.ErrBotResp {
 		de := err.(*biz.DomainError)
@@ -30,14 +62,25 @@ func uponErr(chatid, msgid int64) func(error) *resp.ErrBotResp {
 // gets the total expenses, recovered expenses
 // gets the play days and player estimate for the play days
 // sends exact debit transaction
+// In the attendance cost mode the playday is only marked, the adjustment after play splits the cost of the day among the attendees
+// late gm after the adjustment is debited the share of the day straight away
+// gm outside the attendance window is not debited, unless the policy is to mark the late ones
 func (abc *AttendanceBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
 	// Getting handles to all the datatbase connections
 	accounts := ctx.DBAdp.Switch("accounts")
 	transacs := ctx.DBAdp.Switch("transacs")
 	estimates := ctx.DBAdp.Switch("estimates")
-	expenses := ctx.DBAdp.Switch("expenses")
 	debit := &biz.Transac{TelegID: abc.SenderId, Desc: biz.PLAYDAY_DESC, DtTm: biz.TodayAtSevenAM(), Credit: 0.0}
 	upon_err := uponErr(abc.ChatId, abc.MsgId)
+	now, aw := time.Now(), attendWindow()
+	if aw.Early(now) {
+		return resp.NewTextResponse(fmt.Sprintf("%c Too early, gm counts as attendance only between %s", biz.EMOJI_warning, aw), abc.ChatId, abc.MsgId)
+	} else if aw.Late(now) {
+		if lateAttendPolicy() != biz.ATTEND_LATE_MARK {
+			return resp.NewTextResponse(fmt.Sprintf("%c Attendance for today closed, gm counts only between %s%%0AIf you did play, ask a manager to /markattend", biz.EMOJI_warning, aw), abc.ChatId, abc.MsgId)
+		}
+		debit.Late = true
+	}
 	/* =====================
 	- 	Checking to see if the account is registered
 	-	Checking to see if the user hasnt marked his attendance for the day
@@ -51,6 +94,35 @@ func (abc *AttendanceBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
 	if err != nil {
 		return upon_err(err)
 	} // emits error when the player yes == true
+	cm := &biz.CostMode{GrpID: abc.ChatId}
+	if err := biz.CostModeOf(cm, ctx.DBAdp.Switch("costmodes")); err != nil {
+		return upon_err(err)
+	}
+	if cm.Mode == biz.COSTMODE_ATTENDANCE {
+		adjusted, err := biz.DayAdjusted(now, transacs)
+		if err != nil {
+			return upon_err(err)
+		}
+		if adjusted {
+			// late gm after the split, pays what each one attending has paid else would never be charged for the day
+			share, err := biz.DayShare(now, transacs)
+			if err == nil && share == 0.0 {
+				share, err = biz.AttendanceDayCost(now, ctx.DBAdp)
+			}
+			if err != nil {
+				return upon_err(err)
+			}
+			debit.Debit = float32(math.Round(float64(share)))
+		}
+		if err := biz.MarkPlayday(debit, transacs); err != nil {
+			return upon_err(err)
+		}
+		ctx.Snapshot(nil, debit)
+		if adjusted {
+			return resp.NewTextResponse(fmt.Sprintf("%c Noted, cost of the day is already split, you are debited %.2f INR", biz.EMOJI_greentick, debit.Debit), abc.ChatId, abc.MsgId)
+		}
+		return resp.NewTextResponse(fmt.Sprintf("%c Noted, cost of the day is split among everyone playing today after the play", biz.EMOJI_greentick), abc.ChatId, abc.MsgId)
+	}
 	/* =====================
 	- Getting total estimates
 	- Getting the individual estimates
@@ -67,28 +139,22 @@ func (abc *AttendanceBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
 	if err != nil {
 		de, _ := err.(*biz.DomainError)
 		if errors.Is(de.Err, biz.ERR_NOPLAYERESTM) {
-			gc := os.Getenv("GUEST_CHARGE")
-			guestCharge := 0.0
-			if gc == "" {
-				guestCharge = 110.00 // nominal charge if guest charge variale isnt loaded on environment
-			} else {
-				guestCharge, _ = strconv.ParseFloat(gc, 64)
-			}
-			debit.Debit = float32(guestCharge)
+			debit.Debit = guestCharge()
 			if err := biz.MarkPlayday(debit, transacs); err != nil {
 				return upon_err(err)
 			} else {
-				return resp.NewTextResponse(fmt.Sprintf("%c You would be charged a default of %.2f INR/day", biz.EMOJI_greentick, guestCharge), abc.ChatId, abc.MsgId)
+				ctx.Snapshot(nil, debit)
+				return resp.NewTextResponse(fmt.Sprintf("%c You would be charged a default of %.2f INR/day", biz.EMOJI_greentick, debit.Debit), abc.ChatId, abc.MsgId)
 			}
 		} else {
 			return upon_err(err)
 		}
 	}
 	/* =====================
-	- Getting expenses and recoveries
+	- Getting budgeted cost of the month and recoveries
 	===================== */
-	expQ := biz.MnthlyExpnsQry{TelegID: abc.SenderId, Dttm: time.Now()}
-	err = biz.TeamMonthlyExpense(&expQ, expenses)
+	bq := &biz.BudgetQ{Month: biz.PeriodOf(time.Now())}
+	err = biz.MonthlyBudget(bq, ctx.DBAdp)
 	if err != nil {
 		return upon_err(err)
 	}
@@ -100,16 +166,159 @@ func (abc *AttendanceBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
 	/* calculating the actual player debit
 	marking the attendance with appropriate debit
 	*/
-	playerShare := float32(playerdays) / float32(days)                        // ratio of player contribution when getting the debit
-	mnthEquity := (expQ.Total - recovery) / float32(biz.DaysBeforeMonthEnd()) // Playday transactions are marked at 07:00 am
+	playerShare := float32(playerdays) / float32(days)                       // ratio of player contribution when getting the debit
+	mnthEquity := (bq.Cost() - recovery) / float32(biz.DaysBeforeMonthEnd()) // Playday transactions are marked at 07:00 am
 	debit.Debit = mnthEquity * playerShare
 	debit.Debit = float32(math.Round(float64(debit.Debit)))
 	if err := biz.MarkPlayday(debit, ctx.DBAdp); err != nil {
 		return upon_err(err)
 	}
+	ctx.Snapshot(nil, debit)
+	if debit.Late {
+		return resp.NewTextResponse(fmt.Sprintf("%c Noted, marked late", biz.EMOJI_greentick), abc.ChatId, abc.MsgId)
+	}
 	return resp.NewTextResponse(fmt.Sprintf("%c Noted", biz.EMOJI_greentick), abc.ChatId, abc.MsgId)
 }
 
 func (abc *AttendanceBotCmd) CollName() string {
 	return "transacs"
 }
+
+// MarkAttendBotCmd : manager backfills the attendance of a player for a day the gm was missed
+type MarkAttendBotCmd struct {
+	*core.AnyBotCmd
+	TargetId int64
+	Day      time.Time
+}
+
+func (mabc *MarkAttendBotCmd) AsMap() map[string]interface{} {
+	base := mabc.AnyBotCmd.AsMap()
+	base["tid"] = mabc.TargetId
+	base["day"] = mabc.Day
+	return base
+}
+
+// Execute : player is debited what each one attending the day has paid
+// when no one else attended, its the cost of the day as per the cost mode - share by the estimates or the entire day's cost
+// backfill for today in the attendance mode is left for the adjustment to split, unless the day is already adjusted
+func (mabc *MarkAttendBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(mabc.ChatId, mabc.MsgId)
+	if err := biz.AssertElevation(&biz.UserAccount{TelegID: mabc.SenderId}, biz.AccElev(biz.Manager), ctx.DBAdp.Switch("accounts")); err != nil {
+		return upon_err(err)
+	}
+	_, today := biz.TodayAsBoundary()
+	if mabc.Day.After(today) {
+		return upon_err(biz.NewDomainError(biz.ERR_INVLPARAM, nil).SetLoc("MarkAttendBotCmd").SetUsrMsg(fmt.Sprintf("%c Attendance cannot be marked ahead of the day", biz.EMOJI_warning)))
+	}
+	if err := biz.AccountInfo(&biz.UserAccount{TelegID: mabc.TargetId}, ctx.DBAdp.Switch("accounts")); err != nil {
+		return upon_err(err)
+	}
+	transacs := ctx.DBAdp.Switch("transacs")
+	if _, err := biz.IsPlayMarkedOn(transacs, mabc.TargetId, mabc.Day); err != nil {
+		return upon_err(err)
+	}
+	debit := &biz.Transac{TelegID: mabc.TargetId, Desc: biz.PLAYDAY_DESC, DtTm: time.Date(mabc.Day.Year(), mabc.Day.Month(), mabc.Day.Day(), 7, 0, 0, 0, mabc.Day.Location()), MarkedBy: mabc.SenderId}
+	share, err := biz.DayShare(mabc.Day, transacs)
+	if err != nil {
+		return upon_err(err)
+	}
+	if share == 0.0 {
+		share, err = mabc.dayCost(ctx)
+		if err != nil {
+			return upon_err(err)
+		}
+	}
+	debit.Debit = float32(math.Round(float64(share)))
+	if err := biz.MarkPlayday(debit, transacs); err != nil {
+		return upon_err(err)
+	}
+	ctx.Snapshot(nil, debit)
+	return resp.NewTextResponse(fmt.Sprintf("%c Attendance of %d marked for %s, debited %.2f INR", biz.EMOJI_greentick, mabc.TargetId, mabc.Day.Format("02-Jan-2006"), debit.Debit), mabc.ChatId, mabc.MsgId)
+}
+
+// dayCost : cost of the day for the player when no one else attended the day
+func (mabc *MarkAttendBotCmd) dayCost(ctx *core.CmdExecCtx) (float32, error) {
+	cm := &biz.CostMode{GrpID: mabc.ChatId}
+	if err := biz.CostModeOf(cm, ctx.DBAdp.Switch("costmodes")); err != nil {
+		return 0.0, err
+	}
+	if cm.Mode == biz.COSTMODE_ATTENDANCE {
+		from, to := biz.TodayAsBoundary()
+		if !mabc.Day.Before(from) && !mabc.Day.After(to) {
+			adjusted, err := biz.DayAdjusted(mabc.Day, ctx.DBAdp.Switch("transacs"))
+			if err != nil || !adjusted {
+				return 0.0, err // adjustment for today is yet to split the cost
+			}
+		}
+		// priced the same as the adjustment would have on the day
+		return biz.AttendanceDayCost(mabc.Day, ctx.DBAdp)
+	}
+	bq := &biz.BudgetQ{Month: biz.PeriodOf(mabc.Day)}
+	if err := biz.MonthlyBudget(bq, ctx.DBAdp); err != nil {
+		return 0.0, err
+	}
+	monthEnd := time.Date(mabc.Day.Year(), mabc.Day.Month()+1, 0, 0, 0, 0, 0, mabc.Day.Location())
+	dayEquity := bq.Cost() / float32(monthEnd.Day())
+	estimates := ctx.DBAdp.Switch("estimates")
+	days, err := biz.TotalPlayDaysOf(mabc.Day, estimates)
+	if err != nil {
+		return 0.0, err
+	}
+	playerdays, err := biz.PlayerPlayDaysOf(mabc.TargetId, mabc.Day, estimates)
+	if err != nil {
+		if de, _ := err.(*biz.DomainError); errors.Is(de.Err, biz.ERR_NOPLAYERESTM) {
+			return guestCharge(), nil
+		}
+		return 0.0, err
+	}
+	return dayEquity * float32(playerdays) / float32(days), nil
+}
+
+func (mabc *MarkAttendBotCmd) CollName() string {
+	return "transacs"
+}
+
+// UngmBotCmd : undo the attendance marked by mistake
+// /ungm is for the sender and only for today before the adjustment, managers can undo for anyone on any day
+type UngmBotCmd struct {
+	*core.AnyBotCmd
+	TargetId int64
+	Day      time.Time
+	Managed  bool // true when a manager undoes the attendance
+}
+
+func (ubc *UngmBotCmd) AsMap() map[string]interface{} {
+	base := ubc.AnyBotCmd.AsMap()
+	base["tid"] = ubc.TargetId
+	base["day"] = ubc.Day
+	base["managed"] = ubc.Managed
+	return base
+}
+
+func (ubc *UngmBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(ubc.ChatId, ubc.MsgId)
+	transacs := ctx.DBAdp.Switch("transacs")
+	if ubc.Managed {
+		if err := biz.AssertElevation(&biz.UserAccount{TelegID: ubc.SenderId}, biz.AccElev(biz.Manager), ctx.DBAdp.Switch("accounts")); err != nil {
+			return upon_err(err)
+		}
+	} else {
+		adjusted, err := biz.DayAdjusted(ubc.Day, transacs)
+		if err != nil {
+			return upon_err(err)
+		}
+		if adjusted {
+			return upon_err(biz.NewDomainError(biz.ERR_INVLPARAM, nil).SetLoc("UngmBotCmd").SetUsrMsg(fmt.Sprintf("%c Debits for today are already adjusted, ask a manager to undo your attendance", biz.EMOJI_warning)))
+		}
+	}
+	pu := &biz.PlaydayUndo{TelegID: ubc.TargetId, Day: ubc.Day}
+	if err := biz.UndoPlayday(pu, transacs); err != nil {
+		return upon_err(err)
+	}
+	ctx.Snapshot(nil, pu)
+	return resp.NewTextResponse(fmt.Sprintf("%c %s", biz.EMOJI_greentick, pu.ToMsgTxt()), ubc.ChatId, ubc.MsgId)
+}
+
+func (ubc *UngmBotCmd) CollName() string {
+	return "transacs"
+}
@@ -0,0 +1,89 @@
+package cmd
+
+/*====================
+Every command executed by the bot leaves an entry in the audit trail
+ExecuteAudited wraps the execution of any command, admins can then query the trail with /audit
+====================*/
+import (
+	"reflect"
+	"time"
+
+	"github.com/kneerunjun/botmincock/biz"
+	"github.com/kneerunjun/botmincock/bot/core"
+	"github.com/kneerunjun/botmincock/bot/resp"
+	log "github.com/sirupsen/logrus"
+)
+
+// ExecuteAudited : executes the command and records the outcome in the audit trail
+// failure to record the audit is logged but does not alter the response of the command
+func ExecuteAudited(c core.BotCommand, ctx *core.CmdExecCtx) core.BotResponse {
+	r := c.Execute(ctx)
+	al := &biz.AuditLog{Cmd: reflect.TypeOf(c).Elem().Name(), Outcome: biz.AUDIT_OK, Before: ctx.Before, After: ctx.After, DtTm: time.Now()}
+	if lggbl, ok := c.(core.Loggable); ok {
+		al.Args = lggbl.AsMap()
+		al.TelegID, _ = al.Args["from_id"].(int64)
+		al.ChatId, _ = al.Args["chat_id"].(int64)
+		al.MsgId, _ = al.Args["msg_id"].(int64)
+	}
+	if errResp, ok := r.(*resp.ErrBotResp); ok {
+		al.Outcome = biz.AUDIT_ERR
+		al.Err = errResp.Err.Error()
+		al.ErrLoc = errResp.Context
+		al.UserMsg = errResp.UserMessage()
+	}
+	if ctx.DBAdp == nil {
+		log.WithFields(log.Fields{
+			"cmd": al.Cmd,
+		}).Error("no database adaptor, cannot record audit")
+		return r
+	}
+	if err := biz.RecordAudit(al, ctx.DBAdp.Switch("audit")); err != nil {
+		err.(*biz.DomainError).LogE()
+	}
+	return r
+}
+
+/*
+====================
+Querying the audit trail, only for admins
+====================
+*/
+type AuditBotCmd struct {
+	*core.AnyBotCmd
+	TelegID int64  // account for which the trail is sought, 0 for everyone
+	UName   string // account by the telegram username instead of the id
+	Limit   int    // latest n entries
+}
+
+func (adbc *AuditBotCmd) AsMap() map[string]interface{} {
+	base := adbc.AnyBotCmd.AsMap()
+	base["tid"] = adbc.TelegID
+	if adbc.UName != "" {
+		base["uname"] = adbc.UName
+	}
+	base["n"] = adbc.Limit
+	return base
+}
+
+func (adbc *AuditBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(adbc.ChatId, adbc.MsgId)
+	if err := biz.AssertElevation(&biz.UserAccount{TelegID: adbc.SenderId}, biz.AccElev(biz.Admin), ctx.DBAdp.Switch("accounts")); err != nil {
+		return upon_err(err)
+	}
+	if adbc.UName != "" {
+		ua := &biz.UserAccount{UName: adbc.UName}
+		if err := biz.AccountByUName(ua, ctx.DBAdp.Switch("accounts")); err != nil {
+			return upon_err(err)
+		}
+		adbc.TelegID = ua.TelegID
+	}
+	aq := &biz.AuditQ{TelegID: adbc.TelegID, Limit: adbc.Limit}
+	if err := biz.AuditTrail(aq, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	return resp.NewTextResponse(aq.ToMsgTxt(), adbc.ChatId, adbc.MsgId)
+}
+
+func (adbc *AuditBotCmd) CollName() string {
+	return "audit"
+}
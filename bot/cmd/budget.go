@@ -0,0 +1,66 @@
+package cmd
+
+/*====================
+Monthly budget: admins set the projected cost of the month, anyone can see the budget against the actual expenses
+====================*/
+import (
+	"fmt"
+	"time"
+
+	"github.com/kneerunjun/botmincock/biz"
+	"github.com/kneerunjun/botmincock/bot/core"
+	"github.com/kneerunjun/botmincock/bot/resp"
+)
+
+type SetBudgetBotCmd struct {
+	*core.AnyBotCmd
+	Month string  // YYYY-MM
+	Val   float32 // budget in INR
+}
+
+func (sbbc *SetBudgetBotCmd) AsMap() map[string]interface{} {
+	base := sbbc.AnyBotCmd.AsMap()
+	base["month"] = sbbc.Month
+	base["inr"] = sbbc.Val
+	return base
+}
+
+// Execute : only admins can set the budget
+func (sbbc *SetBudgetBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(sbbc.ChatId, sbbc.MsgId)
+	if err := biz.AssertElevation(&biz.UserAccount{TelegID: sbbc.SenderId}, biz.AccElev(biz.Admin), ctx.DBAdp.Switch("accounts")); err != nil {
+		return upon_err(err)
+	}
+	before := &biz.BudgetQ{Month: sbbc.Month}
+	if err := biz.MonthlyBudget(before, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	b := &biz.Budget{Month: before.Month, INR: sbbc.Val, SetBy: sbbc.SenderId, DtTm: time.Now()}
+	if err := biz.SetBudget(b, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	after := &biz.BudgetQ{Month: b.Month, Budget: b.INR, Actual: before.Actual}
+	ctx.Snapshot(before, after)
+	return resp.NewTextResponse(fmt.Sprintf("%c %s", biz.EMOJI_greentick, after.ToMsgTxt()), sbbc.ChatId, sbbc.MsgId)
+}
+
+func (sbbc *SetBudgetBotCmd) CollName() string {
+	return "budgets"
+}
+
+type BudgetBotCmd struct {
+	*core.AnyBotCmd
+	Month string // YYYY-MM, empty for the current month
+}
+
+func (bbc *BudgetBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	bq := &biz.BudgetQ{Month: bbc.Month}
+	if err := biz.MonthlyBudget(bq, ctx.DBAdp); err != nil {
+		return uponErr(bbc.ChatId, bbc.MsgId)(err)
+	}
+	return resp.NewTextResponse(bq.ToMsgTxt(), bbc.ChatId, bbc.MsgId)
+}
+
+func (bbc *BudgetBotCmd) CollName() string {
+	return "budgets"
+}
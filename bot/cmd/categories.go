@@ -0,0 +1,92 @@
+package cmd
+
+/*====================
+Expense categories: admins maintain the list, anyone can list them or see the monthly break down of expenses by category
+====================*/
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kneerunjun/botmincock/biz"
+	"github.com/kneerunjun/botmincock/bot/core"
+	"github.com/kneerunjun/botmincock/bot/resp"
+)
+
+type ExpenseCtgryBotCmd struct {
+	*core.AnyBotCmd
+	Name   string // name of the category
+	Remove bool   // when true, category is removed from the list
+}
+
+func (ecbc *ExpenseCtgryBotCmd) AsMap() map[string]interface{} {
+	base := ecbc.AnyBotCmd.AsMap()
+	base["cat"] = ecbc.Name
+	base["remove"] = ecbc.Remove
+	return base
+}
+
+// Execute : only admins can add/remove categories
+func (ecbc *ExpenseCtgryBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(ecbc.ChatId, ecbc.MsgId)
+	if err := biz.AssertElevation(&biz.UserAccount{TelegID: ecbc.SenderId}, biz.AccElev(biz.Admin), ctx.DBAdp.Switch("accounts")); err != nil {
+		return upon_err(err)
+	}
+	ec := &biz.ExpenseCategory{Name: ecbc.Name, AddedBy: ecbc.SenderId, DtTm: time.Now()}
+	if ecbc.Remove {
+		if err := biz.RemoveExpenseCategory(ec, ctx.DBAdp); err != nil {
+			return upon_err(err)
+		}
+		ctx.Snapshot(ec, nil)
+		return resp.NewTextResponse(fmt.Sprintf("%c removed category %%23%s", biz.EMOJI_greentick, ec.Name), ecbc.ChatId, ecbc.MsgId)
+	}
+	if err := biz.AddExpenseCategory(ec, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	ctx.Snapshot(nil, ec)
+	return resp.NewTextResponse(fmt.Sprintf("%c added category %%23%s", biz.EMOJI_greentick, ec.Name), ecbc.ChatId, ecbc.MsgId)
+}
+
+func (ecbc *ExpenseCtgryBotCmd) CollName() string {
+	return "expcategories"
+}
+
+type ListCtgryBotCmd struct {
+	*core.AnyBotCmd
+}
+
+func (lcbc *ListCtgryBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	cats, err := biz.ExpenseCategories(ctx.DBAdp)
+	if err != nil {
+		return uponErr(lcbc.ChatId, lcbc.MsgId)(err)
+	}
+	return resp.NewTextResponse(fmt.Sprintf("Expense categories:%%0A%%23%s", strings.Join(cats, " %23")), lcbc.ChatId, lcbc.MsgId)
+}
+
+func (lcbc *ListCtgryBotCmd) CollName() string {
+	return "expcategories"
+}
+
+// ExpensesByBotCmd : monthly break down of the team expenses
+type ExpensesByBotCmd struct {
+	*core.AnyBotCmd
+	Month time.Time // any date in the month
+}
+
+func (ebbc *ExpensesByBotCmd) AsMap() map[string]interface{} {
+	base := ebbc.AnyBotCmd.AsMap()
+	base["month"] = biz.PeriodOf(ebbc.Month)
+	return base
+}
+
+func (ebbc *ExpensesByBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	ceq := &biz.CtgryExpnsQry{Dttm: ebbc.Month}
+	if err := biz.ExpensesByCategory(ceq, ctx.DBAdp); err != nil {
+		return uponErr(ebbc.ChatId, ebbc.MsgId)(err)
+	}
+	return resp.NewTextResponse(ceq.ToMsgTxt(), ebbc.ChatId, ebbc.MsgId)
+}
+
+func (ebbc *ExpensesByBotCmd) CollName() string {
+	return "expenses"
+}
@@ -0,0 +1,50 @@
+package cmd
+
+/*====================
+Cost mode of the group: admins choose between sharing the cost by the estimates or only by the attendance
+====================*/
+import (
+	"fmt"
+	"time"
+
+	"github.com/kneerunjun/botmincock/biz"
+	"github.com/kneerunjun/botmincock/bot/core"
+	"github.com/kneerunjun/botmincock/bot/resp"
+)
+
+// CostModeBotCmd : gets the cost mode of the group, or sets it when the mode is sent
+type CostModeBotCmd struct {
+	*core.AnyBotCmd
+	Mode string // empty to only get the current mode
+}
+
+func (cmbc *CostModeBotCmd) AsMap() map[string]interface{} {
+	base := cmbc.AnyBotCmd.AsMap()
+	base["mode"] = cmbc.Mode
+	return base
+}
+
+// Execute : anyone can see the mode, only admins can change it
+func (cmbc *CostModeBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(cmbc.ChatId, cmbc.MsgId)
+	before := &biz.CostMode{GrpID: cmbc.ChatId}
+	if err := biz.CostModeOf(before, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	if cmbc.Mode == "" {
+		return resp.NewTextResponse(fmt.Sprintf("Cost is shared by %s", before.Mode), cmbc.ChatId, cmbc.MsgId)
+	}
+	if err := biz.AssertElevation(&biz.UserAccount{TelegID: cmbc.SenderId}, biz.AccElev(biz.Admin), ctx.DBAdp.Switch("accounts")); err != nil {
+		return upon_err(err)
+	}
+	after := &biz.CostMode{GrpID: cmbc.ChatId, Mode: cmbc.Mode, SetBy: cmbc.SenderId, DtTm: time.Now()}
+	if err := biz.SetCostMode(after, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	ctx.Snapshot(before, after)
+	return resp.NewTextResponse(fmt.Sprintf("%c Cost is now shared by %s, effective from the next playday", biz.EMOJI_greentick, after.Mode), cmbc.ChatId, cmbc.MsgId)
+}
+
+func (cmbc *CostModeBotCmd) CollName() string {
+	return "costmodes"
+}
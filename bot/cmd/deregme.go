@@ -17,11 +17,14 @@ type DeregBotCmd struct {
 
 // Execute : for the account id this will archive the account from the database
 func (debc *DeregBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	before := &biz.UserAccount{TelegID: debc.SenderId}
+	biz.AccountInfo(before, ctx.DBAdp) // only for the audit, incase of errors deregistering would fail too
 	if err := biz.DeregisterAccount(&biz.UserAccount{TelegID: debc.SenderId}, ctx.DBAdp); err != nil {
 		de, _ := err.(*biz.DomainError)
 		de.LogE()
 		return resp.NewErrResponse(err, de.Loc, de.UserMsg, debc.ChatId, debc.MsgId)
 	}
+	ctx.Snapshot(before, nil)
 	return resp.NewTextResponse("You have been successfully deregistered, you can re-register anytime using /registerme command", debc.ChatId, debc.MsgId)
 }
 
@@ -14,7 +14,15 @@ type EditMeBotCmd struct {
 	UserEmail string
 }
 
+func (edit *EditMeBotCmd) AsMap() map[string]interface{} {
+	base := edit.AnyBotCmd.AsMap()
+	base["email"] = edit.UserEmail
+	return base
+}
+
 func (edit *EditMeBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	before := &biz.UserAccount{TelegID: edit.SenderId}
+	biz.AccountInfo(before, ctx.DBAdp) // only for the audit, incase of errors update would fail too
 	patchAcc := &biz.UserAccount{TelegID: edit.SenderId, Email: edit.UserEmail}
 	err := biz.UpdateAccountEmail(patchAcc, ctx.DBAdp)
 	if err != nil {
@@ -22,6 +30,7 @@ func (edit *EditMeBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
 		de.LogE()
 		return resp.NewErrResponse(err, de.Loc, de.UserMsg, edit.ChatId, edit.MsgId)
 	}
+	ctx.Snapshot(before, patchAcc)
 	return resp.NewTextResponse(patchAcc.ToMsgTxt(), edit.ChatId, edit.MsgId)
 }
 
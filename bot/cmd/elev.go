@@ -13,6 +13,12 @@ type ElevAccBotCmd struct {
 	TargetAcc int64 // id of the account that would be elevated
 }
 
+func (eabc *ElevAccBotCmd) AsMap() map[string]interface{} {
+	base := eabc.AnyBotCmd.AsMap()
+	base["accid"] = eabc.TargetAcc
+	return base
+}
+
 func (eabc *ElevAccBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
 	/*====================
 	- verify if the commanding account is of admin level
@@ -31,6 +37,7 @@ func (eabc *ElevAccBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
 	}
 	// FIXME: this has to be dynamic - the existing elevation has to be bumped up a level
 	// for now we are just hardcoding this to manager level
+	before := *ua
 	mangrEl := *ua.Elevtn + biz.AccElev(uint8(1))
 	ua.Elevtn = &mangrEl
 	err = biz.ElevateAccount(ua, ctx.DBAdp)
@@ -39,6 +46,7 @@ func (eabc *ElevAccBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
 		de.LogE()
 		return resp.NewErrResponse(err, de.Loc, de.UserMsg, eabc.ChatId, eabc.MsgId)
 	}
+	ctx.Snapshot(&before, ua)
 	return resp.NewTextResponse("Successfully elevated account", eabc.ChatId, eabc.MsgId)
 }
 
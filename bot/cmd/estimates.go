@@ -0,0 +1,213 @@
+package cmd
+
+/*====================
+Estimates outside of the poll, for members who missed the poll or guests added mid-month
+Members can set their own estimate for the current month, managers can set anyone's for any open month
+Members who do not answer the poll are reminded before the deadline, and given default estimates after
+====================*/
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kneerunjun/botmincock/biz"
+	"github.com/kneerunjun/botmincock/bot/core"
+	"github.com/kneerunjun/botmincock/bot/resp"
+)
+
+type SetEstimateBotCmd struct {
+	*core.AnyBotCmd
+	TargetId int64  // account for which the estimate is set, sender when setting own estimate
+	Days     int    // playdays
+	Month    string // YYYY-MM, empty for the current month
+	Managed  bool   // set by a manager with /setestimate, else its members setting their own
+}
+
+func (sebc *SetEstimateBotCmd) AsMap() map[string]interface{} {
+	base := sebc.AnyBotCmd.AsMap()
+	base["tid"] = sebc.TargetId
+	base["playdays"] = sebc.Days
+	base["month"] = sebc.Month
+	base["managed"] = sebc.Managed
+	return base
+}
+
+// Execute : /setestimate needs a manager, account has to be registered either way
+func (sebc *SetEstimateBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(sebc.ChatId, sebc.MsgId)
+	if sebc.Managed {
+		if err := biz.AssertElevation(&biz.UserAccount{TelegID: sebc.SenderId}, biz.AccElev(biz.Manager), ctx.DBAdp.Switch("accounts")); err != nil {
+			return upon_err(err)
+		}
+	}
+	if err := biz.AccountInfo(&biz.UserAccount{TelegID: sebc.TargetId}, ctx.DBAdp.Switch("accounts")); err != nil {
+		return upon_err(err)
+	}
+	month, err := biz.ParsePeriod(sebc.Month)
+	if err != nil {
+		return upon_err(biz.NewDomainError(biz.ERR_INVLPARAM, err).SetLoc("SetEstimateBotCmd").SetUsrMsg(fmt.Sprintf("%c %s isn't a valid month, expected YYYY-MM", biz.EMOJI_warning, sebc.Month)))
+	}
+	est := &biz.Estimate{TelegID: sebc.TargetId, PlyDys: sebc.Days, DtTm: month}
+	if biz.PeriodOf(month) == biz.PeriodOf(time.Now()) {
+		est.DtTm = time.Now() // estimate within the month is dated when its set
+	}
+	before, _ := biz.PlayerPlayDaysOf(est.TelegID, est.DtTm, ctx.DBAdp)
+	if err := biz.UpsertEstimate(est, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	ctx.Snapshot(&biz.Estimate{TelegID: est.TelegID, PlyDys: before, DtTm: est.DtTm}, est)
+	return resp.NewTextResponse(fmt.Sprintf("%c Estimate for %d is %d days in %s", biz.EMOJI_greentick, est.TelegID, est.PlyDys, biz.PeriodOf(est.DtTm)), sebc.ChatId, sebc.MsgId)
+}
+
+func (sebc *SetEstimateBotCmd) CollName() string {
+	return "estimates"
+}
+
+type EstimatesBotCmd struct {
+	*core.AnyBotCmd
+	Month string // YYYY-MM, empty for the current month
+}
+
+func (ebc *EstimatesBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	eq := &biz.EstimatesQ{Month: ebc.Month}
+	if err := biz.MonthEstimates(eq, ctx.DBAdp); err != nil {
+		return uponErr(ebc.ChatId, ebc.MsgId)(err)
+	}
+	if len(eq.Estimates) == 0 {
+		return resp.NewTextResponse(fmt.Sprintf("%c No estimates for %s yet", biz.EMOJI_warning, eq.Month), ebc.ChatId, ebc.MsgId)
+	}
+	return resp.NewTextResponse(eq.ToMsgTxt(), ebc.ChatId, ebc.MsgId)
+}
+
+func (ebc *EstimatesBotCmd) CollName() string {
+	return "estimates"
+}
+
+// MyShareBotCmd : how the share of the sender in the monthly cost changed with the estimates during the month
+type MyShareBotCmd struct {
+	*core.AnyBotCmd
+	Month string // YYYY-MM, empty for the current month
+}
+
+func (msbc *MyShareBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	sq := &biz.ShareQ{TelegID: msbc.SenderId, Month: msbc.Month}
+	if err := biz.PlayerShareHistory(sq, ctx.DBAdp); err != nil {
+		return uponErr(msbc.ChatId, msbc.MsgId)(err)
+	}
+	return resp.NewTextResponse(sq.ToMsgTxt(), msbc.ChatId, msbc.MsgId)
+}
+
+func (msbc *MyShareBotCmd) CollName() string {
+	return "estimates"
+}
+
+// estimateDeadline : answers to the poll for the month are due by this
+// ESTIMATE_DEADLINE_DAY on the environment is the day of the month, 1 when not set - estimates are due before the month starts
+func estimateDeadline(month time.Time) time.Time {
+	day, err := strconv.Atoi(os.Getenv("ESTIMATE_DEADLINE_DAY"))
+	if err != nil || day < 1 || day > biz.MAX_RECUR_DAY {
+		day = 1
+	}
+	return time.Date(month.Year(), month.Month(), day, 0, 0, 0, 0, month.Location())
+}
+
+// estimateDefault : playdays for the members who did not answer the poll
+// ESTIMATE_DEFAULT on the environment, when not a number its the same as their last month
+func estimateDefault() int {
+	days, err := strconv.Atoi(os.Getenv("ESTIMATE_DEFAULT"))
+	if err != nil || days < 0 {
+		return -1
+	}
+	return days
+}
+
+// EstimateDefaultsBotCmd : once the deadline for the poll of the month is past, non-respondents get default estimates
+// not a chat command, the scheduler triggers this
+type EstimateDefaultsBotCmd struct {
+	*core.AnyBotCmd
+}
+
+func (edbc *EstimateDefaultsBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(edbc.ChatId, edbc.MsgId)
+	now := time.Now()
+	month, _ := biz.MonthBoundaryOf(now)
+	sp := &biz.SentPoll{Month: biz.PeriodOf(now)}
+	if err := biz.PollOfMonth(sp, ctx.DBAdp.Switch("polls")); err != nil {
+		if de, _ := err.(*biz.DomainError); errors.Is(de.Err, biz.ERR_POLL404) {
+			return nil // no poll this month, nothing to default
+		}
+		return upon_err(err)
+	}
+	if sp.Defaulted || now.Before(estimateDeadline(month)) {
+		return nil
+	}
+	added, err := biz.DefaultEstimates(sp, estimateDefault(), ctx.DBAdp)
+	if err != nil {
+		return upon_err(err)
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	ctx.Snapshot(nil, added)
+	lines := []string{}
+	for _, e := range added {
+		lines = append(lines, fmt.Sprintf("%d: %d days", e.TelegID, e.PlyDys))
+	}
+	return resp.NewTextResponse(fmt.Sprintf("%c Poll for %s is closed, default estimates for those who did not answer%%0A%s%%0AManagers can change them with /setestimate", biz.EMOJI_warning, sp.Month, strings.Join(lines, "%0A")), edbc.ChatId, edbc.MsgId)
+}
+
+func (edbc *EstimateDefaultsBotCmd) CollName() string {
+	return "estimates"
+}
+
+// EstimateReminderBotCmd : before the deadline, members yet to answer the poll are named on the group
+// not a chat command, the scheduler triggers this
+type EstimateReminderBotCmd struct {
+	*core.AnyBotCmd
+}
+
+func (erbc *EstimateReminderBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(erbc.ChatId, erbc.MsgId)
+	now := time.Now()
+	thisMonth, _ := biz.MonthBoundaryOf(now)
+	for _, month := range []time.Time{thisMonth.AddDate(0, 1, 0), thisMonth} {
+		sp := &biz.SentPoll{Month: biz.PeriodOf(month)}
+		if err := biz.PollOfMonth(sp, ctx.DBAdp.Switch("polls")); err != nil {
+			if de, _ := err.(*biz.DomainError); errors.Is(de.Err, biz.ERR_POLL404) {
+				continue
+			}
+			return upon_err(err)
+		}
+		deadline := estimateDeadline(month)
+		if sp.Defaulted || !now.Before(deadline) {
+			continue
+		}
+		members, err := biz.NonRespondents(month, ctx.DBAdp)
+		if err != nil {
+			return upon_err(err)
+		}
+		if len(members) == 0 {
+			return nil
+		}
+		names := []string{}
+		for _, ua := range members {
+			switch {
+			case ua.UName != "":
+				names = append(names, "@"+ua.UName)
+			case ua.Name != "":
+				names = append(names, ua.Name)
+			default:
+				names = append(names, fmt.Sprintf("%d", ua.TelegID))
+			}
+		}
+		return resp.NewTextResponse(fmt.Sprintf("%c Yet to answer the poll for %s: %s%%0APoll closes on %s, else you get a default estimate", biz.EMOJI_warning, sp.Month, strings.Join(names, ", "), deadline.Format("02-Jan 15:04")), erbc.ChatId, erbc.MsgId)
+	}
+	return nil
+}
+
+func (erbc *EstimateReminderBotCmd) CollName() string {
+	return "estimates"
+}
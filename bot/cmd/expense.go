@@ -8,31 +8,75 @@ Expenses can be added, or aggregated for the month
 ====================*/
 import (
 	"fmt"
+	"os"
+	"strconv"
 	"time"
 
+	"gopkg.in/mgo.v2/bson"
+
 	"github.com/kneerunjun/botmincock/biz"
 	"github.com/kneerunjun/botmincock/bot/core"
 	"github.com/kneerunjun/botmincock/bot/resp"
+	"github.com/kneerunjun/botmincock/dbadp"
 )
 
 type AddExpenseBotCmd struct {
 	*core.AnyBotCmd
 	Val  float32 // total expenditure
 	Desc string  // description of the expenditure
+	Cat  string  // optional category of the expenditure
+	// telegram file id of the receipt, when the command is sent as caption to a photo / document
+	Receipt  string
+	RcptKind string
+}
+
+func (ebc *AddExpenseBotCmd) AsMap() map[string]interface{} {
+	base := ebc.AnyBotCmd.AsMap()
+	base["inr"] = ebc.Val
+	base["desc"] = ebc.Desc
+	base["cat"] = ebc.Cat
+	base["receipt"] = ebc.Receipt
+	return base
+}
+
+// approvalLimit : expenses above this amount need a manager's approval
+// 0 when APPROVAL_LIMIT isnt loaded on the environment, no expense then needs approval
+func approvalLimit() float32 {
+	limit, err := strconv.ParseFloat(os.Getenv("APPROVAL_LIMIT"), 32)
+	if err != nil {
+		return 0.0
+	}
+	return float32(limit)
+}
+
+// reviewButtons : inline keyboard for the managers to approve / reject the expense
+func reviewButtons(expid bson.ObjectId) [][]resp.InlineBtn {
+	return [][]resp.InlineBtn{{
+		{Text: fmt.Sprintf("%c Approve", biz.EMOJI_greentick), Data: fmt.Sprintf("approve:%s", expid.Hex())},
+		{Text: fmt.Sprintf("%c Reject", biz.EMOJI_redcross), Data: fmt.Sprintf("reject:%s", expid.Hex())},
+	}}
 }
 
 // Execute : records a new expense for the sender id
 // timestamp for the expense is the time when this command is executed
 // since expenses are collated monthly - it makes little difference if the time stamp is local or the actual time of expenditure
+// expenses above the approval limit are recorded pending, managers can approve / reject them from the buttons
 // Sends a error response when error in recording expense
 func (ebc *AddExpenseBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
-	exp := &biz.Expense{TelegID: ebc.SenderId, DtTm: time.Now(), Desc: ebc.Desc, INR: ebc.Val}
+	exp := &biz.Expense{TelegID: ebc.SenderId, DtTm: time.Now(), Desc: ebc.Desc, INR: ebc.Val, Cat: ebc.Cat, Receipt: ebc.Receipt, RcptKind: ebc.RcptKind}
+	if limit := approvalLimit(); limit > 0.0 && exp.INR > limit {
+		exp.Status = biz.EXPNS_PENDING
+	}
 	err := biz.RecordExpense(exp, ctx.DBAdp)
 	if err != nil {
 		de, _ := err.(*biz.DomainError)
 		de.LogE()
 		return resp.NewErrResponse(err, de.Loc, de.UserMsg, ebc.ChatId, ebc.MsgId)
 	}
+	ctx.Snapshot(nil, exp)
+	if exp.Status == biz.EXPNS_PENDING {
+		return resp.NewKeybrdResponse(fmt.Sprintf("%c above %.2f needs a manager's approval, recorded %s", biz.EMOJI_warning, approvalLimit(), exp.ToMsgTxt()), reviewButtons(exp.Id), ebc.ChatId, ebc.MsgId)
+	}
 	return resp.NewTextResponse(fmt.Sprintf("successfully recorded %s", exp.ToMsgTxt()), ebc.ChatId, ebc.MsgId)
 }
 
@@ -40,6 +84,220 @@ func (ebc *AddExpenseBotCmd) CollName() string {
 	return "expenses"
 }
 
+/*
+====================
+Managers recording an expense on behalf of a member, the member is credited
+Audit trail records the manager as the sender
+====================
+*/
+type AddExpenseForBotCmd struct {
+	*core.AnyBotCmd
+	TargetId    int64  // telegram id of the member, either this or the username
+	TargetUName string // telegram username of the member without the @
+	Val         float32
+	Desc        string
+	Cat         string
+}
+
+func (aefc *AddExpenseForBotCmd) AsMap() map[string]interface{} {
+	base := aefc.AnyBotCmd.AsMap()
+	base["target_id"] = aefc.TargetId
+	base["target_uname"] = aefc.TargetUName
+	base["inr"] = aefc.Val
+	base["desc"] = aefc.Desc
+	base["cat"] = aefc.Cat
+	return base
+}
+
+// Execute : only managers can record for others, member has to be registered & not archived
+// since the sender is a manager the expense needs no further approval
+func (aefc *AddExpenseForBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(aefc.ChatId, aefc.MsgId)
+	accounts := ctx.DBAdp.Switch("accounts")
+	if err := biz.AssertElevation(&biz.UserAccount{TelegID: aefc.SenderId}, biz.AccElev(biz.Manager), accounts); err != nil {
+		return upon_err(err)
+	}
+	member := &biz.UserAccount{TelegID: aefc.TargetId, UName: aefc.TargetUName}
+	var err error
+	if aefc.TargetUName != "" {
+		err = biz.AccountByUName(member, accounts)
+	} else {
+		err = biz.AccountInfo(member, accounts)
+	}
+	if err != nil {
+		return upon_err(err)
+	}
+	exp := &biz.Expense{TelegID: member.TelegID, AddedBy: aefc.SenderId, DtTm: time.Now(), Desc: aefc.Desc, INR: aefc.Val, Cat: aefc.Cat}
+	if err := biz.RecordExpense(exp, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	ctx.Snapshot(nil, exp)
+	return resp.NewTextResponse(fmt.Sprintf("successfully recorded on behalf of %s, %s", member.Name, exp.ToMsgTxt()), aefc.ChatId, aefc.MsgId)
+}
+
+func (aefc *AddExpenseForBotCmd) CollName() string {
+	return "expenses"
+}
+
+// ownerOrManager : expenses can be altered only by the account that recorded it or a manager
+// Errors when the sender is neither
+func ownerOrManager(owner, sender int64, accounts dbadp.DbAdaptor) error {
+	if owner == sender {
+		return nil
+	}
+	return biz.AssertElevation(&biz.UserAccount{TelegID: sender}, biz.AccElev(biz.Manager), accounts)
+}
+
+/*
+====================
+Correcting an expense already recorded
+Expense is referred to by its unique id as sent back when the expense was recorded
+====================
+*/
+type EditExpenseBotCmd struct {
+	*core.AnyBotCmd
+	ExpId bson.ObjectId // id of the expense to edit
+	Val   float32       // corrected expenditure
+	Desc  string        // corrected description
+}
+
+func (eebc *EditExpenseBotCmd) AsMap() map[string]interface{} {
+	base := eebc.AnyBotCmd.AsMap()
+	base["expid"] = eebc.ExpId.Hex()
+	base["inr"] = eebc.Val
+	base["desc"] = eebc.Desc
+	return base
+}
+
+// Execute : edits the amount & description of the expense along with its matching credit
+// only the owner of the expense or a manager can edit the expense
+func (eebc *EditExpenseBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(eebc.ChatId, eebc.MsgId)
+	exp := &biz.Expense{Id: eebc.ExpId}
+	if err := biz.GetExpense(exp, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	if err := ownerOrManager(exp.TelegID, eebc.SenderId, ctx.DBAdp.Switch("accounts")); err != nil {
+		return upon_err(err)
+	}
+	if limit := approvalLimit(); limit > 0.0 && eebc.Val > limit && eebc.Val > exp.INR {
+		// raising the expense above the approval limit would otherwise skip the approval
+		if err := biz.AssertElevation(&biz.UserAccount{TelegID: eebc.SenderId}, biz.AccElev(biz.Manager), ctx.DBAdp.Switch("accounts")); err != nil {
+			return upon_err(err)
+		}
+	}
+	before := *exp
+	exp.INR, exp.Desc = eebc.Val, eebc.Desc
+	if err := biz.EditExpense(exp, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	ctx.Snapshot(&before, exp)
+	return resp.NewTextResponse(fmt.Sprintf("%c edited, %s", biz.EMOJI_greentick, exp.ToMsgTxt()), eebc.ChatId, eebc.MsgId)
+}
+
+func (eebc *EditExpenseBotCmd) CollName() string {
+	return "expenses"
+}
+
+type DelExpenseBotCmd struct {
+	*core.AnyBotCmd
+	ExpId bson.ObjectId // id of the expense to remove
+}
+
+func (debc *DelExpenseBotCmd) AsMap() map[string]interface{} {
+	base := debc.AnyBotCmd.AsMap()
+	base["expid"] = debc.ExpId.Hex()
+	return base
+}
+
+// Execute : removes the expense and its matching credit
+// only the owner of the expense or a manager can remove the expense
+func (debc *DelExpenseBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(debc.ChatId, debc.MsgId)
+	exp := &biz.Expense{Id: debc.ExpId}
+	if err := biz.GetExpense(exp, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	if err := ownerOrManager(exp.TelegID, debc.SenderId, ctx.DBAdp.Switch("accounts")); err != nil {
+		return upon_err(err)
+	}
+	if err := biz.DeleteExpense(exp, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	ctx.Snapshot(exp, nil)
+	return resp.NewTextResponse(fmt.Sprintf("%c removed, %s", biz.EMOJI_greentick, exp.ToMsgTxt()), debc.ChatId, debc.MsgId)
+}
+
+func (debc *DelExpenseBotCmd) CollName() string {
+	return "expenses"
+}
+
+/*
+====================
+Approving / rejecting expenses above the approval limit, only managers can review
+====================
+*/
+type ReviewExpenseBotCmd struct {
+	*core.AnyBotCmd
+	ExpId   bson.ObjectId
+	Approve bool // false rejects the expense
+}
+
+func (rebc *ReviewExpenseBotCmd) AsMap() map[string]interface{} {
+	base := rebc.AnyBotCmd.AsMap()
+	base["expid"] = rebc.ExpId.Hex()
+	base["approve"] = rebc.Approve
+	return base
+}
+
+func (rebc *ReviewExpenseBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(rebc.ChatId, rebc.MsgId)
+	if err := biz.AssertElevation(&biz.UserAccount{TelegID: rebc.SenderId}, biz.AccElev(biz.Manager), ctx.DBAdp.Switch("accounts")); err != nil {
+		return upon_err(err)
+	}
+	exp := &biz.Expense{Id: rebc.ExpId, Status: biz.EXPNS_REJECTED, ReviewedBy: rebc.SenderId}
+	if rebc.Approve {
+		exp.Status = biz.EXPNS_APPROVED
+	}
+	if err := biz.ReviewExpense(exp, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	ctx.Snapshot(&biz.Expense{Id: exp.Id, Status: biz.EXPNS_PENDING}, exp)
+	return resp.NewTextResponse(fmt.Sprintf("%c %s %s", biz.EMOJI_greentick, exp.Status, exp.ToMsgTxt()), rebc.ChatId, rebc.MsgId)
+}
+
+func (rebc *ReviewExpenseBotCmd) CollName() string {
+	return "expenses"
+}
+
+/*
+====================
+Resending the receipt attached to the expense, so that the spending can be verified
+====================
+*/
+type ReceiptBotCmd struct {
+	*core.AnyBotCmd
+	ExpId bson.ObjectId // id of the expense for which the receipt is sought
+}
+
+func (rbc *ReceiptBotCmd) AsMap() map[string]interface{} {
+	base := rbc.AnyBotCmd.AsMap()
+	base["expid"] = rbc.ExpId.Hex()
+	return base
+}
+
+func (rbc *ReceiptBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	exp := &biz.Expense{Id: rbc.ExpId}
+	if err := biz.ExpenseReceipt(exp, ctx.DBAdp); err != nil {
+		return uponErr(rbc.ChatId, rbc.MsgId)(err)
+	}
+	return resp.NewFileResponse(exp.RcptKind, exp.Receipt, exp.ToMsgTxt(), rbc.ChatId, rbc.MsgId)
+}
+
+func (rbc *ReceiptBotCmd) CollName() string {
+	return "expenses"
+}
+
 type ExpenseAggBotCmd struct {
 	*core.AnyBotCmd
 }
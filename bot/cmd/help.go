@@ -13,7 +13,7 @@ type HelpBotCmd struct {
 }
 
 var (
-	ALL_HELP string = fmt.Sprintf("%c Botmincock v0.0.0 %cPSA Badminton Team %c-%c%%0A%%0ACommands:%%0A@psabadminton_bot /registerme <email>%%0A@psabadminton_bot /editme <new-email>%%0A@psabadminton_bot /myinfo%%0A@psabadminton_bot /deregisterme%%0A@psabadminton_bot /elevateacc <TelegramID>%%0A@psabadminton_bot /addexpense <INR> <remarks>%%0A@psabadminton_bot /myexpense%%0A@psabadminton_bot /allexpenses", biz.EMOJI_robot, biz.EMOJI_copyrt, biz.EMOJI_banana, biz.EMOJI_garlic)
+	ALL_HELP string = fmt.Sprintf("%c Botmincock v0.0.0 %cPSA Badminton Team %c-%c%%0A%%0ACommands:%%0A@psabadminton_bot /registerme <email>%%0A@psabadminton_bot /editme <new-email>%%0A@psabadminton_bot /myinfo%%0A@psabadminton_bot /deregisterme%%0A@psabadminton_bot /elevateacc <TelegramID>%%0A@psabadminton_bot /addexpense <INR> [%%23category] <remarks>%%0A@psabadminton_bot /addexpensefor <TelegramID|@username> <INR> [%%23category] <remarks>%%0A@psabadminton_bot /split <INR> <remarks> @member[:weight] @member..%%0A@psabadminton_bot /editexpense <ID> <INR> <remarks>%%0A@psabadminton_bot /delexpense <ID>%%0A@psabadminton_bot /receipt <ID>%%0A@psabadminton_bot /approve <ID>%%0A@psabadminton_bot /reject <ID>%%0A@psabadminton_bot /myexpense%%0A@psabadminton_bot /allexpenses%%0A@psabadminton_bot /expensesby category [YYYY-MM]%%0A@psabadminton_bot /categories%%0A@psabadminton_bot /addcategory <name>%%0A@psabadminton_bot /delcategory <name>%%0A@psabadminton_bot /addrecurring <TelegramID> <day> <INR> [%%23category] <remarks>%%0A@psabadminton_bot /recurring%%0A@psabadminton_bot /pauserecurring <ID>%%0A@psabadminton_bot /resumerecurring <ID>%%0A@psabadminton_bot /delrecurring <ID>%%0A@psabadminton_bot /mydues [YYYY-MM-DD] [qr]%%0A@psabadminton_bot /paydues <INR>%%0A@psabadminton_bot /confirmpay <ID> [TelegramID]%%0A@psabadminton_bot /declinepay <ID>%%0A@psabadminton_bot /pendingpayments%%0A@psabadminton_bot /pay @member <INR>%%0A@psabadminton_bot /whoowes%%0A@psabadminton_bot /setbudget <INR> [YYYY-MM]%%0A@psabadminton_bot /budget [YYYY-MM]%%0A@psabadminton_bot /costmode [estimates|attendance]%%0A@psabadminton_bot /markattend <TelegramID> <YYYY-MM-DD>%%0A@psabadminton_bot /ungm [<TelegramID> <YYYY-MM-DD>]%%0A@psabadminton_bot /myestimate <days>%%0A@psabadminton_bot /setestimate <TelegramID> <days> [YYYY-MM]%%0A@psabadminton_bot /estimates [YYYY-MM]%%0A@psabadminton_bot /myshare [YYYY-MM]%%0A@psabadminton_bot /lockperiod <YYYY-MM>%%0A@psabadminton_bot /unlockperiod <YYYY-MM>%%0A@psabadminton_bot /audit [TelegramID|@username] [n]%%0A@psabadminton_bot /jobs", biz.EMOJI_robot, biz.EMOJI_copyrt, biz.EMOJI_banana, biz.EMOJI_garlic)
 )
 
 func (info *HelpBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
@@ -0,0 +1,40 @@
+package cmd
+
+/*====================
+Scheduled jobs as run by the in-process scheduler, admins can see when they last ran and when they run next
+====================*/
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kneerunjun/botmincock/biz"
+	"github.com/kneerunjun/botmincock/bot/core"
+	"github.com/kneerunjun/botmincock/bot/resp"
+)
+
+type JobsBotCmd struct {
+	*core.AnyBotCmd
+}
+
+func (jbc *JobsBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(jbc.ChatId, jbc.MsgId)
+	if err := biz.AssertElevation(&biz.UserAccount{TelegID: jbc.SenderId}, biz.AccElev(biz.Admin), ctx.DBAdp.Switch("accounts")); err != nil {
+		return upon_err(err)
+	}
+	runs, err := biz.JobRuns(ctx.DBAdp)
+	if err != nil {
+		return upon_err(err)
+	}
+	if len(runs) == 0 {
+		return resp.NewTextResponse(fmt.Sprintf("%c No jobs scheduled yet", biz.EMOJI_warning), jbc.ChatId, jbc.MsgId)
+	}
+	lines := []string{}
+	for _, jr := range runs {
+		lines = append(lines, jr.ToMsgTxt())
+	}
+	return resp.NewTextResponse(fmt.Sprintf("Scheduled jobs%%0A%%0A%s", strings.Join(lines, "%0A%0A")), jbc.ChatId, jbc.MsgId)
+}
+
+func (jbc *JobsBotCmd) CollName() string {
+	return "jobs"
+}
@@ -5,8 +5,18 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/kneerunjun/botmincock/biz"
 	"github.com/kneerunjun/botmincock/bot/core"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	// data of the inline keyboard buttons as <action>:<id>
+	REGX_CALLBACK_DATA = regexp.MustCompile(`^(?P<cmd>[a-z]+):(?P<id>[0-9a-f]{24})$`)
+	// members of the split as @username with optional weight @username:2
+	REGX_SPLIT_MEMBER = regexp.MustCompile(`@(?P<uname>[a-zA-Z0-9_]{5,32})(:(?P<weight>[\d]{1,2}))?`)
 )
 
 // text_to_cmdargs : for a given pattern this will match the text and then for every subexpnames will form a key value pair
@@ -35,9 +45,9 @@ func ParseBotCmd(updt core.BotUpdate, botCmnds []*regexp.Regexp) (core.BotComman
 	// textual command needs to be broken down to an action that the bot can execute
 	// reference to the original message though remains intact
 	for _, pattrn := range botCmnds {
-		if pattrn.MatchString(updt.Message.Text) {
+		if pattrn.MatchString(updt.MsgText()) {
 			cmdArgs := map[string]interface{}{} // all that a command ever needs to execute and send a reponse
-			matches := pattrn.FindStringSubmatch(updt.Message.Text)
+			matches := pattrn.FindStringSubmatch(updt.MsgText())
 			for i, name := range pattrn.SubexpNames() {
 				if i != 0 && name != "" {
 					cmdArgs[name] = matches[i]
@@ -46,7 +56,7 @@ func ParseBotCmd(updt core.BotUpdate, botCmnds []*regexp.Regexp) (core.BotComman
 			anyCmd := &core.AnyBotCmd{MsgId: updt.Message.Id, ChatId: updt.Message.Chat.Id, SenderId: updt.Message.From.Id}
 			switch cmdArgs["cmd"] {
 			case "registerme":
-				return &RegMeBotCmd{AnyBotCmd: anyCmd, UserEmail: cmdArgs["email"].(string), FullName: fmt.Sprintf("%s %s", updt.Message.From.FName, updt.Message.From.LName)}, nil
+				return &RegMeBotCmd{AnyBotCmd: anyCmd, UserEmail: cmdArgs["email"].(string), FullName: fmt.Sprintf("%s %s", updt.Message.From.FName, updt.Message.From.LName), UName: updt.Message.From.UName}, nil
 			case "myinfo":
 				return &MyInfoBotCmd{AnyBotCmd: anyCmd}, nil
 			case "editme":
@@ -68,15 +78,164 @@ func ParseBotCmd(updt core.BotUpdate, botCmnds []*regexp.Regexp) (core.BotComman
 				if err != nil {
 					return nil, fmt.Errorf("error parsing command, failed to get expenditure amount. Expected numerical value")
 				}
-				return &AddExpenseBotCmd{AnyBotCmd: anyCmd, Val: float32(inrVal), Desc: cmdArgs["desc"].(string)}, nil
+				fileId, kind := updt.Attachment() // command sent as caption to the photo of the receipt
+				return &AddExpenseBotCmd{AnyBotCmd: anyCmd, Val: float32(inrVal), Desc: cmdArgs["desc"].(string), Cat: cmdArgs["cat"].(string), Receipt: fileId, RcptKind: kind}, nil
+			case "addexpensefor":
+				inrVal, err := strconv.ParseFloat(cmdArgs["inr"].(string), 32)
+				if err != nil {
+					return nil, fmt.Errorf("error parsing command, failed to get expenditure amount. Expected numerical value")
+				}
+				target := &AddExpenseForBotCmd{AnyBotCmd: anyCmd, Val: float32(inrVal), Desc: cmdArgs["desc"].(string), Cat: cmdArgs["cat"].(string), TargetUName: cmdArgs["uname"].(string)}
+				if tid := cmdArgs["tid"].(string); tid != "" {
+					if target.TargetId, err = strconv.ParseInt(tid, 10, 64); err != nil {
+						return nil, fmt.Errorf("error parsing command, failed to get ID of the member")
+					}
+				}
+				return target, nil
+			case "split":
+				inrVal, err := strconv.ParseFloat(cmdArgs["inr"].(string), 32)
+				if err != nil {
+					return nil, fmt.Errorf("error parsing command, failed to get expenditure amount. Expected numerical value")
+				}
+				split := &SplitBotCmd{AnyBotCmd: anyCmd, Val: float32(inrVal), Desc: cmdArgs["desc"].(string)}
+				for _, m := range REGX_SPLIT_MEMBER.FindAllStringSubmatch(cmdArgs["members"].(string), -1) {
+					weight := 1
+					if m[3] != "" {
+						weight, _ = strconv.Atoi(m[3])
+					}
+					split.Members = append(split.Members, SplitMember{UName: m[1], Weight: weight})
+				}
+				return split, nil
+			case "editexpense":
+				inrVal, err := strconv.ParseFloat(cmdArgs["inr"].(string), 32)
+				if err != nil {
+					return nil, fmt.Errorf("error parsing command, failed to get expenditure amount. Expected numerical value")
+				}
+				return &EditExpenseBotCmd{AnyBotCmd: anyCmd, ExpId: bson.ObjectIdHex(cmdArgs["expid"].(string)), Val: float32(inrVal), Desc: cmdArgs["desc"].(string)}, nil
+			case "approve", "reject":
+				return &ReviewExpenseBotCmd{AnyBotCmd: anyCmd, ExpId: bson.ObjectIdHex(cmdArgs["expid"].(string)), Approve: cmdArgs["cmd"] == "approve"}, nil
+			case "receipt":
+				return &ReceiptBotCmd{AnyBotCmd: anyCmd, ExpId: bson.ObjectIdHex(cmdArgs["expid"].(string))}, nil
+			case "delexpense":
+				return &DelExpenseBotCmd{AnyBotCmd: anyCmd, ExpId: bson.ObjectIdHex(cmdArgs["expid"].(string))}, nil
+			case "addrecurring":
+				paidBy, err := strconv.ParseInt(cmdArgs["tid"].(string), 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("error parsing command, failed to get ID of the paying account")
+				}
+				day, err := strconv.Atoi(cmdArgs["day"].(string))
+				if err != nil {
+					return nil, fmt.Errorf("error parsing command, failed to get day of the month. Expected numerical value")
+				}
+				inrVal, err := strconv.ParseFloat(cmdArgs["inr"].(string), 32)
+				if err != nil {
+					return nil, fmt.Errorf("error parsing command, failed to get expenditure amount. Expected numerical value")
+				}
+				return &AddRecurringBotCmd{AnyBotCmd: anyCmd, PaidBy: paidBy, Day: day, Val: float32(inrVal), Desc: cmdArgs["desc"].(string), Cat: cmdArgs["cat"].(string)}, nil
+			case "recurring":
+				return &ListRecurringBotCmd{AnyBotCmd: anyCmd}, nil
+			case "pauserecurring", "resumerecurring", "delrecurring":
+				action := map[interface{}]string{"pauserecurring": "pause", "resumerecurring": "resume", "delrecurring": "delete"}[cmdArgs["cmd"]]
+				return &EditRecurringBotCmd{AnyBotCmd: anyCmd, RecurId: bson.ObjectIdHex(cmdArgs["recurid"].(string)), Action: action}, nil
+			case "addcategory", "delcategory":
+				return &ExpenseCtgryBotCmd{AnyBotCmd: anyCmd, Name: cmdArgs["cat"].(string), Remove: cmdArgs["cmd"] == "delcategory"}, nil
+			case "categories":
+				return &ListCtgryBotCmd{AnyBotCmd: anyCmd}, nil
+			case "expensesby":
+				month, err := biz.ParsePeriod(cmdArgs["month"].(string))
+				if err != nil {
+					return nil, fmt.Errorf("error parsing command, invalid month %s expected YYYY-MM", cmdArgs["month"])
+				}
+				return &ExpensesByBotCmd{AnyBotCmd: anyCmd, Month: month}, nil
+			case "setbudget":
+				inrVal, err := strconv.ParseFloat(cmdArgs["inr"].(string), 32)
+				if err != nil {
+					return nil, fmt.Errorf("error parsing command, failed to get budget amount. Expected numerical value")
+				}
+				return &SetBudgetBotCmd{AnyBotCmd: anyCmd, Val: float32(inrVal), Month: cmdArgs["month"].(string)}, nil
+			case "markattend":
+				tid, _ := strconv.ParseInt(cmdArgs["tid"].(string), 10, 64)
+				day, err := time.ParseInLocation("2006-01-02", cmdArgs["day"].(string), time.Local)
+				if err != nil {
+					return nil, fmt.Errorf("error parsing command, invalid date %s expected YYYY-MM-DD", cmdArgs["day"])
+				}
+				return &MarkAttendBotCmd{AnyBotCmd: anyCmd, TargetId: tid, Day: day}, nil
+			case "ungm":
+				ubc := &UngmBotCmd{AnyBotCmd: anyCmd, TargetId: anyCmd.SenderId, Day: time.Now()}
+				if cmdArgs["tid"].(string) != "" {
+					day, err := time.ParseInLocation("2006-01-02", cmdArgs["day"].(string), time.Local)
+					if err != nil {
+						return nil, fmt.Errorf("error parsing command, invalid date %s expected YYYY-MM-DD", cmdArgs["day"])
+					}
+					ubc.TargetId, _ = strconv.ParseInt(cmdArgs["tid"].(string), 10, 64)
+					ubc.Day, ubc.Managed = day, true
+				}
+				return ubc, nil
+			case "costmode":
+				return &CostModeBotCmd{AnyBotCmd: anyCmd, Mode: cmdArgs["mode"].(string)}, nil
+			case "myestimate", "setestimate":
+				days, err := strconv.Atoi(cmdArgs["days"].(string))
+				if err != nil {
+					return nil, fmt.Errorf("error parsing command, failed to get playdays. Expected numerical value")
+				}
+				sec := &SetEstimateBotCmd{AnyBotCmd: anyCmd, TargetId: anyCmd.SenderId, Days: days}
+				if cmdArgs["cmd"] == "setestimate" {
+					sec.TargetId, _ = strconv.ParseInt(cmdArgs["tid"].(string), 10, 64)
+					sec.Month, sec.Managed = cmdArgs["month"].(string), true
+				}
+				return sec, nil
+			case "estimates":
+				return &EstimatesBotCmd{AnyBotCmd: anyCmd, Month: cmdArgs["month"].(string)}, nil
+			case "myshare":
+				return &MyShareBotCmd{AnyBotCmd: anyCmd, Month: cmdArgs["month"].(string)}, nil
+			case "budget":
+				return &BudgetBotCmd{AnyBotCmd: anyCmd, Month: cmdArgs["month"].(string)}, nil
 			case "paydues":
 				inrVal, err := strconv.ParseFloat(cmdArgs["inr"].(string), 32)
 				if err != nil {
 					return nil, fmt.Errorf("error parsing command, failed to get expenditure amount. Expected numerical value")
 				}
 				return &PayDuesBotCmd{AnyBotCmd: anyCmd, Val: float32(inrVal)}, nil
+			case "confirmpay", "declinepay":
+				rpbc := &ReviewPaymentBotCmd{AnyBotCmd: anyCmd, PayId: bson.ObjectIdHex(cmdArgs["payid"].(string)), Confirm: cmdArgs["cmd"] == "confirmpay"}
+				if cmdArgs["tid"].(string) != "" {
+					rpbc.TelegID, _ = strconv.ParseInt(cmdArgs["tid"].(string), 10, 64)
+				}
+				return rpbc, nil
+			case "pendingpayments":
+				return &PendingPaymentsBotCmd{AnyBotCmd: anyCmd}, nil
+			case "pay":
+				inrVal, err := strconv.ParseFloat(cmdArgs["inr"].(string), 32)
+				if err != nil {
+					return nil, fmt.Errorf("error parsing command, failed to get the amount paid. Expected numerical value")
+				}
+				return &PayMemberBotCmd{AnyBotCmd: anyCmd, Payee: cmdArgs["uname"].(string), Val: float32(inrVal)}, nil
+			case "whoowes":
+				return &WhoOwesBotCmd{AnyBotCmd: anyCmd}, nil
 			case "mydues":
-				return &MyDuesBotCmd{AnyBotCmd: anyCmd}, nil
+				mdbc := &MyDuesBotCmd{AnyBotCmd: anyCmd, QR: cmdArgs["qr"] == "qr"}
+				if cmdArgs["day"].(string) != "" {
+					day, err := time.ParseInLocation("2006-01-02", cmdArgs["day"].(string), time.Local)
+					if err != nil {
+						return nil, fmt.Errorf("error parsing command, invalid date %s expected YYYY-MM-DD", cmdArgs["day"])
+					}
+					mdbc.AsOf = day
+				}
+				return mdbc, nil
+			case "lockperiod", "unlockperiod":
+				return &PeriodLockBotCmd{AnyBotCmd: anyCmd, Month: cmdArgs["month"].(string), Unlock: cmdArgs["cmd"] == "unlockperiod"}, nil
+			case "jobs":
+				return &JobsBotCmd{AnyBotCmd: anyCmd}, nil
+			case "audit":
+				// account ids are longer than the 2 digit count, /audit 20 is the latest 20 entries
+				aq := &AuditBotCmd{AnyBotCmd: anyCmd, UName: cmdArgs["uname"].(string)}
+				if cmdArgs["tid"] != "" {
+					aq.TelegID, _ = strconv.ParseInt(cmdArgs["tid"].(string), 10, 64)
+				}
+				if cmdArgs["n"] != "" {
+					aq.Limit, _ = strconv.Atoi(cmdArgs["n"].(string))
+				}
+				return aq, nil
 			case "help":
 				return &HelpBotCmd{AnyBotCmd: anyCmd}, nil
 			default:
@@ -92,7 +251,7 @@ func ParseTextCmd(updt core.BotUpdate, botCmnds []*regexp.Regexp) (core.BotComma
 	// there arent too many components in the message that need to be
 	for _, pattrn := range botCmnds {
 		cmdArgs := map[string]interface{}{} // all that a command ever needs to execute and send a reponse
-		if text_to_cmdargs(pattrn, updt.Message.Text, &cmdArgs) {
+		if text_to_cmdargs(pattrn, updt.MsgText(), &cmdArgs) {
 			anyCmd := &core.AnyBotCmd{MsgId: updt.Message.Id, ChatId: updt.Message.Chat.Id, SenderId: updt.Message.From.Id}
 			cmd := strings.ToLower(cmdArgs["cmd"].(string))
 			switch cmd {
@@ -106,30 +265,37 @@ func ParseTextCmd(updt core.BotUpdate, botCmnds []*regexp.Regexp) (core.BotComma
 	return nil, nil
 }
 
+// ParseCallbackCmd : When someone taps on the inline keyboard button the callback data is converted to command
+// callback data is <action>:<id>, sender is the one who tapped the button
+func ParseCallbackCmd(updt core.BotUpdate) (core.BotCommand, error) {
+	cbq := updt.CallbackQuery
+	cmdArgs := map[string]interface{}{}
+	if !text_to_cmdargs(REGX_CALLBACK_DATA, cbq.Data, &cmdArgs) {
+		return nil, fmt.Errorf("unknown callback data %s", cbq.Data)
+	}
+	anyCmd := &core.AnyBotCmd{MsgId: cbq.Message.Id, ChatId: cbq.Message.Chat.Id, SenderId: cbq.From.Id}
+	switch cmdArgs["cmd"] {
+	case "approve", "reject":
+		return &ReviewExpenseBotCmd{AnyBotCmd: anyCmd, ExpId: bson.ObjectIdHex(cmdArgs["id"].(string)), Approve: cmdArgs["cmd"] == "approve"}, nil
+	case "confirmpay", "declinepay":
+		return &ReviewPaymentBotCmd{AnyBotCmd: anyCmd, PayId: bson.ObjectIdHex(cmdArgs["id"].(string)), Confirm: cmdArgs["cmd"] == "confirmpay"}, nil
+	}
+	return nil, fmt.Errorf("unknown callback action %s", cmdArgs["cmd"])
+}
+
 // ParsePollAnsCmd : When someone answers a poll it sends out an update
 // UPdate such received is then converted to command which can be executed
+// poll answers arent attached to any chat, the voter is responded to in private
+// vote retracted is an update with no options
 func ParsePollAnsCmd(updt core.BotUpdate) (core.BotCommand, error) {
-	if len(updt.PollAnswer.Options) <= 0 {
-		return nil, fmt.Errorf("option selected could not be read, or did you not select any option?")
-	} else {
-		return &PollAnsBotCmd{
-			UserID: updt.PollAnswer.User.Id,
-			// TODO: check out the options here in sequence
-			// For now we are just hard hacking the integers here
-			Playdays: func(opt int) int {
-				switch opt {
-				case 0:
-					return 30
-				case 1:
-					return 15
-				case 2:
-					return 8
-				case 3:
-					return 0
-				}
-				return 31
-			}(updt.PollAnswer.Options[0]),
-		}, nil
+	pac := &PollAnsBotCmd{
+		AnyBotCmd: &core.AnyBotCmd{SenderId: updt.PollAnswer.User.Id, ChatId: updt.PollAnswer.User.Id},
+		UserID:    updt.PollAnswer.User.Id,
+		PollId:    updt.PollAnswer.Id,
+		Retract:   len(updt.PollAnswer.Options) == 0,
 	}
-
+	if !pac.Retract {
+		pac.Option = updt.PollAnswer.Options[0] // playdays for the option are from the poll as it was sent
+	}
+	return pac, nil
 }
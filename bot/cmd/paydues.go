@@ -2,11 +2,18 @@ package cmd
 
 import (
 	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/kneerunjun/botmincock/biz"
 	"github.com/kneerunjun/botmincock/bot/core"
 	"github.com/kneerunjun/botmincock/bot/resp"
+	log "github.com/sirupsen/logrus"
+	qrcode "github.com/skip2/go-qrcode"
+	"gopkg.in/mgo.v2/bson"
 )
 
 type PayDuesBotCmd struct {
@@ -14,30 +21,162 @@ type PayDuesBotCmd struct {
 	Val float32 // total expenditure
 }
 
-// Execute : records a new expense for the sender id
-// timestamp for the expense is the time when this command is executed
-// since expenses are collated monthly - it makes little difference if the time stamp is local or the actual time of expenditure
-// Sends a error response when error in recording expense
+func (pdc *PayDuesBotCmd) AsMap() map[string]interface{} {
+	base := pdc.AnyBotCmd.AsMap()
+	base["inr"] = pdc.Val
+	return base
+}
+
+// paymentButtons : inline keyboard for the managers to confirm / decline the payment
+func paymentButtons(payid bson.ObjectId) [][]resp.InlineBtn {
+	return [][]resp.InlineBtn{{
+		{Text: fmt.Sprintf("%c Received", biz.EMOJI_greentick), Data: fmt.Sprintf("confirmpay:%s", payid.Hex())},
+		{Text: fmt.Sprintf("%c Not received", biz.EMOJI_redcross), Data: fmt.Sprintf("declinepay:%s", payid.Hex())},
+	}}
+}
+
+// Execute : records the payment from the sender as pending
+// dues are cleared only when a manager confirms the money is received, from the buttons or /confirmpay
+// Sends a error response when error in recording the payment
 func (pdc *PayDuesBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
-	trnsc := &biz.Transac{TelegID: pdc.SenderId, Credit: pdc.Val, DtTm: time.Now(), Desc: "Clearing dues.."}
-	err := biz.ClearDues(trnsc, ctx.DBAdp)
-	if err != nil {
-		de, _ := err.(*biz.DomainError)
-		de.LogE()
-		return resp.NewErrResponse(err, de.Loc, de.UserMsg, pdc.ChatId, pdc.MsgId)
+	pp := &biz.PendingPayment{TelegID: pdc.SenderId, INR: pdc.Val, DtTm: time.Now()}
+	if err := biz.RequestPayment(pp, ctx.DBAdp); err != nil {
+		return uponErr(pdc.ChatId, pdc.MsgId)(err)
 	}
-	return resp.NewTextResponse(fmt.Sprintf("transacted %s", trnsc.ToMsgTxt()), pdc.ChatId, pdc.MsgId)
+	ctx.Snapshot(nil, pp)
+	return resp.NewKeybrdResponse(fmt.Sprintf("%c Payment awaiting confirmation from a manager%%0A%s", biz.EMOJI_warning, pp.ToMsgTxt()), paymentButtons(pp.Id), pdc.ChatId, pdc.MsgId)
 }
 
 func (ebc *PayDuesBotCmd) CollName() string {
-	return "transacs"
+	return "payments"
+}
+
+/*
+====================
+Managers confirming the payment is received, only then the dues are cleared
+====================
+*/
+type ReviewPaymentBotCmd struct {
+	*core.AnyBotCmd
+	PayId   bson.ObjectId
+	Confirm bool  // false declines the payment
+	TelegID int64 // member the payment is from, only for the gateway payments that came in without one
+}
+
+func (rpbc *ReviewPaymentBotCmd) AsMap() map[string]interface{} {
+	base := rpbc.AnyBotCmd.AsMap()
+	base["payid"] = rpbc.PayId.Hex()
+	base["confirm"] = rpbc.Confirm
+	if rpbc.TelegID != 0 {
+		base["tid"] = rpbc.TelegID
+	}
+	return base
+}
+
+func (rpbc *ReviewPaymentBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(rpbc.ChatId, rpbc.MsgId)
+	if err := biz.AssertElevation(&biz.UserAccount{TelegID: rpbc.SenderId}, biz.AccElev(biz.Manager), ctx.DBAdp.Switch("accounts")); err != nil {
+		return upon_err(err)
+	}
+	pp := &biz.PendingPayment{Id: rpbc.PayId, TelegID: rpbc.TelegID, Status: biz.PAYMNT_DECLINED, ReviewedBy: rpbc.SenderId}
+	if rpbc.Confirm {
+		pp.Status = biz.PAYMNT_CONFIRMED
+	}
+	if err := biz.ReviewPayment(pp, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	ctx.Snapshot(&biz.PendingPayment{Id: pp.Id, Status: biz.PAYMNT_PENDING}, pp)
+	if pp.Status == biz.PAYMNT_DECLINED {
+		return resp.NewTextResponse(fmt.Sprintf("%c Payment declined, dues not cleared%%0A%s", biz.EMOJI_redcross, pp.ToMsgTxt()), rpbc.ChatId, rpbc.MsgId)
+	}
+	return resp.NewTextResponse(fmt.Sprintf("%c Payment confirmed, dues cleared%%0A%s", biz.EMOJI_greentick, pp.ToMsgTxt()), rpbc.ChatId, rpbc.MsgId)
+}
+
+func (rpbc *ReviewPaymentBotCmd) CollName() string {
+	return "payments"
+}
+
+// PendingPaymentsBotCmd : lists the payments awaiting confirmation
+// Remind when true, lists only the payments waiting longer than PAYMENT_REMIND_HRS, the scheduler triggers this
+type PendingPaymentsBotCmd struct {
+	*core.AnyBotCmd
+	Remind bool
+}
+
+func (ppbc *PendingPaymentsBotCmd) AsMap() map[string]interface{} {
+	base := ppbc.AnyBotCmd.AsMap()
+	base["remind"] = ppbc.Remind
+	return base
+}
+
+// paymentRemindAfter : payments pending longer than this are reminded, 24 hours when not set on the environment
+func paymentRemindAfter() time.Duration {
+	hrs, err := strconv.Atoi(os.Getenv("PAYMENT_REMIND_HRS"))
+	if err != nil || hrs <= 0 {
+		return 24 * time.Hour
+	}
+	return time.Duration(hrs) * time.Hour
+}
+
+func (ppbc *PendingPaymentsBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	before := time.Time{}
+	if ppbc.Remind {
+		before = time.Now().Add(-paymentRemindAfter())
+	}
+	pending, err := biz.PendingPayments(before, ctx.DBAdp)
+	if err != nil {
+		return uponErr(ppbc.ChatId, ppbc.MsgId)(err)
+	}
+	if len(pending) == 0 {
+		if ppbc.Remind {
+			return nil // nothing waiting too long, nothing to remind
+		}
+		return resp.NewTextResponse(fmt.Sprintf("%c No payments pending confirmation", biz.EMOJI_greentick), ppbc.ChatId, ppbc.MsgId)
+	}
+	lines := []string{}
+	for _, pp := range pending {
+		lines = append(lines, pp.ToMsgTxt())
+	}
+	title := "Payments pending confirmation"
+	if ppbc.Remind {
+		title = "Managers, kindly confirm these payments"
+	}
+	return resp.NewTextResponse(fmt.Sprintf("%c %s%%0A%s%%0A%%0A/confirmpay <ID> or /declinepay <ID>", biz.EMOJI_warning, title, strings.Join(lines, "%0A")), ppbc.ChatId, ppbc.MsgId)
+}
+
+func (ppbc *PendingPaymentsBotCmd) CollName() string {
+	return "payments"
 }
 
 type MyDuesBotCmd struct {
 	*core.AnyBotCmd
+	QR   bool      // when true, the UPI link is sent as QR code image
+	AsOf time.Time // when set, the balance as it stood at the end of this day is sent instead
 }
 
+func (mdbc *MyDuesBotCmd) AsMap() map[string]interface{} {
+	base := mdbc.AnyBotCmd.AsMap()
+	base["qr"] = mdbc.QR
+	if !mdbc.AsOf.IsZero() {
+		base["asof"] = mdbc.AsOf
+	}
+	return base
+}
+
+// Execute : gets the balance of the sender for the month
+// when there are dues and TREASURER_VPA is set on the environment, UPI link to pay the exact amount is sent along
+// with a date, the balance is reconstructed as it was known at the end of that day, no payment link for past balances
 func (mdbc *MyDuesBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	if !mdbc.AsOf.IsZero() {
+		_, eod := biz.DayAsBoundary(mdbc.AsOf)
+		bal := &biz.Balance{TelegID: mdbc.SenderId, DtTm: eod}
+		if err := biz.BalanceAsOf(bal, ctx.DBAdp); err != nil {
+			de, _ := err.(*biz.DomainError)
+			de.LogE()
+			return resp.NewErrResponse(err, de.Loc, de.UserMsg, mdbc.ChatId, mdbc.MsgId)
+		}
+		return resp.NewTextResponse(fmt.Sprintf("As of %s: %s", mdbc.AsOf.Format("02-Jan-2006"), bal.ToMsgTxt()), mdbc.ChatId, mdbc.MsgId)
+	}
 	bal := &biz.Balance{TelegID: mdbc.SenderId, DtTm: time.Now()}
 	err := biz.MyDues(bal, ctx.DBAdp)
 	if err != nil {
@@ -45,7 +184,21 @@ func (mdbc *MyDuesBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
 		de.LogE()
 		return resp.NewErrResponse(err, de.Loc, de.UserMsg, mdbc.ChatId, mdbc.MsgId)
 	}
-	return resp.NewTextResponse(bal.ToMsgTxt(), mdbc.ChatId, mdbc.MsgId)
+	vpa := os.Getenv("TREASURER_VPA")
+	if bal.Due >= 0.0 || vpa == "" {
+		return resp.NewTextResponse(bal.ToMsgTxt(), mdbc.ChatId, mdbc.MsgId)
+	}
+	link := biz.UPILink(vpa, os.Getenv("TREASURER_NAME"), -bal.Due, biz.DuesNote(mdbc.SenderId))
+	if mdbc.QR {
+		png, err := qrcode.Encode(link, qrcode.Medium, 256)
+		if err == nil {
+			return resp.NewUploadResponse("dues.png", png, fmt.Sprintf("%s%%0AScan to pay, then /paydues %.0f", bal.ToMsgTxt(), -bal.Due), mdbc.ChatId, mdbc.MsgId)
+		}
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Warn("failed to render the UPI QR code, sending the link instead")
+	}
+	return resp.NewTextResponse(fmt.Sprintf("%s%%0APay here: %s%%0AThen /paydues %.0f", bal.ToMsgTxt(), url.QueryEscape(link), -bal.Due), mdbc.ChatId, mdbc.MsgId)
 }
 func (mdbc *MyDuesBotCmd) CollName() string {
 	return "transacs"
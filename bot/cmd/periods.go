@@ -0,0 +1,60 @@
+package cmd
+
+/*====================
+Closing and re-opening the books for a month
+Only admins can lock / unlock the period, once locked none of the biz functions can write into the month
+====================*/
+import (
+	"fmt"
+	"time"
+
+	"github.com/kneerunjun/botmincock/biz"
+	"github.com/kneerunjun/botmincock/bot/core"
+	"github.com/kneerunjun/botmincock/bot/resp"
+)
+
+type PeriodLockBotCmd struct {
+	*core.AnyBotCmd
+	Month  string // YYYY-MM
+	Unlock bool   // when true the month is re-opened
+}
+
+func (plbc *PeriodLockBotCmd) AsMap() map[string]interface{} {
+	base := plbc.AnyBotCmd.AsMap()
+	base["month"] = plbc.Month
+	base["unlock"] = plbc.Unlock
+	return base
+}
+
+// Execute : verifies the sender is an admin and then locks/unlocks the month
+func (plbc *PeriodLockBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(plbc.ChatId, plbc.MsgId)
+	if err := biz.AssertElevation(&biz.UserAccount{TelegID: plbc.SenderId}, biz.AccElev(biz.Admin), ctx.DBAdp.Switch("accounts")); err != nil {
+		return upon_err(err)
+	}
+	pl := &biz.PeriodLock{Month: plbc.Month, LockedBy: plbc.SenderId, DtTm: time.Now()}
+	if plbc.Unlock {
+		if err := biz.UnlockPeriod(pl, ctx.DBAdp); err != nil {
+			return upon_err(err)
+		}
+		ctx.Snapshot(pl, nil)
+		return resp.NewTextResponse(fmt.Sprintf("%c Books for %s are open again", biz.EMOJI_greentick, pl.Month), plbc.ChatId, plbc.MsgId)
+	}
+	// before the books are closed, recoveries are trued-up against the actual expenses
+	trueups, err := biz.TrueUpMonth(pl.Month, ctx.DBAdp)
+	if err != nil {
+		return upon_err(err)
+	}
+	if err := biz.LockPeriod(pl, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	ctx.Snapshot(nil, map[string]interface{}{"lock": pl, "trueups": trueups})
+	if len(trueups) > 0 {
+		return resp.NewTextResponse(fmt.Sprintf("%s%%0A%d accounts trued-up against the actual expenses", pl.ToMsgTxt(), len(trueups)), plbc.ChatId, plbc.MsgId)
+	}
+	return resp.NewTextResponse(pl.ToMsgTxt(), plbc.ChatId, plbc.MsgId)
+}
+
+func (plbc *PeriodLockBotCmd) CollName() string {
+	return "periodlocks"
+}
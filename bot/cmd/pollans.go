@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/kneerunjun/botmincock/biz"
@@ -12,25 +14,68 @@ import (
 // PollAnsBotCmd : command received when someone answers the poll
 type PollAnsBotCmd struct {
 	*core.AnyBotCmd
-	UserID   int64
-	Playdays int
+	UserID  int64
+	PollId  string
+	Option  int  // index of the option chosen
+	Retract bool // voter withdrew the answer, estimate for the month is zeroed
+}
+
+func (pabc *PollAnsBotCmd) AsMap() map[string]interface{} {
+	base := pabc.AnyBotCmd.AsMap()
+	base["tid"] = pabc.UserID
+	base["pollid"] = pabc.PollId
+	base["option"] = pabc.Option
+	base["retract"] = pabc.Retract
+	return base
 }
 
 func (pabc *PollAnsBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
-	est := &biz.Estimate{TelegID: pabc.UserID, PlyDys: pabc.Playdays, DtTm: time.Now()}
-	err := biz.UpsertEstimate(est, ctx.DBAdp.Switch("estimates"))
+	sp := &biz.SentPoll{PollId: pabc.PollId}
+	err := biz.PollOf(sp, ctx.DBAdp.Switch("polls"))
+	if err != nil {
+		de := err.(*biz.DomainError)
+		de.LogE()
+		if errors.Is(de.Err, biz.ERR_POLL404) {
+			return nil // answer to some poll not sent by the bot
+		}
+		return resp.NewErrResponse(err, de.Loc, de.UserMsg, pabc.ChatId, pabc.MsgId)
+	}
+	if sp.Expired(time.Now()) {
+		log.WithFields(log.Fields{
+			"pollid": sp.PollId,
+			"month":  sp.Month,
+		}).Warn("ignoring answer to an expired poll")
+		return nil
+	}
+	days := 0 // retracted vote is as good as out for the month
+	if !pabc.Retract {
+		days, err = sp.Playdays(pabc.Option)
+		if err != nil {
+			de := err.(*biz.DomainError)
+			de.LogE()
+			return resp.NewErrResponse(err, de.Loc, de.UserMsg, pabc.ChatId, pabc.MsgId)
+		}
+	}
+	est := &biz.Estimate{TelegID: pabc.UserID, PlyDys: days, DtTm: sp.EstimateMonth(time.Now())}
+	// changed vote updates the estimate, playdays before the change are for the audit trail
+	before, _ := biz.PlayerPlayDaysOf(est.TelegID, est.DtTm, ctx.DBAdp.Switch("estimates"))
+	err = biz.UpsertEstimate(est, ctx.DBAdp.Switch("estimates"))
 	if err != nil {
 		de := err.(*biz.DomainError)
 		de.LogE()
 		return resp.NewErrResponse(err, de.Loc, de.UserMsg, pabc.ChatId, pabc.MsgId)
 	}
-	// for the playdays mentioned this can update the estimates for the player current month
+	ctx.Snapshot(&biz.Estimate{TelegID: est.TelegID, PlyDys: before, DtTm: est.DtTm}, est)
 	log.WithFields(log.Fields{
 		"telegid":  est.TelegID,
 		"playdays": est.PlyDys,
+		"month":    sp.Month,
+		"retract":  pabc.Retract,
 	}).Info("Noting a poll response")
-	// BUG: cannot in any case send back a nil response ?
-	return nil
+	if pabc.Retract {
+		return resp.NewTextResponse(fmt.Sprintf("%c You withdrew your answer to the poll, estimate for %s is now 0 days. You would be charged as a guest on the days you play, vote again to change this", biz.EMOJI_warning, sp.Month), pabc.ChatId, 0)
+	}
+	return resp.NewTextResponse(fmt.Sprintf("%c Noted your estimate of %d days for %s", biz.EMOJI_greentick, est.PlyDys, sp.Month), pabc.ChatId, 0)
 }
 
 func (pabc *PollAnsBotCmd) CollName() string {
@@ -186,3 +186,22 @@ func TestAttendCmd(t *testing.T) {
 	assert.Equal(t, "*resp.TxtBotResp", reflect.TypeOf(resp).String(), "Unexpected type of response")
 	t.Log(resp.UserMessage())
 }
+
+func TestParsePollAns(t *testing.T) {
+	updt := core.BotUpdate{}
+	updt.PollAnswer.Id = "5350906283714150403"
+	updt.PollAnswer.User.Id = 5157350442
+	updt.PollAnswer.Options = []int{2}
+	c, err := ParsePollAnsCmd(updt)
+	assert.Nil(t, err, "Unexpected error parsing poll answer")
+	pac := c.(*PollAnsBotCmd)
+	assert.Equal(t, 2, pac.Option, "Unexpected option parsed")
+	assert.False(t, pac.Retract, "Unexpected retraction for an answer")
+	// TEST: voter is responded to in private since poll answers arent in any chat
+	assert.Equal(t, int64(5157350442), pac.ChatId, "Unexpected chat for responding to the voter")
+	// TEST: retracted vote has no options
+	updt.PollAnswer.Options = []int{}
+	c, err = ParsePollAnsCmd(updt)
+	assert.Nil(t, err, "Unexpected error parsing retracted vote")
+	assert.True(t, c.(*PollAnsBotCmd).Retract, "Unexpected retracted vote not parsed as retraction")
+}
@@ -0,0 +1,156 @@
+package cmd
+
+/*====================
+Recurring expenses: admins define expenses that repeat every month, court rent for example
+The scheduler posts them as expenses on the day they are due
+====================*/
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kneerunjun/botmincock/biz"
+	"github.com/kneerunjun/botmincock/bot/core"
+	"github.com/kneerunjun/botmincock/bot/resp"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type AddRecurringBotCmd struct {
+	*core.AnyBotCmd
+	PaidBy int64   // account of the member who pays every month
+	Day    int     // day of the month the expense is due
+	Val    float32 // amount in INR
+	Desc   string
+	Cat    string // optional category
+}
+
+func (arbc *AddRecurringBotCmd) AsMap() map[string]interface{} {
+	base := arbc.AnyBotCmd.AsMap()
+	base["paidby"] = arbc.PaidBy
+	base["day"] = arbc.Day
+	base["inr"] = arbc.Val
+	base["desc"] = arbc.Desc
+	base["cat"] = arbc.Cat
+	return base
+}
+
+// Execute : only admins can define recurring expenses, the paying member has to have an account
+func (arbc *AddRecurringBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(arbc.ChatId, arbc.MsgId)
+	if err := biz.AssertElevation(&biz.UserAccount{TelegID: arbc.SenderId}, biz.AccElev(biz.Admin), ctx.DBAdp.Switch("accounts")); err != nil {
+		return upon_err(err)
+	}
+	if err := biz.AccountInfo(&biz.UserAccount{TelegID: arbc.PaidBy}, ctx.DBAdp.Switch("accounts")); err != nil {
+		return upon_err(err)
+	}
+	re := &biz.RecurringExpense{TelegID: arbc.PaidBy, Day: arbc.Day, INR: arbc.Val, Desc: arbc.Desc, Cat: arbc.Cat, AddedBy: arbc.SenderId, DtTm: time.Now()}
+	if err := biz.AddRecurringExpense(re, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	ctx.Snapshot(nil, re)
+	return resp.NewTextResponse(fmt.Sprintf("%c Recurring expense added%%0A%s", biz.EMOJI_greentick, re.ToMsgTxt()), arbc.ChatId, arbc.MsgId)
+}
+
+func (arbc *AddRecurringBotCmd) CollName() string {
+	return "recurexpenses"
+}
+
+type ListRecurringBotCmd struct {
+	*core.AnyBotCmd
+}
+
+func (lrbc *ListRecurringBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	all, err := biz.RecurringExpenses(ctx.DBAdp)
+	if err != nil {
+		return uponErr(lrbc.ChatId, lrbc.MsgId)(err)
+	}
+	if len(all) == 0 {
+		return resp.NewTextResponse("No recurring expenses defined", lrbc.ChatId, lrbc.MsgId)
+	}
+	lines := []string{}
+	for _, re := range all {
+		lines = append(lines, re.ToMsgTxt())
+	}
+	return resp.NewTextResponse(fmt.Sprintf("Recurring expenses:%%0A%s", strings.Join(lines, "%0A")), lrbc.ChatId, lrbc.MsgId)
+}
+
+func (lrbc *ListRecurringBotCmd) CollName() string {
+	return "recurexpenses"
+}
+
+// EditRecurringBotCmd : pause, resume or delete a recurring expense
+type EditRecurringBotCmd struct {
+	*core.AnyBotCmd
+	RecurId bson.ObjectId
+	Action  string // pause / resume / delete
+}
+
+func (erbc *EditRecurringBotCmd) AsMap() map[string]interface{} {
+	base := erbc.AnyBotCmd.AsMap()
+	base["recurid"] = erbc.RecurId.Hex()
+	base["action"] = erbc.Action
+	return base
+}
+
+func (erbc *EditRecurringBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(erbc.ChatId, erbc.MsgId)
+	if err := biz.AssertElevation(&biz.UserAccount{TelegID: erbc.SenderId}, biz.AccElev(biz.Admin), ctx.DBAdp.Switch("accounts")); err != nil {
+		return upon_err(err)
+	}
+	re := &biz.RecurringExpense{Id: erbc.RecurId}
+	switch erbc.Action {
+	case "delete":
+		if err := biz.DeleteRecurringExpense(re, ctx.DBAdp); err != nil {
+			return upon_err(err)
+		}
+		ctx.Snapshot(re, nil)
+		return resp.NewTextResponse(fmt.Sprintf("%c Recurring expense %s deleted", biz.EMOJI_greentick, re.Id.Hex()), erbc.ChatId, erbc.MsgId)
+	default:
+		re.Paused = erbc.Action == "pause"
+		if err := biz.PauseRecurringExpense(re, ctx.DBAdp); err != nil {
+			return upon_err(err)
+		}
+		ctx.Snapshot(nil, re)
+		return resp.NewTextResponse(fmt.Sprintf("%c Recurring expense %s %sd", biz.EMOJI_greentick, re.Id.Hex(), erbc.Action), erbc.ChatId, erbc.MsgId)
+	}
+}
+
+func (erbc *EditRecurringBotCmd) CollName() string {
+	return "recurexpenses"
+}
+
+// PostRecurringBotCmd : posts the recurring expenses due as of today
+// not a chat command, the scheduler triggers this
+type PostRecurringBotCmd struct {
+	*core.AnyBotCmd
+	AsOf time.Time // day as of which the expenses are posted, zero time is today
+}
+
+func (prbc *PostRecurringBotCmd) AsMap() map[string]interface{} {
+	base := prbc.AnyBotCmd.AsMap()
+	base["asof"] = prbc.AsOf
+	return base
+}
+
+func (prbc *PostRecurringBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	if prbc.AsOf.IsZero() {
+		prbc.AsOf = time.Now()
+	}
+	posted, err := biz.PostDueRecurring(prbc.AsOf, ctx.DBAdp)
+	if len(posted) == 0 {
+		if err != nil {
+			return uponErr(prbc.ChatId, prbc.MsgId)(err)
+		}
+		return nil // nothing was due, nothing to announce
+	}
+	ctx.Snapshot(nil, posted)
+	lines := []string{}
+	for _, exp := range posted {
+		lines = append(lines, fmt.Sprintf("%s: %s", exp.Desc, exp.ToMsgTxt()))
+	}
+	return resp.NewTextResponse(fmt.Sprintf("%c Recurring expenses posted%%0A%s", biz.EMOJI_greentick, strings.Join(lines, "%0A")), prbc.ChatId, prbc.MsgId)
+}
+
+func (prbc *PostRecurringBotCmd) CollName() string {
+	return "recurexpenses"
+}
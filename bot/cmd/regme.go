@@ -14,6 +14,7 @@ type RegMeBotCmd struct {
 	*core.AnyBotCmd
 	UserEmail string `json:"email"`
 	FullName  string `json:"full_name"`
+	UName     string `json:"username"` // telegram @username, empty when the user has not set one
 }
 
 func (rbc *RegMeBotCmd) Log() {
@@ -27,6 +28,7 @@ func (rbc *RegMeBotCmd) AsMap() map[string]interface{} {
 	base := rbc.AnyBotCmd.AsMap()
 	base["email"] = rbc.UserEmail
 	base["full_name"] = rbc.FullName
+	base["username"] = rbc.UName
 	return base
 }
 
@@ -38,13 +40,14 @@ func (rbc *RegMeBotCmd) AsJsonByt() []byte {
 // Execute : from the command will pick the params required for registering a new account
 // Upon getting the account registered text response of the newly registered account
 func (reg *RegMeBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
-	newAcc := &biz.UserAccount{TelegID: reg.SenderId, Email: reg.UserEmail, Name: reg.FullName}
+	newAcc := &biz.UserAccount{TelegID: reg.SenderId, Email: reg.UserEmail, Name: reg.FullName, UName: reg.UName}
 	err := biz.RegisterNewAccount(newAcc, ctx.DBAdp)
 	if err != nil {
 		de, _ := err.(*biz.DomainError)
 		de.LogE()
 		return resp.NewErrResponse(err, de.Loc, de.UserMsg, reg.ChatId, reg.MsgId)
 	}
+	ctx.Snapshot(nil, newAcc)
 	return resp.NewTextResponse(newAcc.ToMsgTxt(), reg.ChatId, reg.MsgId)
 }
 
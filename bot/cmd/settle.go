@@ -0,0 +1,88 @@
+package cmd
+
+/*====================
+Members paying each other directly, and the report of who owes whom
+====================*/
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kneerunjun/botmincock/biz"
+	"github.com/kneerunjun/botmincock/bot/core"
+	"github.com/kneerunjun/botmincock/bot/resp"
+)
+
+type PayMemberBotCmd struct {
+	*core.AnyBotCmd
+	Payee string // telegram username of the member paid, without the @
+	Val   float32
+}
+
+func (pmbc *PayMemberBotCmd) AsMap() map[string]interface{} {
+	base := pmbc.AnyBotCmd.AsMap()
+	base["payee"] = pmbc.Payee
+	base["inr"] = pmbc.Val
+	return base
+}
+
+// Execute : sender is the payer, both the payer & payee have to be registered
+func (pmbc *PayMemberBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(pmbc.ChatId, pmbc.MsgId)
+	accounts := ctx.DBAdp.Switch("accounts")
+	if err := biz.AccountInfo(&biz.UserAccount{TelegID: pmbc.SenderId}, accounts); err != nil {
+		return upon_err(err)
+	}
+	payee := &biz.UserAccount{UName: pmbc.Payee}
+	if err := biz.AccountByUName(payee, accounts); err != nil {
+		return upon_err(err)
+	}
+	tf := &biz.Transfer{Payer: pmbc.SenderId, Payee: payee.TelegID, INR: pmbc.Val, DtTm: time.Now()}
+	if err := biz.RecordTransfer(tf, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	ctx.Snapshot(nil, tf)
+	return resp.NewTextResponse(fmt.Sprintf("%c recorded %.2f paid to %s", biz.EMOJI_greentick, tf.INR, payee.Name), pmbc.ChatId, pmbc.MsgId)
+}
+
+func (pmbc *PayMemberBotCmd) CollName() string {
+	return "transacs"
+}
+
+// WhoOwesBotCmd : simplified list of payments that would settle all the members
+type WhoOwesBotCmd struct {
+	*core.AnyBotCmd
+}
+
+func (wobc *WhoOwesBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	balances, err := biz.MemberBalances(ctx.DBAdp)
+	if err != nil {
+		return uponErr(wobc.ChatId, wobc.MsgId)(err)
+	}
+	settlements := biz.SimplifyDebts(balances)
+	if len(settlements) == 0 {
+		return resp.NewTextResponse(fmt.Sprintf("%c No one owes anyone, all settled", biz.EMOJI_greentick), wobc.ChatId, wobc.MsgId)
+	}
+	accounts := ctx.DBAdp.Switch("accounts")
+	names := map[int64]string{}
+	nameOf := func(tid int64) string {
+		if n, ok := names[tid]; ok {
+			return n
+		}
+		names[tid] = fmt.Sprintf("%d", tid) // accounts no longer registered are referred by id
+		ua := &biz.UserAccount{TelegID: tid}
+		if err := biz.AccountInfo(ua, accounts); err == nil {
+			names[tid] = ua.Name
+		}
+		return names[tid]
+	}
+	lines := []string{}
+	for _, s := range settlements {
+		lines = append(lines, fmt.Sprintf("%s pays %s %.2f", nameOf(s.From), nameOf(s.To), s.INR))
+	}
+	return resp.NewTextResponse(fmt.Sprintf("Who owes whom:%%0A%s", strings.Join(lines, "%0A")), wobc.ChatId, wobc.MsgId)
+}
+
+func (wobc *WhoOwesBotCmd) CollName() string {
+	return "transacs"
+}
@@ -0,0 +1,73 @@
+package cmd
+
+/*====================
+Splitting an ad-hoc expense amongst a few members, weights can be given as @username:2
+None of it goes into the shared monthly pool
+====================*/
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kneerunjun/botmincock/biz"
+	"github.com/kneerunjun/botmincock/bot/core"
+	"github.com/kneerunjun/botmincock/bot/resp"
+)
+
+// SplitMember : member as mentioned in the split command
+type SplitMember struct {
+	UName  string // telegram username without the @
+	Weight int    // 1 for equal share
+}
+
+type SplitBotCmd struct {
+	*core.AnyBotCmd
+	Val     float32
+	Desc    string
+	Members []SplitMember
+}
+
+func (sbc *SplitBotCmd) AsMap() map[string]interface{} {
+	base := sbc.AnyBotCmd.AsMap()
+	base["inr"] = sbc.Val
+	base["desc"] = sbc.Desc
+	members := []string{}
+	for _, m := range sbc.Members {
+		members = append(members, fmt.Sprintf("@%s:%d", m.UName, m.Weight))
+	}
+	base["members"] = members
+	return base
+}
+
+// Execute : sender is the payer, sender and all the members have to be registered
+func (sbc *SplitBotCmd) Execute(ctx *core.CmdExecCtx) core.BotResponse {
+	upon_err := uponErr(sbc.ChatId, sbc.MsgId)
+	accounts := ctx.DBAdp.Switch("accounts")
+	payer := &biz.UserAccount{TelegID: sbc.SenderId}
+	if err := biz.AccountInfo(payer, accounts); err != nil {
+		return upon_err(err)
+	}
+	sp := &biz.Split{PaidBy: sbc.SenderId, INR: sbc.Val, Desc: strings.TrimSpace(sbc.Desc), DtTm: time.Now()}
+	names := map[int64]string{}
+	for _, m := range sbc.Members {
+		ua := &biz.UserAccount{UName: m.UName}
+		if err := biz.AccountByUName(ua, accounts); err != nil {
+			return upon_err(err)
+		}
+		names[ua.TelegID] = m.UName
+		sp.Shares = append(sp.Shares, biz.SplitShare{TelegID: ua.TelegID, Weight: m.Weight})
+	}
+	if err := biz.RecordSplit(sp, ctx.DBAdp); err != nil {
+		return upon_err(err)
+	}
+	ctx.Snapshot(nil, sp)
+	lines := []string{}
+	for _, s := range sp.Shares {
+		lines = append(lines, fmt.Sprintf("@%s owes %.2f", names[s.TelegID], s.INR))
+	}
+	return resp.NewTextResponse(fmt.Sprintf("%c %s %.2f paid by %s%%0A%s%%0ASplit ID: %s", biz.EMOJI_greentick, sp.Desc, sp.INR, payer.Name, strings.Join(lines, "%0A"), sp.Id.Hex()), sbc.ChatId, sbc.MsgId)
+}
+
+func (sbc *SplitBotCmd) CollName() string {
+	return "splits"
+}
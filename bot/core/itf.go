@@ -4,6 +4,8 @@ const (
 	ERR_FETCHBOTUPDT = "I was unable to get updates from telegram server"
 	ERR_READUPDT     = "Unable to read the update message on the telegram server"
 	SECRET_FILE      = "/run/secrets/token_secret"
+	ATTACH_PHOTO     = "photo"    // kind of attachment on the message
+	ATTACH_DOC       = "document" // kind of attachment on the message
 )
 
 type ConfigEnv interface {
@@ -18,6 +20,7 @@ type Bot interface {
 type BotUrl interface {
 	BotBaseUrl() string
 	SendPollUrl(anon, qs, opts string) string
+	AnswerCallbackUrl(cbqid string) string
 }
 
 type BotResponse interface {
@@ -26,6 +29,12 @@ type BotResponse interface {
 	Log()
 }
 
+// BotUpload : responses that carry a file to be uploaded along with the message
+// such responses are posted as multipart form instead of just the url
+type BotUpload interface {
+	Upload() (field, filename string, data []byte)
+}
+
 // BotUpdtFilter : takes in the bot update and then seeks to filter the update
 // Not all updates are meant for the bot, and such can help filtering updates
 type BotUpdtFilter interface {
@@ -3,6 +3,7 @@ package core
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 
 	"github.com/kneerunjun/botmincock/dbadp"
 	"github.com/sirupsen/logrus"
@@ -51,6 +52,11 @@ func (seb *SharedExpensesBot) SendPollUrl(anon, qs, opts string) string {
 	return fmt.Sprintf("%s/sendPoll?chat_id=%d&is_anonymous=%s&question=%s&options=%s", seb.BotBaseUrl(), seb.Env.GrpID, anon, qs, opts)
 }
 
+// AnswerCallbackUrl : every callback query has to be answered, else the button keeps spinning on the client
+func (seb *SharedExpensesBot) AnswerCallbackUrl(cbqid string) string {
+	return fmt.Sprintf("%s/answerCallbackQuery?callback_query_id=%s", seb.BotBaseUrl(), url.QueryEscape(cbqid))
+}
+
 type BotUpdate struct {
 	Id      int64 `json:"update_id"`
 	Message struct {
@@ -65,6 +71,21 @@ type BotUpdate struct {
 		Chat struct {
 			Id int64 `json:"id"`
 		} `json:"chat"`
+		// messages with photo / document attachments have the text as caption
+		Caption string `json:"caption"`
+		Photo   []struct {
+			FileId   string `json:"file_id"`
+			FileUId  string `json:"file_unique_id"`
+			Width    int    `json:"width"`
+			Height   int    `json:"height"`
+			FileSize int64  `json:"file_size"`
+		} `json:"photo"` // same photo in different sizes, largest is the last one
+		Document struct {
+			FileId   string `json:"file_id"`
+			FileUId  string `json:"file_unique_id"`
+			FileName string `json:"file_name"`
+			MimeType string `json:"mime_type"`
+		} `json:"document"`
 	} `json:"message"`
 	Poll struct {
 		Id       string `json:"id"`
@@ -81,6 +102,41 @@ type BotUpdate struct {
 		} `json:"user"`
 		Options []int `json:"option_ids"` //answers that the user may have chosen
 	} `json:"poll_answer"`
+	// when someone taps an inline keyboard button on the message sent by the bot
+	CallbackQuery struct {
+		Id   string `json:"id"`
+		From struct {
+			Id int64 `json:"id"`
+		} `json:"from"`
+		Message struct {
+			Id   int64 `json:"message_id"`
+			Chat struct {
+				Id int64 `json:"id"`
+			} `json:"chat"`
+		} `json:"message"`
+		Data string `json:"data"` // callback data of the button
+	} `json:"callback_query"`
+}
+
+// MsgText : text of the message, or the caption when the message is a photo / document
+// commands can be sent as caption to attachments
+func (bu *BotUpdate) MsgText() string {
+	if bu.Message.Text != "" {
+		return bu.Message.Text
+	}
+	return bu.Message.Caption
+}
+
+// Attachment : file id of the photo / document attached to the message, along with the kind of attachment
+// for photos the largest size is picked, empty file id when there isnt any attachment
+func (bu *BotUpdate) Attachment() (string, string) {
+	if n := len(bu.Message.Photo); n > 0 {
+		return bu.Message.Photo[n-1].FileId, ATTACH_PHOTO
+	}
+	if bu.Message.Document.FileId != "" {
+		return bu.Message.Document.FileId, ATTACH_DOC
+	}
+	return "", ""
 }
 
 /*
@@ -89,13 +145,23 @@ Flywheel object that gets injected in the command
 ====================
 */
 type CmdExecCtx struct {
-	DBAdp dbadp.DbAdaptor //DBAdaptor is to be pushed to biz functions for calling out domain functions
+	DBAdp  dbadp.DbAdaptor //DBAdaptor is to be pushed to biz functions for calling out domain functions
+	Before interface{}     // commands that mutate state can snapshot the state before the mutation for the audit
+	After  interface{}     // state after the mutation, for the audit
 }
 
 func (cec *CmdExecCtx) SetDB(db dbadp.DbAdaptor) *CmdExecCtx {
 	cec.DBAdp = db
 	return cec
 }
+
+// Snapshot : commands that mutate state call this to leave the before & after state for the audit trail
+// either of them can be nil, when creating or removing
+func (cec *CmdExecCtx) Snapshot(before, after interface{}) *CmdExecCtx {
+	cec.Before = before
+	cec.After = after
+	return cec
+}
 func NewExecCtx() *CmdExecCtx {
 	return &CmdExecCtx{}
 }
@@ -120,6 +186,10 @@ func (abc *AnyBotCmd) Log() {
 }
 
 func (abc *AnyBotCmd) AsMap() map[string]interface{} {
+	if abc == nil {
+		// commands not sent as messages (poll answers, cron jobs) may not have the message references
+		return map[string]interface{}{}
+	}
 	return map[string]interface{}{
 		"msg_id":  abc.MsgId,
 		"chat_id": abc.ChatId,
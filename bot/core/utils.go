@@ -187,7 +187,7 @@ func WatchUpdates(cancel chan bool, bot Bot, freq time.Duration, flts ...BotUpdt
 						// filter may pass thru but if the channel is nil it would mean the program will hang writing to nil channel
 						// This may seem redundant but is necessary
 						log.WithFields(log.Fields{
-							"text": updt.Message.Text,
+							"text": updt.MsgText(),
 						}).Debug("passed filter")
 						f.PassThruChn() <- updt
 						if abort {
@@ -0,0 +1,85 @@
+package resp
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FileBotResp : resends a file already on telegram servers, referred by its file id
+// Kind decides if its sent as photo or a document
+type FileBotResp struct {
+	*AnyResponse
+	Kind   string // photo / document
+	FileId string // telegram file id
+}
+
+func (fbr *FileBotResp) Log() {
+	log.WithFields(log.Fields{
+		"kind": fbr.Kind,
+		"file": fbr.FileId,
+	}).Info("file response..")
+}
+
+func (fbr *FileBotResp) SendMsgUrl() string {
+	method, param := "/sendDocument", "document"
+	if fbr.Kind == "photo" {
+		method, param = "/sendPhoto", "photo"
+	}
+	url := fmt.Sprintf("%s?chat_id=%d&%s=%s&caption=%s", method, fbr.ChatId, param, fbr.FileId, fbr.UsrMessage)
+	if fbr.ReplyToMsg > 0 {
+		url = fmt.Sprintf("%s&reply_to_message_id=%d", url, fbr.ReplyToMsg)
+	}
+	return url
+}
+
+func NewFileResponse(kind, fileid, caption string, chatid, msgid int64) *FileBotResp {
+	return &FileBotResp{
+		AnyResponse: &AnyResponse{
+			ChatId:     chatid,
+			ReplyToMsg: msgid,
+			UsrMessage: caption,
+		},
+		Kind:   kind,
+		FileId: fileid,
+	}
+}
+
+// UploadBotResp : sends a file rendered locally, unlike FileBotResp the file isnt on telegram servers yet
+// the bytes are uploaded as multipart form, only photos for now
+type UploadBotResp struct {
+	*AnyResponse
+	Name string // file name as uploaded
+	Data []byte
+}
+
+func (ubr *UploadBotResp) Log() {
+	log.WithFields(log.Fields{
+		"name": ubr.Name,
+		"size": len(ubr.Data),
+	}).Info("upload response..")
+}
+
+func (ubr *UploadBotResp) SendMsgUrl() string {
+	url := fmt.Sprintf("/sendPhoto?chat_id=%d&caption=%s", ubr.ChatId, ubr.UsrMessage)
+	if ubr.ReplyToMsg > 0 {
+		url = fmt.Sprintf("%s&reply_to_message_id=%d", url, ubr.ReplyToMsg)
+	}
+	return url
+}
+
+func (ubr *UploadBotResp) Upload() (string, string, []byte) {
+	return "photo", ubr.Name, ubr.Data
+}
+
+func NewUploadResponse(name string, data []byte, caption string, chatid, msgid int64) *UploadBotResp {
+	return &UploadBotResp{
+		AnyResponse: &AnyResponse{
+			ChatId:     chatid,
+			ReplyToMsg: msgid,
+			UsrMessage: caption,
+		},
+		Name: name,
+		Data: data,
+	}
+}
@@ -0,0 +1,47 @@
+package resp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// InlineBtn : button on the inline keyboard, tapping it sends back the Data as callback query
+type InlineBtn struct {
+	Text string `json:"text"`
+	Data string `json:"callback_data"`
+}
+
+// KeybrdBotResp : text response along with an inline keyboard under the message
+type KeybrdBotResp struct {
+	*AnyResponse
+	Buttons [][]InlineBtn // rows of buttons
+}
+
+func (kbr *KeybrdBotResp) Log() {
+	log.Info("keyboard response..")
+}
+
+func (kbr *KeybrdBotResp) SendMsgUrl() string {
+	markup, err := json.Marshal(map[string]interface{}{"inline_keyboard": kbr.Buttons})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to marshal inline keyboard, sending message without it")
+		return kbr.AnyResponse.SendMsgUrl()
+	}
+	return fmt.Sprintf("%s&reply_markup=%s", kbr.AnyResponse.SendMsgUrl(), url.QueryEscape(string(markup)))
+}
+
+func NewKeybrdResponse(txt string, btns [][]InlineBtn, chatid, msgid int64) *KeybrdBotResp {
+	return &KeybrdBotResp{
+		AnyResponse: &AnyResponse{
+			ChatId:     chatid,
+			ReplyToMsg: msgid,
+			UsrMessage: txt,
+		},
+		Buttons: btns,
+	}
+}
@@ -78,7 +78,7 @@ func (btcll *BotCalloutFilter) Apply(updt *core.BotUpdate) (bool, bool) {
 		log.Warn("Invalid bot handle to search for. What is the bot handle?")
 		return false, (false)
 	}
-	yes := strings.Contains(updt.Message.Text, callout)
+	yes := strings.Contains(updt.MsgText(), callout)
 	return yes, (yes && btcll.PassChn != nil)
 }
 
@@ -94,7 +94,7 @@ func (btcmd *BotCommandFilter) PassThruChn() chan core.BotUpdate {
 func (btcmd *BotCommandFilter) Apply(updt *core.BotUpdate) (bool, bool) {
 	yes := false
 	for _, expr := range btcmd.CommandExprs {
-		if expr.MatchString(updt.Message.Text) {
+		if expr.MatchString(updt.MsgText()) {
 			yes = true
 			break
 		}
@@ -114,7 +114,7 @@ func (txtcmd *TextMsgCmdFilter) PassThruChn() chan core.BotUpdate {
 func (txtcmd *TextMsgCmdFilter) Apply(updt *core.BotUpdate) (bool, bool) {
 	yes := false
 	for _, expr := range txtcmd.CommandExprs {
-		if expr.MatchString(updt.Message.Text) {
+		if expr.MatchString(updt.MsgText()) {
 			yes = true
 			break
 		}
@@ -124,6 +124,21 @@ func (txtcmd *TextMsgCmdFilter) Apply(updt *core.BotUpdate) (bool, bool) {
 
 // =======================
 
+// CallbackQryFilter : when someone taps on the inline keyboard buttons
+// callback queries arent messages hence are filtered before any of the message filters
+type CallbackQryFilter struct {
+	PassChn chan core.BotUpdate // pass thru channel
+}
+
+func (cbqf *CallbackQryFilter) PassThruChn() chan core.BotUpdate {
+	return cbqf.PassChn
+}
+
+func (cbqf *CallbackQryFilter) Apply(updt *core.BotUpdate) (bool, bool) {
+	yes := updt.CallbackQuery.Id != ""
+	return yes, (yes && cbqf.PassChn != nil)
+}
+
 // This is when you have update on the poll being sent
 type PollAnsCmdFilter struct {
 	PassChn chan core.BotUpdate // pass thru channel
@@ -44,6 +44,10 @@ func (da *DummyAdaptor) Aggregate(p []bson.M, res interface{}) error {
 	return nil
 }
 
+func (da *DummyAdaptor) AggregateAll(p []bson.M, res interface{}) error {
+	return nil
+}
+
 func (da *DummyAdaptor) Switch(string) DbAdaptor {
 	return nil
 }
@@ -22,5 +22,6 @@ type DbAdaptor interface {
 	GetOne(interface{}, reflect.Type) (interface{}, error)
 	GetCount(interface{}, *int) error
 	Aggregate(p []bson.M, res interface{}) error
-	Switch(string) DbAdaptor // switches the collection and sends out a new adaptor with new underlying collection
+	AggregateAll(p []bson.M, res interface{}) error // same as Aggregate but gets all the results of the pipe onto a slice
+	Switch(string) DbAdaptor                        // switches the collection and sends out a new adaptor with new underlying collection
 }
@@ -61,6 +61,12 @@ func (ma *mongoAdaptor) Aggregate(p []bson.M, res interface{}) error {
 	return ma.Pipe(p).One(res)
 }
 
+// AggregateAll : runs the pipe and gets all the results, res has to be a pointer to slice
+// NOTE: unlike Aggregate, no results is not an error here, the slice is just empty
+func (ma *mongoAdaptor) AggregateAll(p []bson.M, res interface{}) error {
+	return ma.Pipe(p).All(res)
+}
+
 func (ma *mongoAdaptor) Switch(name string) DbAdaptor {
 	mngcoll := ma.Database.Session.DB("").C(name)
 	return &mongoAdaptor{Collection: mngcoll}
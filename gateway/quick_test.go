@@ -0,0 +1,69 @@
+package gateway
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	TEST_SECRET  = "whsec_botmincock"
+	TEST_CAPTURE = `{"event":"payment.captured","payload":{"payment":{"entity":{"id":"pay_MZ1sVxLhJ5XaEW","amount":123450,"currency":"INR","status":"captured","description":"Dues 5157350442","notes":{"tid":"5157350442"}}}}}`
+)
+
+// stubServer : stands in for the webhook endpoint, responds with the status as ReadWebhook decides
+func stubServer(got **Payment) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := ReadWebhook(r, TEST_SECRET)
+		if errors.Is(err, ERR_SIGNATURE) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		*got = p
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func postWebhook(t *testing.T, url, body, signature string) int {
+	req, _ := http.NewRequest("POST", url, bytes.NewBufferString(body))
+	req.Header.Set(SIGNATURE_HEADER, signature)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err, "Unexpected error posting to stub server")
+	return resp.StatusCode
+}
+
+func TestReadWebhook(t *testing.T) {
+	var got *Payment
+	srvr := stubServer(&got)
+	defer srvr.Close()
+	// TEST: signed payload is read along with the payment details
+	assert.Equal(t, http.StatusOK, postWebhook(t, srvr.URL, TEST_CAPTURE, Sign([]byte(TEST_CAPTURE), TEST_SECRET)), "Unexpected status for signed payload")
+	assert.NotNil(t, got, "Unexpected nil payment for signed payload")
+	assert.Equal(t, "pay_MZ1sVxLhJ5XaEW", got.Id, "Unexpected payment id")
+	assert.Equal(t, float32(1234.50), got.INR, "Unexpected amount, expected paise converted to INR")
+	assert.Equal(t, "5157350442", got.Notes["tid"], "Unexpected notes on the payment")
+	assert.True(t, got.Captured(), "Unexpected payment not captured")
+	// TEST: tampered payload, wrong secret and missing signature are all rejected
+	tampered := bytes.Replace([]byte(TEST_CAPTURE), []byte("123450"), []byte("999999"), 1)
+	assert.Equal(t, http.StatusUnauthorized, postWebhook(t, srvr.URL, string(tampered), Sign([]byte(TEST_CAPTURE), TEST_SECRET)), "Unexpected status for tampered payload")
+	assert.Equal(t, http.StatusUnauthorized, postWebhook(t, srvr.URL, TEST_CAPTURE, Sign([]byte(TEST_CAPTURE), "wrongsecret")), "Unexpected status for wrong secret")
+	assert.Equal(t, http.StatusUnauthorized, postWebhook(t, srvr.URL, TEST_CAPTURE, ""), "Unexpected status for missing signature")
+	// TEST: signed but unreadable payloads
+	for _, body := range []string{`{"event":"payment.captured"}`, `not json`, `{"event":"payment.captured","payload":{"payment":{"entity":{"id":"pay_1","amount":100,"currency":"USD"}}}}`} {
+		assert.Equal(t, http.StatusBadRequest, postWebhook(t, srvr.URL, body, Sign([]byte(body), TEST_SECRET)), "Unexpected status for unreadable payload")
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(TEST_CAPTURE)
+	assert.True(t, VerifySignature(body, Sign(body, TEST_SECRET), TEST_SECRET), "Unexpected fail verifying signature")
+	// TEST: empty secret never verifies, else an unconfigured server would accept anything
+	assert.False(t, VerifySignature(body, Sign(body, ""), ""), "Unexpected pass with empty secret")
+}
@@ -0,0 +1,111 @@
+package gateway
+
+/* ==================================
+project		: botmincock
+Payment gateway webhooks, Razorpay style
+Gateway posts a signed json payload when a payment is captured, signature is the HMAC-SHA256 of the raw body with the webhook secret
+====================================*/
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	SIGNATURE_HEADER = "X-Razorpay-Signature"
+	EVENT_CAPTURED   = "payment.captured" // only captured payments are money received
+)
+
+var (
+	ERR_SIGNATURE = fmt.Errorf("webhook signature mismatch")
+	ERR_PAYLOAD   = fmt.Errorf("webhook payload unreadable")
+)
+
+// Payment : payment as notified by the gateway, amount is converted to INR from paise
+type Payment struct {
+	Id     string            // payment id on the gateway, unique for each payment
+	Event  string            // payment.captured, payment.failed ..
+	INR    float32           // amount paid
+	Desc   string            // description / transaction note as the payer sent it
+	Notes  map[string]string // key values attached to the payment
+	Status string
+}
+
+// Captured : true when the payment is received
+func (p *Payment) Captured() bool {
+	return p.Event == EVENT_CAPTURED
+}
+
+// webhookPayload : only the fields of interest from the gateway payload
+type webhookPayload struct {
+	Event   string `json:"event"`
+	Payload struct {
+		Payment struct {
+			Entity struct {
+				Id          string            `json:"id"`
+				Amount      int64             `json:"amount"` // in paise
+				Currency    string            `json:"currency"`
+				Status      string            `json:"status"`
+				Description string            `json:"description"`
+				Notes       map[string]string `json:"notes"`
+			} `json:"entity"`
+		} `json:"payment"`
+	} `json:"payload"`
+}
+
+// Sign : signature for the body as the gateway would compute it
+func Sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature : checks the signature sent by the gateway against the raw body
+// comparison is constant time, empty secret never verifies
+func VerifySignature(body []byte, signature, secret string) bool {
+	if secret == "" || signature == "" {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(body, secret)), []byte(signature))
+}
+
+// ParseWebhook : reads the payment from the raw webhook body
+func ParseWebhook(body []byte) (*Payment, error) {
+	wp := webhookPayload{}
+	if err := json.Unmarshal(body, &wp); err != nil {
+		return nil, fmt.Errorf("%w: %s", ERR_PAYLOAD, err)
+	}
+	entity := wp.Payload.Payment.Entity
+	if wp.Event == "" || entity.Id == "" {
+		return nil, fmt.Errorf("%w: missing event or payment id", ERR_PAYLOAD)
+	}
+	if entity.Currency != "" && entity.Currency != "INR" {
+		return nil, fmt.Errorf("%w: unsupported currency %s", ERR_PAYLOAD, entity.Currency)
+	}
+	return &Payment{
+		Id:     entity.Id,
+		Event:  wp.Event,
+		INR:    float32(entity.Amount) / 100.0,
+		Desc:   entity.Description,
+		Notes:  entity.Notes,
+		Status: entity.Status,
+	}, nil
+}
+
+// ReadWebhook : reads the request body, verifies the signature and then parses the payment
+// Errors with ERR_SIGNATURE when the request isnt from the gateway, ERR_PAYLOAD when the body cannot be read
+func ReadWebhook(r *http.Request, secret string) (*Payment, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ERR_PAYLOAD, err)
+	}
+	defer r.Body.Close()
+	if !VerifySignature(body, r.Header.Get(SIGNATURE_HEADER), secret) {
+		return nil, ERR_SIGNATURE
+	}
+	return ParseWebhook(body)
+}
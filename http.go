@@ -1,16 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"mime/multipart"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/kneerunjun/botmincock/bot/cmd"
+	"github.com/kneerunjun/botmincock/biz"
 	"github.com/kneerunjun/botmincock/bot/core"
 	"github.com/kneerunjun/botmincock/dbadp"
+	"github.com/kneerunjun/botmincock/gateway"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -21,24 +29,25 @@ func HandlrBotInContext(b core.Bot) gin.HandlerFunc {
 	}
 }
 
+// HndlrRequireToken : lets the request through only when it carries the token as Authorization: Bearer <token>
+// token is read from the environment variable, when not set every request is forbidden
+func HndlrRequireToken(envVar string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := os.Getenv(envVar)
+		sent := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(sent)) != 1 {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+	}
+}
+
 // HndlrPlaydayEstimates : this handles getting http command to send the poll for getting the estimates
-// This will trigger sending the poll to the group once every month as per scheduled cron job
+// The scheduler sends the poll to the group once every month, this is to send it on demand
 // Does not require the command infra  .. can send
 func HndlrPlaydayEstimates(c *gin.Context) {
 	val, _ := c.Get("bot")
-	bot := val.(core.Bot)
-
-	qs := fmt.Sprintf("Availability for %s %%3F", time.Now().AddDate(0, 1, 0).Month().String()) // the question of the poll
-	anon := "False"
-	options := []string{
-		"All days",
-		"15 days",
-		"Only on weekends",
-		"Out for the month",
-	}
-	jOptions, _ := json.Marshal(options)
-	url := bot.(core.BotUrl).SendPollUrl(anon, qs, string(jOptions))
-	if err := SendBotHttp(url); err != nil {
+	if err := jobEstimatesPoll(val.(core.Bot))(time.Now()); err != nil {
 		c.AbortWithStatus(http.StatusBadGateway)
 		return
 	}
@@ -47,21 +56,104 @@ func HndlrPlaydayEstimates(c *gin.Context) {
 func HandlrDebitAdjustments(c *gin.Context) {
 	log.Debug("Received request to adjust daily debits")
 	// We send in a bot text response whenever the debits are adjusted
-	command := cmd.AdjustPlayDebitBotCmd{AnyBotCmd: &core.AnyBotCmd{ChatId: -902469479}}
-	ctx := core.NewExecCtx().SetDB(dbadp.NewMongoAdpator(MONGO_ADDRS, DB_NAME, "transacs"))
-	resp := command.Execute(ctx)
-	if resp != nil {
-		// TODO: here we need the bot url to send the message
-		// But unless we have the bot instance getting the url isnt really possible
-		// HACK: we are just hardcoding the boturl here for the time being
-		if err := SendBotHttp(fmt.Sprintf("%s%s", "https://api.telegram.org/bot6133190482:AAFdMU-49W7t9zDoD5BIkOFmtc-PR7-nBLk", resp.SendMsgUrl())); err != nil {
-			c.AbortWithStatus(http.StatusBadGateway)
+	val, _ := c.Get("bot")
+	if err := jobAdjustDebits(val.(core.Bot))(time.Now()); err != nil {
+		c.AbortWithStatus(http.StatusBadGateway)
+		return
+	}
+	c.AbortWithStatus(http.StatusOK)
+}
+
+// HndlrPostRecurring : posts the recurring expenses due as of today, the scheduler does this daily
+// posted expenses are announced on the group
+func HndlrPostRecurring(c *gin.Context) {
+	val, _ := c.Get("bot")
+	if err := jobPostRecurring(val.(core.Bot))(time.Now()); err != nil {
+		c.AbortWithStatus(http.StatusBadGateway)
+		return
+	}
+	c.AbortWithStatus(http.StatusOK)
+}
+
+// HndlrRemindPayments : reminds the managers on the group of the payments waiting too long for confirmation
+// the scheduler does this daily, PAYMENT_REMIND_HRS on the environment decides how long is too long
+func HndlrRemindPayments(c *gin.Context) {
+	val, _ := c.Get("bot")
+	if err := jobRemindPayments(val.(core.Bot))(time.Now()); err != nil {
+		c.AbortWithStatus(http.StatusBadGateway)
+		return
+	}
+	c.AbortWithStatus(http.StatusOK)
+}
+
+// HndlrPaymentWebhook : payment gateway notifies the payments received here, dues are cleared for the account in the payment note
+// GATEWAY_SECRET on the environment is the webhook secret, requests not signed with it are unauthorized
+// payments that cannot be credited right away are recorded pending, managers confirm them with /confirmpay
+func HndlrPaymentWebhook(c *gin.Context) {
+	payment, err := gateway.ReadWebhook(c.Request, os.Getenv("GATEWAY_SECRET"))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("failed to read payment webhook")
+		if errors.Is(err, gateway.ERR_SIGNATURE) {
+			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if !payment.Captured() {
+		// failed / authorized payments arent money received yet
 		c.AbortWithStatus(http.StatusOK)
 		return
 	}
-	c.AbortWithStatus(http.StatusNotFound)
+	// payment that cannot be mapped to a member is still recorded, pending for the managers to confirm against the member
+	tid, _ := biz.TelegIDFromNote(payment.Desc)
+	if val, err := strconv.ParseInt(payment.Notes["tid"], 10, 64); err == nil {
+		tid = val
+	}
+	pp := &biz.PendingPayment{TelegID: tid, INR: payment.INR, DtTm: time.Now(), GwId: payment.Id}
+	if err := biz.GatewayPayment(pp, dbadp.NewMongoAdpator(MONGO_ADDRS, DB_NAME, "payments")); err != nil {
+		de, _ := err.(*biz.DomainError)
+		de.LogE()
+		if errors.Is(de.Err, biz.ERR_QRYFAIL) || errors.Is(de.Err, biz.ERR_DBCONN) {
+			// gateway retries the webhook when not acknowledged
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.AbortWithStatus(http.StatusOK)
+		return
+	}
+	c.AbortWithStatus(http.StatusOK)
+}
+
+// HndlrAuditTrail : gets the latest entries from the audit trail
+// ?tid= to filter for an account, ?n= for the number of entries
+// NOTE: the port is published for the payment gateway, hence the audit needs the AUDIT_TOKEN
+func HndlrAuditTrail(c *gin.Context) {
+	aq := &biz.AuditQ{}
+	if tid := c.Query("tid"); tid != "" {
+		val, err := strconv.ParseInt(tid, 10, 64)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		aq.TelegID = val
+	}
+	if n := c.Query("n"); n != "" {
+		val, err := strconv.Atoi(n)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		aq.Limit = val
+	}
+	if err := biz.AuditTrail(aq, dbadp.NewMongoAdpator(MONGO_ADDRS, DB_NAME, "audit")); err != nil {
+		err.(*biz.DomainError).LogE()
+		c.AbortWithStatus(http.StatusBadGateway)
+		return
+	}
+	c.JSON(http.StatusOK, aq.Logs)
 }
 
 type HttpListenServlet struct {
@@ -73,6 +165,10 @@ func (hls *HttpListenServlet) Run(*RunConfig) error {
 	r := gin.Default()
 	r.GET("debits/adjust", HandlrBotInContext(hls.Bot), HandlrDebitAdjustments)
 	r.GET("playdays/estimate", HandlrBotInContext(hls.Bot), HndlrPlaydayEstimates)
+	r.GET("expenses/recurring", HandlrBotInContext(hls.Bot), HndlrPostRecurring)
+	r.GET("payments/remind", HandlrBotInContext(hls.Bot), HndlrRemindPayments)
+	r.POST("payments/webhook", HndlrPaymentWebhook)
+	r.GET("audit", HndlrRequireToken("AUDIT_TOKEN"), HndlrAuditTrail)
 	hls.Srvr = &http.Server{
 		Addr:    ":3333",
 		Handler: r,
@@ -115,3 +211,67 @@ func SendBotHttp(url string) error {
 		return nil
 	}
 }
+
+// SendBotUpload : posts the file along with the message as multipart form to the telegram api server
+// url carries the rest of the message params just as in SendBotHttp
+func SendBotUpload(url string, upld core.BotUpload) error {
+	field, filename, data := upld.Upload()
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile(field, filename)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(data); err != nil {
+		return err
+	}
+	form.Close()
+	cl := http.Client{Timeout: STD_REQ_TIMEOUT}
+	resp, err := cl.Post(url, form.FormDataContentType(), body)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+			"url": url,
+		}).Error("error uploading file over http")
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.WithFields(log.Fields{
+			"status": resp.StatusCode,
+			"url":    url,
+		}).Error("unfavourable reponse from server")
+		return fmt.Errorf("unfavourable reponse from server")
+	}
+	return nil
+}
+
+// SendBotPoll : sends the poll to the telegram api server and reads back the id of the poll
+// answers to the poll refer to this id
+func SendBotPoll(url string) (string, error) {
+	cl := http.Client{Timeout: STD_REQ_TIMEOUT}
+	resp, err := cl.Post(url, "application/json", nil)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"err": err,
+		}).Error("error sending poll over http")
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.WithFields(log.Fields{
+			"status": resp.StatusCode,
+		}).Error("unfavourable reponse from server")
+		return "", fmt.Errorf("unfavourable reponse from server")
+	}
+	result := struct {
+		Result struct {
+			Poll struct {
+				Id string `json:"id"`
+			} `json:"poll"`
+		} `json:"result"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Result.Poll.Id == "" {
+		return "", fmt.Errorf("failed to read the poll id from response: %v", err)
+	}
+	return result.Result.Poll.Id, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+/* ==================================
+Chores the bot does on schedule, registered with the in-process scheduler
+Same chores can be triggered over http on the servlet, handy when testing
+====================================*/
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kneerunjun/botmincock/biz"
+	"github.com/kneerunjun/botmincock/bot/cmd"
+	"github.com/kneerunjun/botmincock/bot/core"
+	"github.com/kneerunjun/botmincock/bot/resp"
+	"github.com/kneerunjun/botmincock/dbadp"
+	"github.com/kneerunjun/botmincock/sched"
+	log "github.com/sirupsen/logrus"
+)
+
+// mongoJobStore : job states persisted on the jobs collection
+type mongoJobStore struct {
+	adp dbadp.DbAdaptor
+}
+
+func (mjs *mongoJobStore) State(name string) (*sched.JobState, error) {
+	jr := &biz.JobRun{Name: name}
+	if err := biz.JobRunOf(jr, mjs.adp); err != nil {
+		if de, ok := err.(*biz.DomainError); ok && errors.Is(de.Err, biz.ERR_JOB404) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &sched.JobState{Name: jr.Name, Spec: jr.Spec, LastRun: jr.LastRun, NextRun: jr.NextRun, LastErr: jr.LastErr, Due: jr.Due}, nil
+}
+
+func (mjs *mongoJobStore) Save(st *sched.JobState) error {
+	return biz.SaveJobRun(&biz.JobRun{Name: st.Name, Spec: st.Spec, LastRun: st.LastRun, NextRun: st.NextRun, LastErr: st.LastErr, Due: st.Due}, mjs.adp)
+}
+
+// execForGroup : executes the command for the group and sends the response over the bot
+// commands that have nothing to announce send back nil response
+// command that fails is an error for the scheduler to retry, the error isnt sent to the group since the retries would flood it
+// failures are logged and are on /jobs
+func execForGroup(bot core.Bot, command core.BotCommand, coll string) error {
+	res := cmd.ExecuteAudited(command, core.NewExecCtx().SetDB(dbadp.NewMongoAdpator(MONGO_ADDRS, DB_NAME, coll)))
+	if res == nil {
+		return nil
+	}
+	if errResp, ok := res.(*resp.ErrBotResp); ok {
+		errResp.Log()
+		return fmt.Errorf("%s: %s", errResp.Context, errResp.Err)
+	}
+	return SendBotHttp(fmt.Sprintf("%s%s", bot.(core.BotUrl).BotBaseUrl(), res.SendMsgUrl()))
+}
+
+func groupCmd() *core.AnyBotCmd {
+	grp, _ := strconv.ParseInt(os.Getenv("PSABADMIN_GRP"), 10, 64)
+	return &core.AnyBotCmd{ChatId: grp}
+}
+
+// jobAdjustDebits : daily play debits adjusted after the play is over
+// run caught up / retried later adjusts the day it was due for
+func jobAdjustDebits(bot core.Bot) func(time.Time) error {
+	return func(due time.Time) error {
+		return execForGroup(bot, &cmd.AdjustPlayDebitBotCmd{AnyBotCmd: groupCmd(), Day: due}, "transacs")
+	}
+}
+
+// jobEstimatesPoll : poll on the group for the availability next month
+// next to the month the run was due in, run caught up after the month has turned still polls for the month it was meant for
+func jobEstimatesPoll(bot core.Bot) func(time.Time) error {
+	return func(due time.Time) error {
+		thisMonth, _ := biz.MonthBoundaryOf(due)
+		poll := biz.EstimatePoll(thisMonth.AddDate(0, 1, 0))
+		jOptions, _ := json.Marshal(poll.OptionTexts())
+		pollid, err := SendBotPoll(bot.(core.BotUrl).SendPollUrl("False", url.QueryEscape(poll.Question), url.QueryEscape(string(jOptions))))
+		if err != nil {
+			return err
+		}
+		// answers are decoded from the poll as stored
+		poll.PollId, poll.DtTm = pollid, time.Now()
+		// poll is already out in the group, failing the job here would have the retry post it again
+		if err := biz.RecordPoll(poll, dbadp.NewMongoAdpator(MONGO_ADDRS, DB_NAME, "polls")); err != nil {
+			log.WithFields(log.Fields{
+				"err":     err,
+				"poll_id": pollid,
+			}).Error("estimates poll sent but could not be recorded, answers to it will not be decoded")
+		}
+		return nil
+	}
+}
+
+// jobPostRecurring : recurring expenses due today are posted
+// run caught up later posts the expenses as of the day it was due, month turned since is not missed
+func jobPostRecurring(bot core.Bot) func(time.Time) error {
+	return func(due time.Time) error {
+		return execForGroup(bot, &cmd.PostRecurringBotCmd{AnyBotCmd: groupCmd(), AsOf: due}, "recurexpenses")
+	}
+}
+
+// jobRemindPayments : managers are reminded of the payments pending confirmation for too long
+func jobRemindPayments(bot core.Bot) func(time.Time) error {
+	return func(time.Time) error {
+		return execForGroup(bot, &cmd.PendingPaymentsBotCmd{AnyBotCmd: groupCmd(), Remind: true}, "payments")
+	}
+}
+
+// jobEstimateReminder : members yet to answer the poll are reminded before the deadline
+func jobEstimateReminder(bot core.Bot) func(time.Time) error {
+	return func(time.Time) error {
+		return execForGroup(bot, &cmd.EstimateReminderBotCmd{AnyBotCmd: groupCmd()}, "estimates")
+	}
+}
+
+// jobEstimateDefaults : once the deadline is past, members who did not answer the poll get default estimates
+func jobEstimateDefaults(bot core.Bot) func(time.Time) error {
+	return func(time.Time) error {
+		return execForGroup(bot, &cmd.EstimateDefaultsBotCmd{AnyBotCmd: groupCmd()}, "estimates")
+	}
+}
+
+// NewJobScheduler : scheduler with all the chores registered
+// NOTE: schedules are in the local time of the container
+func NewJobScheduler(bot core.Bot) (*sched.Scheduler, error) {
+	s := sched.NewScheduler(&mongoJobStore{adp: dbadp.NewMongoAdpator(MONGO_ADDRS, DB_NAME, "jobs")})
+	jobs := []struct {
+		name    string
+		spec    string
+		run     func(time.Time) error
+		catchUp bool // day missed is a day never adjusted / posted, hence runs for each of the days missed
+	}{
+		{"debit-adjust", "30 11 * * *", jobAdjustDebits(bot), true},
+		{"send-poll", "0 11 26 * *", jobEstimatesPoll(bot), false},
+		{"post-recurring", "0 9 * * *", jobPostRecurring(bot), true},
+		{"remind-payments", "0 10 * * *", jobRemindPayments(bot), false},
+		{"remind-estimates", "0 19 * * *", jobEstimateReminder(bot), false},
+		{"default-estimates", "5 0 * * *", jobEstimateDefaults(bot), false},
+	}
+	for _, j := range jobs {
+		register := s.Register
+		if j.catchUp {
+			register = s.RegisterCatchUp
+		}
+		if err := register(j.name, j.spec, j.run); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
@@ -12,6 +12,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/kneerunjun/botmincock/biz"
 	"github.com/kneerunjun/botmincock/bot/cmd"
 	"github.com/kneerunjun/botmincock/bot/core"
 	"github.com/kneerunjun/botmincock/bot/resp"
@@ -44,11 +45,60 @@ var (
 			Check for personal expenses
 			Check for entire team expenses
 		*/
-		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>addexpense)(\s+)(?P<inr>[0-9]+)(\s+)(?P<desc>[^!@#\$%%\^&\*\(\\)\[\]\<\\>]*)$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>addexpense)(\s+)(?P<inr>[0-9]+)(\s+)(#(?P<cat>[a-zA-Z]+)(\s+))?(?P<desc>[^!@#\$%%\^&\*\(\\)\[\]\<\\>]*)$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>addexpensefor)(\s+)((?P<tid>[\d]+)|@(?P<uname>[a-zA-Z0-9_]{5,32}))(\s+)(?P<inr>[0-9]+)(\s+)(#(?P<cat>[a-zA-Z]+)(\s+))?(?P<desc>[^!@#\$%%\^&\*\(\\)\[\]\<\\>]*)$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>split)(\s+)(?P<inr>[0-9]+)(\s+)(?P<desc>[^!@#\$%%\^&\*\(\\)\[\]\<\\>]+?)(?P<members>((\s+)@[a-zA-Z0-9_]{5,32}(:[\d]{1,2})?)+)$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>editexpense)(\s+)(?P<expid>[0-9a-f]{24})(\s+)(?P<inr>[0-9]+)(\s+)(?P<desc>[^!@#\$%%\^&\*\(\\)\[\]\<\\>]*)$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>approve|reject)(\s+)(?P<expid>[0-9a-f]{24})$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>receipt)(\s+)(?P<expid>[0-9a-f]{24})$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>delexpense)(\s+)(?P<expid>[0-9a-f]{24})$`, os.Getenv("BOT_HANDLE"))),
 		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>paydues)(\s+)(?P<inr>[0-9]+)$`, os.Getenv("BOT_HANDLE"))),
-		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>mydues)$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>confirmpay|declinepay)(\s+)(?P<payid>[0-9a-f]{24})((\s+)(?P<tid>[\d]+))?$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>pendingpayments)$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>jobs)$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>mydues)((\s+)(?P<day>[\d]{4}-[\d]{2}-[\d]{2}))?((\s+)(?P<qr>qr))?$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>pay)(\s+)@(?P<uname>[a-zA-Z0-9_]{5,32})(\s+)(?P<inr>[0-9]+)$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>whoowes)$`, os.Getenv("BOT_HANDLE"))),
 		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>myexpenses)$`, os.Getenv("BOT_HANDLE"))),
 		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>allexpenses)$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>expensesby)(\s+)category((\s+)(?P<month>[\d]{4}-[\d]{2}))?$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>categories)$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>addcategory|delcategory)(\s+)#?(?P<cat>[a-zA-Z]+)$`, os.Getenv("BOT_HANDLE"))),
+		/*
+			Recurring expenses, posted by the scheduler when due
+		*/
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>addrecurring)(\s+)(?P<tid>[\d]+)(\s+)(?P<day>[\d]{1,2})(\s+)(?P<inr>[0-9]+)(\s+)(#(?P<cat>[a-zA-Z]+)(\s+))?(?P<desc>[^!@#\$%%\^&\*\(\\)\[\]\<\\>]*)$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>recurring)$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>pauserecurring|resumerecurring|delrecurring)(\s+)(?P<recurid>[0-9a-f]{24})$`, os.Getenv("BOT_HANDLE"))),
+		/*
+			Monthly budget that drives the daily debits till actual expenses exceed it
+		*/
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>setbudget)(\s+)(?P<inr>[0-9]+)((\s+)(?P<month>[\d]{4}-[\d]{2}))?$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>budget)((\s+)(?P<month>[\d]{4}-[\d]{2}))?$`, os.Getenv("BOT_HANDLE"))),
+		/*
+			Attendance backfilled by managers for the gm missed, and undone for the gm sent by mistake
+		*/
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>markattend)(\s+)(?P<tid>[\d]+)(\s+)(?P<day>[\d]{4}-[\d]{2}-[\d]{2})$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>ungm)((\s+)(?P<tid>[\d]+)(\s+)(?P<day>[\d]{4}-[\d]{2}-[\d]{2}))?$`, os.Getenv("BOT_HANDLE"))),
+		/*
+			Cost sharing mode of the group, by estimates or by attendance only
+		*/
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>costmode)((\s+)(?P<mode>estimates|attendance))?$`, os.Getenv("BOT_HANDLE"))),
+		/*
+			Estimates outside the poll
+		*/
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>myestimate)(\s+)(?P<days>[\d]{1,2})$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>setestimate)(\s+)(?P<tid>[\d]+)(\s+)(?P<days>[\d]{1,2})((\s+)(?P<month>[\d]{4}-[\d]{2}))?$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>estimates)((\s+)(?P<month>[\d]{4}-[\d]{2}))?$`, os.Getenv("BOT_HANDLE"))),
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>myshare)((\s+)(?P<month>[\d]{4}-[\d]{2}))?$`, os.Getenv("BOT_HANDLE"))),
+		/*
+			Closing / re-opening the books for a month
+		*/
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>lockperiod|unlockperiod)(\s+)(?P<month>[\d]{4}-[\d]{2})$`, os.Getenv("BOT_HANDLE"))),
+		/*
+			Audit trail of the commands executed, latest n entries for an account or for everyone
+		*/
+		regexp.MustCompile(fmt.Sprintf(`^%s(\s+)\/(?P<cmd>audit)((\s+)((?P<tid>[\d]{3,})|@(?P<uname>[a-zA-Z0-9_]{5,32})))?((\s+)(?P<n>[\d]{1,2}))?$`, os.Getenv("BOT_HANDLE"))),
 		/*
 			Help listing of all the commands
 			calling out the bot and the sending the /help command shall send a list of commands
@@ -135,6 +185,9 @@ func main() {
 	}
 	mongoSession.SetMode(mgo.Monotonic, true)
 	log.Info("Now connected to the database..")
+	if err := mongoSession.DB(DB_NAME).C("payments").EnsureIndex(biz.PAYMENT_GWID_INDEX); err != nil {
+		log.Fatalf("failed to index gateway payments: %s\n", err)
+	}
 
 	if FSeed {
 		log.Warn("Flushing data, and re-seeding it")
@@ -254,12 +307,15 @@ func main() {
 
 	pollAns := make(chan core.BotUpdate, MAX_COINC_UPDATES)
 	defer close(pollAns) // a channel for all poll answer updates
+	callbacks := make(chan core.BotUpdate, MAX_COINC_UPDATES)
+	defer close(callbacks) // a channel for all the inline keyboard button taps
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		filters := []core.BotUpdtFilter{
 			&updt.PollAnsCmdFilter{PassChn: pollAns}, // since the poll update isnt attached to any conversation
+			&updt.CallbackQryFilter{PassChn: callbacks},
 			&updt.GrpConvFilter{PassChn: nil},
 			&updt.NonZeroIDFilter{PassChn: nil},
 			&updt.BotCommandFilter{PassChn: botCommands, CommandExprs: allCommands},
@@ -282,7 +338,7 @@ func main() {
 			case updt := <-botCallouts:
 				// parsing the updates
 				log.WithFields(log.Fields{
-					"text": updt.Message.Text,
+					"text": updt.MsgText(),
 				}).Debug("Received a bot callout ..")
 				respChn <- resp.NewTextResponse("Did you mean to command me? This isn't valid command", updt.Message.Chat.Id, updt.Message.Id)
 			case updt := <-botCommands:
@@ -313,14 +369,32 @@ func main() {
 					}).Debug("someone just answered the poll")
 					command, err := cmd.ParsePollAnsCmd(updt)
 					if err != nil {
-						respChn <- resp.NewErrResponse(err, "ParsePollAnsCmd", "I was trying to make sense of your poll selection , something went wrong", updt.Message.Chat.Id, updt.Message.Id)
+						respChn <- resp.NewErrResponse(err, "ParsePollAnsCmd", "I was trying to make sense of your poll selection , something went wrong", updt.PollAnswer.User.Id, 0) // poll answers arent in any chat, voter is sent a private message
+					} else {
+						respChn <- ResponseFromCommand(command, updt)
+					}
+				}()
+			case updt := <-callbacks:
+				go func() {
+					log.WithFields(log.Fields{
+						"data": updt.CallbackQuery.Data,
+						"user": updt.CallbackQuery.From.Id,
+					}).Debug("someone just tapped an inline button")
+					// answering the query clears the loading spinner on the button, the outcome is sent as a message in the chat
+					go SendBotHttp(botmincock.(core.BotUrl).AnswerCallbackUrl(updt.CallbackQuery.Id))
+					command, err := cmd.ParseCallbackCmd(updt)
+					if err != nil {
+						respChn <- resp.NewErrResponse(err, "ParseCallbackCmd", "Did not quite understand what you tapped, can you try the command instead?", updt.CallbackQuery.Message.Chat.Id, updt.CallbackQuery.Message.Id)
 					} else {
 						respChn <- ResponseFromCommand(command, updt)
 					}
 				}()
 			case resp := <-respChn:
 				// NOTE: when the result from executing a command is nil, the bot need not send out any response
-				if resp != nil {
+				if upld, ok := resp.(core.BotUpload); ok {
+					// responses with files rendered locally are uploaded
+					go SendBotUpload(fmt.Sprintf("%s%s", botmincock.UrlBot(), resp.SendMsgUrl()), upld)
+				} else if resp != nil {
 					go SendBotHttp(fmt.Sprintf("%s%s", botmincock.UrlBot(), resp.SendMsgUrl()))
 				}
 
@@ -329,8 +403,17 @@ func main() {
 			}
 		}
 	}()
-	// Starting a small http server so that we can callup from cron jobs
-	// Daily cronjobs can call this server to get chores done
+	// Scheduler does the daily / monthly chores, missed runs are caught up when the bot starts
+	scheduler, err := NewJobScheduler(botmincock)
+	if err != nil {
+		log.Fatalf("failed to register scheduled jobs: %s", err)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scheduler.Start(cancel)
+	}()
+	// Starting a small http server so that chores can be triggered on demand
 	wg.Add(1)
 	go RunServlet(&HttpListenServlet{Bot: botmincock}, &RunConfig{WtGrp: &wg, Cancel: cancel})
 	wg.Wait()
@@ -341,6 +424,11 @@ func ResponseFromCommand(c core.BotCommand, updt core.BotUpdate) core.BotRespons
 	if !ok {
 		return resp.NewErrResponse(fmt.Errorf("failed to read collection name for the command"), "ResponseFromCommand", "Some internal error could not parse your command", updt.Message.Id, updt.Message.Id)
 	} else {
-		return c.Execute(core.NewExecCtx().SetDB(dbadp.NewMongoAdpator(MONGO_ADDRS, DB_NAME, cmdcoll.CollName())))
+		adp := dbadp.NewMongoAdpator(MONGO_ADDRS, DB_NAME, cmdcoll.CollName())
+		// usernames of the members are refreshed on any command, commands addressing @members depend on it
+		if err := biz.RefreshUName(&biz.UserAccount{TelegID: updt.Message.From.Id, UName: updt.Message.From.UName}, adp.Switch("accounts")); err != nil {
+			err.(*biz.DomainError).LogE()
+		}
+		return cmd.ExecuteAudited(c, core.NewExecCtx().SetDB(adp))
 	}
 }
@@ -0,0 +1,126 @@
+package sched
+
+/* ==================================
+project		: botmincock
+Cron expressions, the standard 5 fields : minute hour day-of-month month day-of-week
+Each field can be a star, a number, a range a-b, a list a,b,c and steps over any of those - every 15 minutes is star/15
+As with cron, when both day-of-month and day-of-week are restricted either of them matching is enough
+====================================*/
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ERR_CRONEXPR = fmt.Errorf("invalid cron expression")
+)
+
+// field bounds in the order of the expression
+var cronBounds = [5][2]int{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 6},  // day of week, sunday is 0
+}
+
+// Schedule : parsed cron expression, each field is the set of values it matches
+type Schedule struct {
+	Expr   string
+	fields [5]map[int]bool
+	anyDom bool // day of month is *
+	anyDow bool // day of week is *
+}
+
+// parseField : values of one field of the expression within the bounds
+func parseField(fld string, min, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(fld, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return nil, fmt.Errorf("%w: step in %s", ERR_CRONEXPR, part)
+			}
+			step, part = s, part[:i]
+		}
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("%w: %s", ERR_CRONEXPR, part)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("%w: %s", ERR_CRONEXPR, part)
+				}
+			} else if step > 1 {
+				to = max // 5/15 is 5 to max in steps of 15
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("%w: %s out of range %d-%d", ERR_CRONEXPR, part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// ParseCron : parses the 5 field cron expression
+func ParseCron(expr string) (*Schedule, error) {
+	flds := strings.Fields(expr)
+	if len(flds) != 5 {
+		return nil, fmt.Errorf("%w: expected 5 fields, got %d", ERR_CRONEXPR, len(flds))
+	}
+	s := &Schedule{Expr: expr, anyDom: flds[2] == "*", anyDow: flds[4] == "*"}
+	for i, f := range flds {
+		values, err := parseField(f, cronBounds[i][0], cronBounds[i][1])
+		if err != nil {
+			return nil, err
+		}
+		s.fields[i] = values
+	}
+	return s, nil
+}
+
+// matchDay : day of month and day of week together decide if the schedule runs on the day
+func (s *Schedule) matchDay(t time.Time) bool {
+	dom, dow := s.fields[2][t.Day()], s.fields[4][int(t.Weekday())]
+	if s.anyDom || s.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next : the first time after the given time that the schedule matches, to the minute
+// zero time when the schedule cannot match in the next 5 years, ex: 30th of February
+func (s *Schedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.fields[3][int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.fields[1][t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.fields[0][t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
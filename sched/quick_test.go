@@ -0,0 +1,152 @@
+package sched
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCron(t *testing.T) {
+	dataOk := []string{"* * * * *", "30 11 * * *", "0 11 26 * *", "*/15 9-17 * * 1-5", "0 0 1,15 * 0", "5/20 * * 1-12/3 *"}
+	for _, d := range dataOk {
+		_, err := ParseCron(d)
+		assert.Nil(t, err, "Unexpected error parsing %s", d)
+	}
+	dataNotOk := []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 7", "*/0 * * * *", "a * * * *", "10-5 * * * *"}
+	for _, d := range dataNotOk {
+		_, err := ParseCron(d)
+		assert.True(t, errors.Is(err, ERR_CRONEXPR), "Unexpected nil error parsing %s", d)
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	at := func(s string) time.Time {
+		t, _ := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		return t
+	}
+	data := []struct {
+		expr  string
+		after string
+		next  string
+	}{
+		{"30 11 * * *", "2023-06-23 10:00", "2023-06-23 11:30"},
+		{"30 11 * * *", "2023-06-23 11:30", "2023-06-24 11:30"}, // next is strictly after
+		{"0 11 26 * *", "2023-06-26 11:01", "2023-07-26 11:00"},
+		{"0 11 26 * *", "2023-12-27 00:00", "2024-01-26 11:00"}, // year rolls over
+		{"*/15 * * * *", "2023-06-23 10:16", "2023-06-23 10:30"},
+		{"0 9 * * 1-5", "2023-06-23 10:00", "2023-06-26 09:00"}, // friday to monday
+		{"0 0 29 2 *", "2023-03-01 00:00", "2024-02-29 00:00"},  // leap day
+		{"0 0 1 * 0", "2023-06-23 00:00", "2023-06-25 00:00"},   // either the 1st or a sunday
+	}
+	for _, d := range data {
+		sch, err := ParseCron(d.expr)
+		assert.Nil(t, err, "Unexpected error parsing %s", d.expr)
+		assert.Equal(t, at(d.next), sch.Next(at(d.after)), "Unexpected next run for %s after %s", d.expr, d.after)
+	}
+	sch, _ := ParseCron("0 0 30 2 *")
+	assert.True(t, sch.Next(time.Now()).IsZero(), "Unexpected next run for a day that never comes")
+}
+
+// mapStore : job states in memory for testing
+type mapStore map[string]JobState
+
+func (ms mapStore) State(name string) (*JobState, error) {
+	st, ok := ms[name]
+	if !ok {
+		return nil, nil
+	}
+	return &st, nil
+}
+
+func (ms mapStore) Save(st *JobState) error {
+	ms[st.Name] = *st
+	return nil
+}
+
+func TestRunDue(t *testing.T) {
+	store := mapStore{}
+	s := NewScheduler(store)
+	runs, fail, ranFor := 0, false, time.Time{}
+	assert.Nil(t, s.Register("adjust", "30 11 * * *", func(due time.Time) error {
+		runs, ranFor = runs+1, due
+		if fail {
+			return fmt.Errorf("telegram unreachable")
+		}
+		return nil
+	}), "Unexpected error registering job")
+	assert.NotNil(t, s.Register("adjust", "0 9 * * *", func(time.Time) error { return nil }), "Unexpected nil error registering the same job twice")
+	assert.NotNil(t, s.Register("bad", "30 11 * *", func(time.Time) error { return nil }), "Unexpected nil error registering job with bad cron")
+
+	day := time.Date(2023, 6, 23, 10, 0, 0, 0, time.Local)
+	// TEST: first seen job isnt run, only scheduled
+	s.RunDue(day)
+	assert.Equal(t, 0, runs, "Unexpected run of a job seen for the first time")
+	assert.Equal(t, day.Add(90*time.Minute), store["adjust"].NextRun, "Unexpected next run")
+	s.RunDue(day.Add(90 * time.Minute))
+	assert.Equal(t, 1, runs, "Unexpected runs when the job was due")
+	// TEST: app down for 3 days, job is caught up only once
+	s.RunDue(day.Add(72 * time.Hour))
+	assert.Equal(t, 2, runs, "Unexpected runs when catching up")
+	assert.Equal(t, day.AddDate(0, 0, 2).Add(90*time.Minute), ranFor, "Unexpected due time when catching up, expected the latest run missed")
+	s.RunDue(day.Add(72*time.Hour + time.Minute))
+	assert.Equal(t, 2, runs, "Unexpected runs after catching up")
+	// TEST: failed job is retried after a while, and not lost
+	fail = true
+	due := store["adjust"].NextRun
+	s.RunDue(due)
+	assert.Equal(t, 3, runs, "Unexpected runs when the job was due")
+	assert.Equal(t, "telegram unreachable", store["adjust"].LastErr, "Unexpected error recorded for the job")
+	s.RunDue(due.Add(time.Minute))
+	assert.Equal(t, 3, runs, "Unexpected retry before RETRY_AFTER")
+	fail = false
+	s.RunDue(due.Add(RETRY_AFTER))
+	assert.Equal(t, 4, runs, "Unexpected runs when retrying")
+	assert.Equal(t, due, ranFor, "Unexpected due time when retrying, expected the time of the failed run")
+	assert.Equal(t, "", store["adjust"].LastErr, "Unexpected error after the retry succeeded")
+	assert.Equal(t, due.Add(RETRY_AFTER), store["adjust"].LastRun, "Unexpected last run")
+}
+
+func TestRunDueCatchUp(t *testing.T) {
+	store := mapStore{}
+	s := NewScheduler(store)
+	ranFor, failOn := []time.Time{}, time.Time{}
+	assert.Nil(t, s.RegisterCatchUp("adjust", "30 11 * * *", func(due time.Time) error {
+		ranFor = append(ranFor, due)
+		if due.Equal(failOn) {
+			return fmt.Errorf("telegram unreachable")
+		}
+		return nil
+	}), "Unexpected error registering job")
+	assert.NotNil(t, s.RegisterCatchUp("adjust", "0 9 * * *", func(time.Time) error { return nil }), "Unexpected nil error registering the same job twice")
+
+	day := time.Date(2023, 6, 23, 10, 0, 0, 0, time.Local)
+	at := func(d int) time.Time { return day.AddDate(0, 0, d).Add(90 * time.Minute) }
+	s.RunDue(day)
+	s.RunDue(at(0))
+	assert.Equal(t, []time.Time{at(0)}, ranFor, "Unexpected runs when the job was due")
+	// TEST: app down missing two daily runs, job runs for each of them oldest first
+	s.RunDue(day.AddDate(0, 0, 3))
+	assert.Equal(t, []time.Time{at(0), at(1), at(2)}, ranFor, "Unexpected runs when catching up, expected each run missed")
+	assert.Equal(t, at(3), store["adjust"].NextRun, "Unexpected next run after catching up")
+	s.RunDue(day.AddDate(0, 0, 3).Add(time.Minute))
+	assert.Equal(t, 3, len(ranFor), "Unexpected runs after catching up")
+	// TEST: run failing midway the catch up is retried for its own due time, runs after it are not skipped
+	ranFor, failOn = []time.Time{}, at(4)
+	now := day.AddDate(0, 0, 6)
+	s.RunDue(now)
+	assert.Equal(t, []time.Time{at(3), at(4)}, ranFor, "Unexpected runs when catching up with a failure")
+	failOn = time.Time{}
+	s.RunDue(now.Add(RETRY_AFTER))
+	assert.Equal(t, []time.Time{at(3), at(4), at(4), at(5)}, ranFor, "Unexpected runs when retrying the catch up")
+	// TEST: runs missed beyond MAX_CATCHUP are caught up on the next tick
+	ranFor = []time.Time{}
+	now = day.AddDate(0, 0, 6+MAX_CATCHUP+5)
+	s.RunDue(now)
+	assert.Equal(t, MAX_CATCHUP, len(ranFor), "Unexpected runs beyond the catch up limit")
+	s.RunDue(now.Add(time.Minute))
+	assert.Equal(t, MAX_CATCHUP+5, len(ranFor), "Unexpected runs on the next tick")
+	assert.Equal(t, at(6+MAX_CATCHUP+4), ranFor[len(ranFor)-1], "Unexpected latest run caught up")
+}
@@ -0,0 +1,192 @@
+package sched
+
+/* ==================================
+project		: botmincock
+In-process scheduler, jobs are registered in code with their cron expressions
+Runs of the jobs are persisted on the store so that runs missed while the app was down are caught up on start
+====================================*/
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	TICK        = time.Minute     // cron resolution is a minute
+	RETRY_AFTER = 5 * time.Minute // failed jobs are retried after this, till they succeed
+	MAX_CATCHUP = 31              // runs of a catch up job in one go, runs missed beyond this are caught up on the next tick
+)
+
+// JobState : what the store remembers of the job
+type JobState struct {
+	Name    string
+	Spec    string    // cron expression the job was last scheduled with
+	LastRun time.Time // last successful run, zero when never run
+	NextRun time.Time // when the job is due next
+	LastErr string    // error from the last run, empty when it succeeded
+	Due     time.Time // run that failed is retried for the time it was due, zero when not retrying
+}
+
+// JobStore : persistence for the job states
+type JobStore interface {
+	State(name string) (*JobState, error) // nil state with no error when the job was never scheduled before
+	Save(st *JobState) error
+}
+
+// Job : named work on a cron schedule
+// Run gets the time the run was due, jobs bound to a date act on that date and not on when they actually run
+// CatchUp jobs run once for every run missed, oldest first, others run once for the latest run missed
+type Job struct {
+	Name     string
+	Schedule *Schedule
+	Run      func(due time.Time) error
+	CatchUp  bool
+}
+
+type Scheduler struct {
+	Store JobStore
+	jobs  []*Job
+}
+
+func NewScheduler(store JobStore) *Scheduler {
+	return &Scheduler{Store: store, jobs: []*Job{}}
+}
+
+// Register : adds the job to the scheduler, errors when the cron expression is invalid or the name is taken
+func (s *Scheduler) Register(name, spec string, run func(due time.Time) error) error {
+	return s.register(&Job{Name: name, Run: run}, spec)
+}
+
+// RegisterCatchUp : same as Register, but the job runs for each of the runs missed and not just the latest
+// for jobs that act on the date they were due, and a day missed is a day never acted on
+func (s *Scheduler) RegisterCatchUp(name, spec string, run func(due time.Time) error) error {
+	return s.register(&Job{Name: name, Run: run, CatchUp: true}, spec)
+}
+
+func (s *Scheduler) register(job *Job, spec string) error {
+	sch, err := ParseCron(spec)
+	if err != nil {
+		return err
+	}
+	for _, j := range s.jobs {
+		if j.Name == job.Name {
+			return fmt.Errorf("job %s already registered", job.Name)
+		}
+	}
+	job.Schedule = sch
+	s.jobs = append(s.jobs, job)
+	return nil
+}
+
+// runJob : runs the job, panics are reported as errors so that one job cannot bring down the scheduler
+func runJob(j *Job, due time.Time) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return j.Run(due)
+}
+
+// lastDue : latest time the job was due as of now, starting from the first run missed
+func lastDue(sch *Schedule, missed, now time.Time) time.Time {
+	due := missed
+	for next := sch.Next(due); !next.IsZero() && !next.After(now); next = sch.Next(due) {
+		due = next
+	}
+	return due
+}
+
+// RunDue : runs all the jobs that are due as of now
+// job seen for the first time is only scheduled, it runs on its next schedule
+// job that missed one or more runs runs only once to catch up, for the latest of the runs missed
+// catch up job runs for each of the runs missed instead, oldest first and at the most MAX_CATCHUP runs in one go
+// failed job is retried after RETRY_AFTER, for the same time it was due
+func (s *Scheduler) RunDue(now time.Time) {
+	for _, j := range s.jobs {
+		st, err := s.Store.State(j.Name)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"job": j.Name,
+				"err": err,
+			}).Error("failed to get job state, skipping")
+			continue
+		}
+		if st == nil {
+			st = &JobState{Name: j.Name, Spec: j.Schedule.Expr, NextRun: j.Schedule.Next(now)}
+			s.save(st)
+			continue
+		}
+		if st.Spec != j.Schedule.Expr {
+			// schedule changed in code since the job was last scheduled
+			from := st.LastRun
+			if from.IsZero() {
+				from = now
+			}
+			st.Spec, st.NextRun = j.Schedule.Expr, j.Schedule.Next(from)
+		}
+		for runs := 0; runs < MAX_CATCHUP; runs++ {
+			if st.NextRun.IsZero() || st.NextRun.After(now) {
+				break
+			}
+			due := st.Due
+			if due.IsZero() {
+				due = st.NextRun
+				if !j.CatchUp {
+					due = lastDue(j.Schedule, st.NextRun, now)
+				}
+			}
+			log.WithFields(log.Fields{
+				"job": j.Name,
+				"due": due,
+			}).Info("running scheduled job")
+			if err := runJob(j, due); err != nil {
+				log.WithFields(log.Fields{
+					"job": j.Name,
+					"err": err,
+				}).Error("scheduled job failed, will retry")
+				st.LastErr, st.NextRun, st.Due = err.Error(), now.Add(RETRY_AFTER), due
+				break
+			}
+			next := j.Schedule.Next(now)
+			if j.CatchUp {
+				// next of the runs missed, if any, else the next on schedule
+				next = j.Schedule.Next(due)
+			}
+			st.LastErr, st.LastRun, st.NextRun, st.Due = "", now, next, time.Time{}
+			if !j.CatchUp {
+				break
+			}
+			// saving after each run, so that runs caught up are not run again if the app goes down midway
+			s.save(st)
+		}
+		s.save(st)
+	}
+}
+
+func (s *Scheduler) save(st *JobState) {
+	if err := s.Store.Save(st); err != nil {
+		log.WithFields(log.Fields{
+			"job": st.Name,
+			"err": err,
+		}).Error("failed to save job state")
+	}
+}
+
+// Start : catches up on the missed runs and then runs the due jobs every TICK, till cancelled
+// blocking call, run this as a go routine
+func (s *Scheduler) Start(cancel chan bool) {
+	s.RunDue(time.Now())
+	ticker := time.NewTicker(TICK)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.RunDue(now)
+		case <-cancel:
+			log.Warn("Closing down scheduler")
+			return
+		}
+	}
+}
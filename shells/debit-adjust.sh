@@ -1,4 +0,0 @@
-#! /bin/sh
-# to get done all the routine chores 
-# curl 'http://localhost:3333/debits/adjust' 
-echo  "test: now adjusting the debits for the day.."
\ No newline at end of file
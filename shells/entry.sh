@@ -3,13 +3,10 @@
 usage() { echo "Usage: $0 [-v <true/false>] [-f <true/false>] [-s <true/false>]" 1>&2; exit 1; }
 _term(){
     echo "shutting down the application container"
-    /usr/sbin/crond stop
     kill -TERM "$child" 2>/dev/null
 }
 
 trap _term SIGTERM #so as to pass it down
-echo "starting cron deamon..."
-/usr/sbin/crond -f -l 8&
 
 
 # getting all the command line arguments 
@@ -41,4 +38,3 @@ echo "now booting the botmincock application.."
 # waiting for seller pro application 
 child=$!
 wait "$child"
-/usr/sbin/crond stop # if the go app container exits gracefully without any user interruption 
\ No newline at end of file
@@ -1,2 +0,0 @@
-#! /bin/sh
-echo "Now sending the poll to get estimates for the next month"